@@ -0,0 +1,258 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: flowyexec.proto
+
+package api
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	FlowyExec_SubmitWorkflow_FullMethodName = "/flowyexec.v1.FlowyExec/SubmitWorkflow"
+	FlowyExec_GetRun_FullMethodName         = "/flowyexec.v1.FlowyExec/GetRun"
+	FlowyExec_WatchEvents_FullMethodName    = "/flowyexec.v1.FlowyExec/WatchEvents"
+	FlowyExec_CancelRun_FullMethodName      = "/flowyexec.v1.FlowyExec/CancelRun"
+)
+
+// FlowyExecClient is the client API for FlowyExec service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type FlowyExecClient interface {
+	// SubmitWorkflow starts a run of a workflow, which waits while
+	// the maximum number of runs are running.
+	SubmitWorkflow(ctx context.Context, in *SubmitWorkflowRequest, opts ...grpc.CallOption) (*Run, error)
+	// GetRun returns the status of a run and the results of its jobs.
+	GetRun(ctx context.Context, in *GetRunRequest, opts ...grpc.CallOption) (*Run, error)
+	// WatchEvents streams the events of a run until the run finishes.
+	WatchEvents(ctx context.Context, in *WatchEventsRequest, opts ...grpc.CallOption) (FlowyExec_WatchEventsClient, error)
+	// CancelRun cancels a run which has not finished.
+	CancelRun(ctx context.Context, in *CancelRunRequest, opts ...grpc.CallOption) (*Run, error)
+}
+
+type flowyExecClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewFlowyExecClient(cc grpc.ClientConnInterface) FlowyExecClient {
+	return &flowyExecClient{cc}
+}
+
+func (c *flowyExecClient) SubmitWorkflow(ctx context.Context, in *SubmitWorkflowRequest, opts ...grpc.CallOption) (*Run, error) {
+	out := new(Run)
+	err := c.cc.Invoke(ctx, FlowyExec_SubmitWorkflow_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *flowyExecClient) GetRun(ctx context.Context, in *GetRunRequest, opts ...grpc.CallOption) (*Run, error) {
+	out := new(Run)
+	err := c.cc.Invoke(ctx, FlowyExec_GetRun_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *flowyExecClient) WatchEvents(ctx context.Context, in *WatchEventsRequest, opts ...grpc.CallOption) (FlowyExec_WatchEventsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &FlowyExec_ServiceDesc.Streams[0], FlowyExec_WatchEvents_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &flowyExecWatchEventsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type FlowyExec_WatchEventsClient interface {
+	Recv() (*Event, error)
+	grpc.ClientStream
+}
+
+type flowyExecWatchEventsClient struct {
+	grpc.ClientStream
+}
+
+func (x *flowyExecWatchEventsClient) Recv() (*Event, error) {
+	m := new(Event)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *flowyExecClient) CancelRun(ctx context.Context, in *CancelRunRequest, opts ...grpc.CallOption) (*Run, error) {
+	out := new(Run)
+	err := c.cc.Invoke(ctx, FlowyExec_CancelRun_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// FlowyExecServer is the server API for FlowyExec service.
+// All implementations must embed UnimplementedFlowyExecServer
+// for forward compatibility
+type FlowyExecServer interface {
+	// SubmitWorkflow starts a run of a workflow, which waits while
+	// the maximum number of runs are running.
+	SubmitWorkflow(context.Context, *SubmitWorkflowRequest) (*Run, error)
+	// GetRun returns the status of a run and the results of its jobs.
+	GetRun(context.Context, *GetRunRequest) (*Run, error)
+	// WatchEvents streams the events of a run until the run finishes.
+	WatchEvents(*WatchEventsRequest, FlowyExec_WatchEventsServer) error
+	// CancelRun cancels a run which has not finished.
+	CancelRun(context.Context, *CancelRunRequest) (*Run, error)
+	mustEmbedUnimplementedFlowyExecServer()
+}
+
+// UnimplementedFlowyExecServer must be embedded to have forward compatible implementations.
+type UnimplementedFlowyExecServer struct {
+}
+
+func (UnimplementedFlowyExecServer) SubmitWorkflow(context.Context, *SubmitWorkflowRequest) (*Run, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SubmitWorkflow not implemented")
+}
+func (UnimplementedFlowyExecServer) GetRun(context.Context, *GetRunRequest) (*Run, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetRun not implemented")
+}
+func (UnimplementedFlowyExecServer) WatchEvents(*WatchEventsRequest, FlowyExec_WatchEventsServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchEvents not implemented")
+}
+func (UnimplementedFlowyExecServer) CancelRun(context.Context, *CancelRunRequest) (*Run, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CancelRun not implemented")
+}
+func (UnimplementedFlowyExecServer) mustEmbedUnimplementedFlowyExecServer() {}
+
+// UnsafeFlowyExecServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to FlowyExecServer will
+// result in compilation errors.
+type UnsafeFlowyExecServer interface {
+	mustEmbedUnimplementedFlowyExecServer()
+}
+
+func RegisterFlowyExecServer(s grpc.ServiceRegistrar, srv FlowyExecServer) {
+	s.RegisterService(&FlowyExec_ServiceDesc, srv)
+}
+
+func _FlowyExec_SubmitWorkflow_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SubmitWorkflowRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FlowyExecServer).SubmitWorkflow(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FlowyExec_SubmitWorkflow_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FlowyExecServer).SubmitWorkflow(ctx, req.(*SubmitWorkflowRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FlowyExec_GetRun_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRunRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FlowyExecServer).GetRun(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FlowyExec_GetRun_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FlowyExecServer).GetRun(ctx, req.(*GetRunRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FlowyExec_WatchEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchEventsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(FlowyExecServer).WatchEvents(m, &flowyExecWatchEventsServer{stream})
+}
+
+type FlowyExec_WatchEventsServer interface {
+	Send(*Event) error
+	grpc.ServerStream
+}
+
+type flowyExecWatchEventsServer struct {
+	grpc.ServerStream
+}
+
+func (x *flowyExecWatchEventsServer) Send(m *Event) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _FlowyExec_CancelRun_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CancelRunRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FlowyExecServer).CancelRun(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FlowyExec_CancelRun_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FlowyExecServer).CancelRun(ctx, req.(*CancelRunRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// FlowyExec_ServiceDesc is the grpc.ServiceDesc for FlowyExec service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var FlowyExec_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "flowyexec.v1.FlowyExec",
+	HandlerType: (*FlowyExecServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "SubmitWorkflow",
+			Handler:    _FlowyExec_SubmitWorkflow_Handler,
+		},
+		{
+			MethodName: "GetRun",
+			Handler:    _FlowyExec_GetRun_Handler,
+		},
+		{
+			MethodName: "CancelRun",
+			Handler:    _FlowyExec_CancelRun_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchEvents",
+			Handler:       _FlowyExec_WatchEvents_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "flowyexec.proto",
+}
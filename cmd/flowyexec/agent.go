@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/bioflowy/flowy-exec/workflow"
+	"github.com/sirupsen/logrus"
+)
+
+// agentTokenEnv is the environment variable of the token shared by the agents
+// and the hosts placing jobs on them, which the agents require in every request.
+const agentTokenEnv = "FLOWYEXEC_AGENT_TOKEN"
+
+// agent runs the jobs placed on the agent by the workflows executed on other hosts.
+// It listens only on localhost by default, since it runs any command of the
+// workflows sent with its token; -addr :7070 accepts the other hosts.
+func agent(args []string) {
+	flags := flag.NewFlagSet("agent", flag.ExitOnError)
+	addr := flags.String("addr", "127.0.0.1:7070", "address the agent listens on for workflows and pipes, e.g. :7070 for every interface")
+	dir := flags.String("dir", "agent", "directory where the jobs of the workflows run")
+	otlpEndpoint := otlpEndpointFlag(flags)
+	logOptions := logFlags(flags)
+	flags.Parse(args)
+	logger := newLogger(logOptions)
+	// the traces are exported in batches while the process runs
+	startTracing(*otlpEndpoint, logger)
+	token := os.Getenv(agentTokenEnv)
+	if token == "" {
+		log.Fatalf("the token of the agent is required in %s", agentTokenEnv)
+	}
+	a, err := workflow.NewAgent(*addr, *dir, token)
+	if err != nil {
+		log.Fatal(err)
+	}
+	a.Logger = logger
+	logger.WithFields(logrus.Fields{"addr": a.Addr()}).Info("Start Agent")
+	log.Fatal(a.Serve())
+}
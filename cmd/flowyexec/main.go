@@ -1,35 +1,70 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"log"
+	"net/http"
 	"os"
+	"path/filepath"
 
 	"github.com/bioflowy/flowy-exec/workflow"
 	"github.com/sirupsen/logrus"
 )
 
 func main() {
-	formatter := &logrus.JSONFormatter{}
-	formatter.DisableTimestamp = true
-	logrus.SetFormatter(formatter)
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		serve(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "agent" {
+		agent(os.Args[2:])
+		return
+	}
 	results := flag.String("results", "results.json", "results JSON File path")
+	inputs := flag.String("inputs", "", "job order YAML or JSON file of a CWL document")
+	outdir := flag.String("outdir", "", "directory or s3:// prefix where the outputs of a CWL document are written")
+	metricsAddr := flag.String("metrics-addr", "", "address serving the metrics on /metrics while the workflow runs")
+	jobLogs := flag.Bool("job-logs", false, "write the log lines and the output of each job to <jobId>.log")
+	progress := flag.Bool("progress", true, "show the progress of the jobs on stdout, redrawn in place if it is a terminal")
+	otlpEndpoint := otlpEndpointFlag(flag.CommandLine)
+	logOptions := logFlags(flag.CommandLine)
 	flag.Parse()
-	args := flag.Args()
-	f, err := os.OpenFile(args[0], os.O_RDONLY, 0)
-	if err != nil {
-		log.Fatal(err)
-		os.Exit(1)
-		return
+	logger := newLogger(logOptions)
+	stopTracing := startTracing(*otlpEndpoint, logger)
+	if *metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", workflow.MetricsHandler())
+		go func() {
+			log.Fatal(http.ListenAndServe(*metricsAddr, mux))
+		}()
 	}
-	wf, err := workflow.LoadWorkflow(f)
+	args := flag.Args()
+	wf, err := loadWorkflow(args[0], *inputs, *outdir)
 	if err != nil {
 		log.Fatal(err)
 		return
 	}
+	wf.Logger = logger
+	wf.JobLogs = *jobLogs
+	wf.AgentToken = os.Getenv(agentTokenEnv)
 	status_ch := make(chan workflow.Event, 10)
+	done := make(chan struct{})
+	if *progress {
+		wf.JobEvents = true
+		wf.PipeEvents = true
+		view := newProgressView(os.Stdout, wf.GetResults())
+		go func() {
+			defer close(done)
+			view.run(status_ch)
+		}()
+	} else {
+		close(done)
+	}
 	wr := wf.Execute(status_ch)
+	<-done
+	stopTracing()
 	b, err := json.Marshal(wr)
 	if err != nil {
 		log.Fatal(err)
@@ -44,3 +79,62 @@ func main() {
 	rf.Write(b)
 
 }
+
+// otlpEndpointFlag defines the flag of the OTLP collector receiving the traces,
+// which defaults to the standard environment variable of OpenTelemetry.
+func otlpEndpointFlag(flags *flag.FlagSet) *string {
+	return flags.String("otlp-endpoint", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"),
+		"URL of the OTLP collector receiving the traces over gRPC, e.g. http://localhost:4317")
+}
+
+// startTracing exports the traces of the workflows to the OTLP collector
+// at endpoint unless it is empty. The returned function flushes the traces.
+func startTracing(endpoint string, logger *logrus.Logger) func() {
+	if endpoint == "" {
+		return func() {}
+	}
+	shutdown, err := workflow.StartTracing(context.Background(), endpoint)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return func() {
+		if err := shutdown(context.Background()); err != nil {
+			logger.WithError(err).Warn("Cannot export traces")
+		}
+	}
+}
+
+// logFlags defines the flags configuring the logger.
+func logFlags(flags *flag.FlagSet) *workflow.LogOptions {
+	options := &workflow.LogOptions{}
+	flags.StringVar(&options.Level, "log-level", "info", "minimum level of the log lines: trace, debug, info, warn or error")
+	flags.StringVar(&options.Format, "log-format", "json", "format of the log lines: json or text")
+	flags.StringVar(&options.File, "log-file", "", "file the log lines are appended to instead of stderr")
+	return options
+}
+
+// newLogger creates the logger configured by the flags.
+func newLogger(options *workflow.LogOptions) *logrus.Logger {
+	logger, err := workflow.NewLogger(*options)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return logger
+}
+
+// loadWorkflow loads a workflow file, or converts a CWL document
+// with the extension .cwl to a workflow.
+func loadWorkflow(path string, inputs string, outdir string) (*workflow.Workflow, error) {
+	if filepath.Ext(path) != ".cwl" {
+		return workflow.LoadWorkflowFile(path)
+	}
+	options := &workflow.CwlOptions{Outdir: outdir}
+	if inputs != "" {
+		values, err := workflow.LoadCwlInputs(inputs)
+		if err != nil {
+			return nil, err
+		}
+		options.Inputs = values
+	}
+	return workflow.LoadCwlFile(path, options)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bioflowy/flowy-exec/workflow"
+)
+
+// progressView shows the progress of the jobs of a workflow from the events
+// of Execute. On a terminal it redraws one line per job; otherwise it prints
+// the lines of the running jobs periodically and the lines of all the jobs
+// at the end.
+type progressView struct {
+	out      io.Writer
+	terminal bool
+	jobIds   []string
+	jobs     map[string]*jobProgress
+	// drawn is the number of lines drawn on the terminal.
+	drawn int
+}
+
+// jobProgress is the progress of a job. in and out are the bytes of its
+// pipes by key, and size and rate are the known size and the throughput
+// of the data it produces, e.g. of a downloaded object.
+type jobProgress struct {
+	status workflow.JobStatus
+	start  time.Time
+	end    time.Time
+	in     map[string]int64
+	out    map[string]int64
+	read   int64
+	size   int64
+	rate   float64
+}
+
+const (
+	terminalInterval = 500 * time.Millisecond
+	plainInterval    = 10 * time.Second
+)
+
+func newProgressView(out *os.File, results []*workflow.JobResult) *progressView {
+	v := &progressView{out: out, terminal: isTerminal(out), jobs: map[string]*jobProgress{}}
+	for _, result := range results {
+		v.jobIds = append(v.jobIds, result.JobId)
+		v.jobs[result.JobId] = &jobProgress{status: result.Status, in: map[string]int64{}, out: map[string]int64{}}
+	}
+	return v
+}
+
+// isTerminal reports whether f is a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// run shows the progress until the WorkflowEvent, which is the last event.
+func (v *progressView) run(events <-chan workflow.Event) {
+	interval := plainInterval
+	if v.terminal {
+		interval = terminalInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case event := <-events:
+			switch e := event.(type) {
+			case *workflow.JobEvent:
+				v.updateJob(e)
+			case *workflow.PipeEvent:
+				v.updatePipe(e.Pipe)
+			case *workflow.WorkflowEvent:
+				if v.terminal {
+					v.draw(time.Now())
+				} else {
+					v.print(time.Now(), true)
+				}
+				return
+			}
+		case now := <-ticker.C:
+			if v.terminal {
+				v.draw(now)
+			} else {
+				v.print(now, false)
+			}
+		}
+	}
+}
+
+func (v *progressView) updateJob(e *workflow.JobEvent) {
+	job, ok := v.jobs[e.JobId]
+	if !ok {
+		return
+	}
+	job.status = e.Status
+	if e.Status == workflow.Running {
+		job.start = e.Occured
+	} else if e.Status.IsFinished() {
+		job.end = e.Occured
+	}
+}
+
+func (v *progressView) updatePipe(pipe *workflow.PipeResult) {
+	if job, ok := v.jobs[pipe.Producer]; ok {
+		job.out[pipe.Key] = pipe.BytesRead
+		if pipe.Size > 0 {
+			job.read, job.size, job.rate = pipe.BytesRead, pipe.Size, pipe.Throughput
+		}
+	}
+	for _, consumer := range pipe.Consumers {
+		if job, ok := v.jobs[consumer.JobId]; ok {
+			job.in[pipe.Key] = consumer.BytesWritten
+		}
+	}
+}
+
+// draw redraws the lines of all the jobs over the lines drawn before.
+func (v *progressView) draw(now time.Time) {
+	var b strings.Builder
+	if v.drawn > 0 {
+		fmt.Fprintf(&b, "\x1b[%dA", v.drawn)
+	}
+	for _, jobId := range v.jobIds {
+		fmt.Fprintf(&b, "\x1b[2K%s\n", v.line(jobId, now))
+	}
+	v.drawn = len(v.jobIds)
+	io.WriteString(v.out, b.String())
+}
+
+// print prints the lines of the running jobs, or of all the jobs if all is set.
+func (v *progressView) print(now time.Time, all bool) {
+	for _, jobId := range v.jobIds {
+		if all || v.jobs[jobId].status == workflow.Running {
+			fmt.Fprintf(v.out, "%s %s\n", now.Format(time.RFC3339), v.line(jobId, now))
+		}
+	}
+}
+
+// line returns the line of a job: its status, elapsed time, the bytes
+// of its pipes, and the rate and the percent of the data of known size.
+func (v *progressView) line(jobId string, now time.Time) string {
+	job := v.jobs[jobId]
+	elapsed := "-"
+	if !job.start.IsZero() {
+		end := job.end
+		if end.IsZero() {
+			end = now
+		}
+		elapsed = end.Sub(job.start).Truncate(time.Second).String()
+	}
+	line := fmt.Sprintf("%-20s %-9s %8s  in %9s  out %9s", jobId, job.status, elapsed, formatBytes(sum(job.in)), formatBytes(sum(job.out)))
+	if job.size > 0 {
+		line += fmt.Sprintf("  %9s/s %3d%%", formatBytes(int64(job.rate)), job.read*100/job.size)
+	}
+	return line
+}
+
+func sum(bytes map[string]int64) int64 {
+	var total int64
+	for _, n := range bytes {
+		total += n
+	}
+	return total
+}
+
+// formatBytes formats bytes in the binary units.
+func formatBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%dB", bytes)
+	}
+	value := float64(bytes) / unit
+	for _, suffix := range []string{"KiB", "MiB", "GiB", "TiB"} {
+		if value < unit {
+			return fmt.Sprintf("%.1f%s", value, suffix)
+		}
+		value /= unit
+	}
+	return fmt.Sprintf("%.1fPiB", value)
+}
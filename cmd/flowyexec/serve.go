@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/bioflowy/flowy-exec/server"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+)
+
+// serve runs the REST API and the gRPC API servers of flowyexec serve.
+// Either of them is disabled if its address is empty.
+// The REST API server listens only on localhost by default, since it runs
+// any submitted workflow without authentication; -addr :8080 exposes it
+// to the other hosts.
+func serve(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", "127.0.0.1:8080", "address the REST API server listens on, e.g. :8080 for every interface")
+	grpcAddr := flags.String("grpc-addr", "", "address the gRPC API server listens on")
+	maxRuns := flags.Int("max-runs", 4, "maximum number of runs running at the same time")
+	history := flags.String("history", "runs", "directory where the finished runs are saved")
+	otlpEndpoint := otlpEndpointFlag(flags)
+	logOptions := logFlags(flags)
+	flags.Parse(args)
+	logger := newLogger(logOptions)
+	// the traces are exported in batches while the process runs
+	startTracing(*otlpEndpoint, logger)
+	if *addr == "" && *grpcAddr == "" {
+		log.Fatal("either -addr or -grpc-addr is required")
+	}
+	s, err := server.NewServer(*maxRuns, *history)
+	if err != nil {
+		log.Fatal(err)
+	}
+	s.Logger = logger
+	s.AgentToken = os.Getenv(agentTokenEnv)
+	errs := make(chan error, 2)
+	if *grpcAddr != "" {
+		listener, err := net.Listen("tcp", *grpcAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		g := grpc.NewServer()
+		s.RegisterGRPC(g)
+		logger.WithFields(logrus.Fields{"addr": *grpcAddr}).Info("Start gRPC Server")
+		go func() { errs <- g.Serve(listener) }()
+	}
+	if *addr != "" {
+		logger.WithFields(logrus.Fields{"addr": *addr}).Info("Start Server")
+		go func() { errs <- http.ListenAndServe(*addr, s.Handler()) }()
+	}
+	log.Fatal(<-errs)
+}
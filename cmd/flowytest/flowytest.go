@@ -19,17 +19,13 @@ func main() {
 	logrus.SetFormatter(formatter)
 	flag.Parse()
 	args := flag.Args()
-	f, err := os.OpenFile(args[0], os.O_RDONLY, 0)
-	if err != nil {
-		log.Fatal(err)
-		os.Exit(1)
-		return
-	}
-	wf, err := workflow.LoadWorkflow(f)
+	wf, err := workflow.LoadWorkflowFile(args[0])
 	if err != nil {
 		log.Fatal(err)
 		return
 	}
+	// a fixed run id keeps the log lines comparable with the expected ones
+	wf.RunId = "flowytest"
 	go func() {
 		time.Sleep(time.Duration(*timeout) * time.Second)
 		log.Fatal("timeout has occurred")
@@ -38,6 +34,8 @@ func main() {
 	wr := wf.Execute(status_ch)
 	wr.Start = nil
 	wr.End = nil
+	// the accounting of the pipes depends on the timing of the jobs
+	wr.Pipes = nil
 	for _, r := range wr.Results {
 		r.Start = nil
 		r.End = nil
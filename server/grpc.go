@@ -0,0 +1,161 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/bioflowy/flowy-exec/api"
+	"github.com/bioflowy/flowy-exec/workflow"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcService is the gRPC control API of a Server.
+type grpcService struct {
+	api.UnimplementedFlowyExecServer
+	server *Server
+}
+
+// RegisterGRPC registers the gRPC control API of the server to g.
+func (s *Server) RegisterGRPC(g *grpc.Server) {
+	api.RegisterFlowyExecServer(g, &grpcService{server: s})
+}
+
+func (g *grpcService) SubmitWorkflow(ctx context.Context, req *api.SubmitWorkflowRequest) (*api.Run, error) {
+	if req.Workflow == nil {
+		return nil, status.Error(codes.InvalidArgument, "workflow is required")
+	}
+	// the workflow is loaded from its JSON form as a workflow file,
+	// whose fields are matched regardless of case
+	document, err := protojson.Marshal(req.Workflow)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	run, err := g.server.Submit(&SubmitRequest{Workflow: document, Parameters: req.Parameters.AsMap()})
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return toRun(run), nil
+}
+
+func (g *grpcService) GetRun(ctx context.Context, req *api.GetRunRequest) (*api.Run, error) {
+	run, err := g.server.Get(req.RunId)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return toRun(run), nil
+}
+
+func (g *grpcService) WatchEvents(req *api.WatchEventsRequest, stream api.FlowyExec_WatchEventsServer) error {
+	err := g.server.Watch(stream.Context(), req.RunId, int(req.From), func(index int, event *RunEvent) error {
+		return stream.Send(toEvent(index, event))
+	})
+	if err != nil {
+		return toStatus(err)
+	}
+	return nil
+}
+
+func (g *grpcService) CancelRun(ctx context.Context, req *api.CancelRunRequest) (*api.Run, error) {
+	canceled, err := g.server.Cancel(req.RunId)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	if !canceled {
+		return nil, status.Errorf(codes.FailedPrecondition, "run %s has finished", req.RunId)
+	}
+	return g.GetRun(ctx, &api.GetRunRequest{RunId: req.RunId})
+}
+
+func toStatus(err error) error {
+	switch {
+	case errors.Is(err, errRunNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	}
+	return err
+}
+
+// toJobStatus converts a JobStatus, whose values are the same in the API.
+func toJobStatus(s workflow.JobStatus) api.JobStatus {
+	return api.JobStatus(s)
+}
+
+func toTimestamp(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
+
+func toRun(run *Run) *api.Run {
+	results := make([]*api.JobResult, 0, len(run.Results))
+	for _, result := range run.Results {
+		results = append(results, toJobResult(result))
+	}
+	return &api.Run{
+		RunId:     run.RunId,
+		Name:      run.Name,
+		Status:    toJobStatus(run.Status),
+		Submitted: timestamppb.New(run.Submitted),
+		Start:     toTimestamp(run.Start),
+		End:       toTimestamp(run.End),
+		Message:   run.Message,
+		Results:   results,
+	}
+}
+
+func toJobResult(result *workflow.JobResult) *api.JobResult {
+	r := &api.JobResult{
+		JobId:    result.JobId,
+		Status:   toJobStatus(result.Status),
+		Start:    toTimestamp(result.Start),
+		End:      toTimestamp(result.End),
+		ExitCode: int32(result.ExitCode),
+		Message:  result.Message,
+		Signal:   result.Signal,
+	}
+	for _, attempt := range result.Attempts {
+		r.Attempts = append(r.Attempts, &api.JobAttempt{
+			Status:   toJobStatus(attempt.Status),
+			Start:    toTimestamp(attempt.Start),
+			End:      toTimestamp(attempt.End),
+			ExitCode: int32(attempt.ExitCode),
+			Message:  attempt.Message,
+			Signal:   attempt.Signal,
+		})
+	}
+	for _, job := range result.Jobs {
+		r.Jobs = append(r.Jobs, toJobResult(job))
+	}
+	return r
+}
+
+func toEvent(index int, event *RunEvent) *api.Event {
+	e := &api.Event{Index: int32(index)}
+	switch event.Type {
+	case "job":
+		e.Event = &api.Event_Job{Job: &api.JobEvent{
+			JobId:    event.JobId,
+			Status:   toJobStatus(event.Status),
+			Occurred: timestamppb.New(event.Time),
+			ExitCode: int32(event.ExitCode),
+			Message:  event.Message,
+		}}
+	default:
+		e.Event = &api.Event_Workflow{Workflow: &api.WorkflowEvent{
+			Status:   toJobStatus(event.Status),
+			Occurred: timestamppb.New(event.Time),
+			ExitCode: int32(event.ExitCode),
+			Message:  event.Message,
+		}}
+	}
+	return e
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bioflowy/flowy-exec/api"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func startGRPC(t *testing.T, s *Server) api.FlowyExecClient {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	g := grpc.NewServer()
+	s.RegisterGRPC(g)
+	go g.Serve(listener)
+	t.Cleanup(g.Stop)
+	conn, err := grpc.NewClient(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return api.NewFlowyExecClient(conn)
+}
+
+func TestGRPCSubmit(t *testing.T) {
+	s, err := NewServer(1, "")
+	assert.NoError(t, err)
+	client := startGRPC(t, s)
+	ctx := context.Background()
+	defaults, err := structpb.NewStruct(map[string]interface{}{"message": "hello"})
+	assert.NoError(t, err)
+	params, err := structpb.NewStruct(map[string]interface{}{"message": "bye"})
+	assert.NoError(t, err)
+	run, err := client.SubmitWorkflow(ctx, &api.SubmitWorkflowRequest{
+		Workflow: &api.WorkflowDto{
+			Name:       "echo",
+			Parameters: defaults,
+			Jobs: []*api.JobDto{
+				{JobId: "literal", Type: "Literal", Content: "${message}\n", WriteTo: "TEXT"},
+				{
+					JobId:   "check",
+					Inputs:  []*api.JobInput{{ReadFrom: "TEXT", Path: "grpc_echo"}},
+					Command: []string{"sh", "-c", `test "$(cat grpc_echo)" = ${message}`},
+				},
+			},
+		},
+		Parameters: params,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "echo", run.Name)
+	stream, err := client.WatchEvents(ctx, &api.WatchEventsRequest{RunId: run.RunId})
+	assert.NoError(t, err)
+	events := []*api.Event{}
+	for {
+		event, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		events = append(events, event)
+	}
+	statuses := map[string][]api.JobStatus{}
+	for idx, event := range events[:len(events)-1] {
+		assert.Equal(t, int32(idx), event.Index)
+		statuses[event.GetJob().JobId] = append(statuses[event.GetJob().JobId], event.GetJob().Status)
+	}
+	for _, jobId := range []string{"literal", "check"} {
+		assert.Equal(t, []api.JobStatus{api.JobStatus_JOB_STATUS_RUNNING, api.JobStatus_JOB_STATUS_SUCCESSED}, statuses[jobId], jobId)
+	}
+	assert.Equal(t, api.JobStatus_JOB_STATUS_SUCCESSED, events[len(events)-1].GetWorkflow().Status)
+	s.Wait()
+	run, err = client.GetRun(ctx, &api.GetRunRequest{RunId: run.RunId})
+	assert.NoError(t, err)
+	assert.Equal(t, api.JobStatus_JOB_STATUS_SUCCESSED, run.Status)
+	assert.Len(t, run.Results, 2)
+	_, err = client.CancelRun(ctx, &api.CancelRunRequest{RunId: run.RunId})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+func TestGRPCSubmitLevelZero(t *testing.T) {
+	s, err := NewServer(1, "")
+	assert.NoError(t, err)
+	client := startGRPC(t, s)
+	out := filepath.Join(t.TempDir(), "stored.gz")
+	content := strings.Repeat("a", 1000)
+	run, err := client.SubmitWorkflow(context.Background(), &api.SubmitWorkflowRequest{
+		Workflow: &api.WorkflowDto{
+			Name: "level",
+			Jobs: []*api.JobDto{
+				{JobId: "literal", Type: "Literal", Content: content, WriteTo: "TEXT"},
+				{JobId: "gzip", Type: "Transform", Codec: "gzip", Level: proto.Int32(0), ReadFrom: "TEXT", WriteTo: "GZ"},
+				{JobId: "sink", Type: "File", ReadFrom: "GZ", Path: out},
+			},
+		},
+	})
+	assert.NoError(t, err)
+	s.Wait()
+	run, err = client.GetRun(context.Background(), &api.GetRunRequest{RunId: run.RunId})
+	assert.NoError(t, err)
+	assert.Equal(t, api.JobStatus_JOB_STATUS_SUCCESSED, run.Status)
+	// level 0 stores the content without compressing it
+	info, err := os.Stat(out)
+	assert.NoError(t, err)
+	assert.Greater(t, info.Size(), int64(len(content)))
+}
+
+func TestGRPCErrors(t *testing.T) {
+	s, err := NewServer(1, "")
+	assert.NoError(t, err)
+	client := startGRPC(t, s)
+	ctx := context.Background()
+	_, err = client.SubmitWorkflow(ctx, &api.SubmitWorkflowRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = client.SubmitWorkflow(ctx, &api.SubmitWorkflowRequest{Workflow: &api.WorkflowDto{
+		Jobs: []*api.JobDto{{JobId: "a"}, {JobId: "a"}},
+	}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = client.GetRun(ctx, &api.GetRunRequest{RunId: "undefined"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = client.CancelRun(ctx, &api.CancelRunRequest{RunId: "undefined"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	stream, err := client.WatchEvents(ctx, &api.WatchEventsRequest{RunId: "undefined"})
+	assert.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
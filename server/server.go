@@ -0,0 +1,557 @@
+// Package server runs workflows submitted through a REST API.
+//
+//	POST   /runs             starts a run of the workflow in the request
+//	GET    /runs             lists the runs
+//	GET    /runs/{id}        returns the status of a run and the results of its jobs
+//	GET    /runs/{id}/events streams the events of a run as Server-Sent Events
+//	DELETE /runs/{id}        cancels a run
+//	GET    /metrics          serves the metrics of the jobs in the Prometheus text format
+//
+// The runs wait while the maximum number of runs are running, and the
+// finished runs are saved to the history directory, from which they are
+// loaded when the server starts again.
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bioflowy/flowy-exec/workflow"
+	"github.com/sirupsen/logrus"
+)
+
+// SubmitRequest is the body of POST /runs.
+type SubmitRequest struct {
+	// Workflow is the workflow document, which is the content of a workflow file.
+	Workflow json.RawMessage
+	// Parameters override the parameters of the workflow.
+	Parameters map[string]interface{}
+}
+
+// Run is the state of a run of a workflow.
+// Status is Created while the run waits for other runs to finish.
+type Run struct {
+	RunId     string
+	Name      string
+	Status    workflow.JobStatus
+	Submitted time.Time
+	Start     *time.Time `json:",omitempty"`
+	End       *time.Time `json:",omitempty"`
+	Message   string     `json:",omitempty"`
+	Results   []*workflow.JobResult
+}
+
+// RunEvent is an event of a run. Type is "job" for the events of the jobs,
+// and "workflow" for the last event of the run.
+type RunEvent struct {
+	Type     string
+	Time     time.Time
+	JobId    string `json:",omitempty"`
+	Status   workflow.JobStatus
+	ExitCode int
+	Message  string `json:",omitempty"`
+}
+
+// runRecord is a run saved to the history directory.
+type runRecord struct {
+	Run    *Run
+	Events []*RunEvent
+}
+
+// runState holds a run and the workflow executed by it.
+// changed is closed and replaced when the run changes,
+// to wake up the clients following the events.
+type runState struct {
+	mu       sync.Mutex
+	run      Run
+	events   []*RunEvent
+	workflow *workflow.Workflow
+	finished bool
+	canceled chan struct{}
+	changed  chan struct{}
+}
+
+// Server executes the submitted workflows in the process.
+type Server struct {
+	historyDir string
+	// runsDir is the directory under which each run has
+	// the directory named after its run id.
+	runsDir string
+	slots   chan struct{}
+	mu      sync.Mutex
+	runs    map[string]*runState
+	wg      sync.WaitGroup
+	// AgentToken is the token authenticating the runs
+	// to the agents running the jobs placed on them.
+	AgentToken string
+	// Logger is the logger of the server and its runs,
+	// which is the standard logger of logrus if it is nil.
+	Logger *logrus.Logger
+}
+
+func (s *Server) logger() *logrus.Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+	return logrus.StandardLogger()
+}
+
+// NewServer creates a server running up to maxRuns runs at the same time,
+// which saves the finished runs to historyDir unless it is empty.
+func NewServer(maxRuns int, historyDir string) (*Server, error) {
+	if maxRuns <= 0 {
+		return nil, fmt.Errorf("maximum number of runs must be positive: %d", maxRuns)
+	}
+	s := &Server{
+		historyDir: historyDir,
+		runsDir:    historyDir,
+		slots:      make(chan struct{}, maxRuns),
+		runs:       map[string]*runState{},
+	}
+	if historyDir == "" {
+		s.runsDir = os.TempDir()
+		return s, nil
+	}
+	if err := os.MkdirAll(historyDir, 0755); err != nil {
+		return nil, err
+	}
+	paths, err := filepath.Glob(filepath.Join(historyDir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var record runRecord
+		if err := json.Unmarshal(data, &record); err != nil || record.Run == nil {
+			s.logger().WithFields(logrus.Fields{"path": path}).WithError(err).Warn("Invalid Run History")
+			continue
+		}
+		s.runs[record.Run.RunId] = &runState{
+			run:      *record.Run,
+			events:   record.Events,
+			finished: true,
+			changed:  make(chan struct{}),
+		}
+	}
+	return s, nil
+}
+
+// Submit starts a run of a workflow, which waits while the maximum number of runs are running.
+func (s *Server) Submit(request *SubmitRequest) (*Run, error) {
+	wf, err := workflow.LoadRunWorkflow(bytes.NewReader(request.Workflow), request.Parameters, s.runsDir)
+	if err != nil {
+		return nil, err
+	}
+	wf.JobEvents = true
+	wf.Logger = s.Logger
+	wf.AgentToken = s.AgentToken
+	state := &runState{
+		run: Run{
+			RunId:     wf.RunId,
+			Name:      wf.Name,
+			Status:    workflow.Created,
+			Submitted: time.Now(),
+			Results:   wf.GetResults(),
+		},
+		workflow: wf,
+		canceled: make(chan struct{}),
+		changed:  make(chan struct{}),
+	}
+	s.mu.Lock()
+	s.runs[wf.RunId] = state
+	s.mu.Unlock()
+	s.logger().WithFields(logrus.Fields{"runId": wf.RunId, "name": wf.Name}).Info("Submit Workflow")
+	s.wg.Add(1)
+	go s.execute(state)
+	return state.snapshot(), nil
+}
+
+// execute executes a run when the number of the running runs is below the maximum.
+func (s *Server) execute(state *runState) {
+	defer s.wg.Done()
+	select {
+	case s.slots <- struct{}{}:
+	case <-state.canceled:
+		s.abortQueued(state)
+		return
+	}
+	defer func() { <-s.slots }()
+	state.mu.Lock()
+	select {
+	case <-state.canceled:
+		state.mu.Unlock()
+		s.abortQueued(state)
+		return
+	default:
+	}
+	start := time.Now()
+	state.run.Status = workflow.Running
+	state.run.Start = &start
+	state.notify()
+	state.mu.Unlock()
+	dir := filepath.Join(s.runsDir, state.run.RunId)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		state.workflow.Cancel()
+		state.finish(workflow.Failed, state.workflow.GetResults(), err.Error())
+		s.logger().WithFields(logrus.Fields{"runId": state.run.RunId, "status": workflow.Failed}).Info("Finished Run")
+		s.save(state)
+		return
+	}
+	// the directory is left if the jobs have written files in it
+	defer os.Remove(dir)
+	events := make(chan workflow.Event, 10)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for event := range events {
+			state.addEvent(event)
+			if _, ok := event.(*workflow.WorkflowEvent); ok {
+				return
+			}
+		}
+	}()
+	result := state.workflow.Execute(events)
+	<-done
+	status, results, message := result.Status, result.Results, ""
+	if results == nil {
+		// the workflow has failed to start with the error in the last event
+		status, results = workflow.Failed, state.workflow.GetResults()
+		state.mu.Lock()
+		message = state.events[len(state.events)-1].Message
+		state.mu.Unlock()
+	}
+	select {
+	case <-state.canceled:
+		if status != workflow.Successed {
+			status, message = workflow.Aborted, "run is canceled"
+		}
+	default:
+	}
+	state.finish(status, results, message)
+	s.logger().WithFields(logrus.Fields{"runId": state.run.RunId, "status": status}).Info("Finished Run")
+	s.save(state)
+}
+
+// abortQueued finishes a run canceled before it starts, whose jobs are aborted.
+func (s *Server) abortQueued(state *runState) {
+	state.workflow.Cancel()
+	state.finish(workflow.Aborted, state.workflow.GetResults(), "run is canceled before it starts")
+	s.logger().WithFields(logrus.Fields{"runId": state.run.RunId, "status": workflow.Aborted}).Info("Finished Run")
+	s.save(state)
+}
+
+// Cancel cancels a run. It returns false if the run has finished.
+func (s *Server) Cancel(runId string) (bool, error) {
+	state, err := s.get(runId)
+	if err != nil {
+		return false, err
+	}
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if state.finished {
+		return false, nil
+	}
+	select {
+	case <-state.canceled:
+		return true, nil
+	default:
+	}
+	close(state.canceled)
+	s.logger().WithFields(logrus.Fields{"runId": runId}).Warn("Cancel Run")
+	if state.run.Status == workflow.Running {
+		go state.workflow.Cancel()
+	}
+	return true, nil
+}
+
+// Get returns the current state of a run.
+func (s *Server) Get(runId string) (*Run, error) {
+	state, err := s.get(runId)
+	if err != nil {
+		return nil, err
+	}
+	return state.snapshot(), nil
+}
+
+// List returns the runs in the order of their submission.
+func (s *Server) List() []*Run {
+	s.mu.Lock()
+	states := make([]*runState, 0, len(s.runs))
+	for _, state := range s.runs {
+		states = append(states, state)
+	}
+	s.mu.Unlock()
+	runs := make([]*Run, 0, len(states))
+	for _, state := range states {
+		runs = append(runs, state.snapshot())
+	}
+	sort.SliceStable(runs, func(i, j int) bool {
+		if runs[i].Submitted.Equal(runs[j].Submitted) {
+			return runs[i].RunId < runs[j].RunId
+		}
+		return runs[i].Submitted.Before(runs[j].Submitted)
+	})
+	return runs
+}
+
+// Watch calls send with the events of a run and their indexes, starting from
+// the index from, until the run finishes. It returns the error of send,
+// or the error of ctx if ctx is done before the run finishes.
+func (s *Server) Watch(ctx context.Context, runId string, from int, send func(index int, event *RunEvent) error) error {
+	state, err := s.get(runId)
+	if err != nil {
+		return err
+	}
+	next := from
+	if next < 0 {
+		next = 0
+	}
+	for {
+		state.mu.Lock()
+		var events []*RunEvent
+		if next < len(state.events) {
+			events = state.events[next:]
+		}
+		finished := state.finished
+		changed := state.changed
+		state.mu.Unlock()
+		for _, event := range events {
+			if err := send(next, event); err != nil {
+				return err
+			}
+			next++
+		}
+		if finished {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Wait waits until all the submitted runs have finished.
+func (s *Server) Wait() {
+	s.wg.Wait()
+}
+
+var errRunNotFound = errors.New("run is not found")
+
+func (s *Server) get(runId string) (*runState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, ok := s.runs[runId]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errRunNotFound, runId)
+	}
+	return state, nil
+}
+
+// save writes a finished run to the history directory.
+func (s *Server) save(state *runState) {
+	if s.historyDir == "" {
+		return
+	}
+	state.mu.Lock()
+	record := runRecord{Run: &state.run, Events: state.events}
+	data, err := json.Marshal(record)
+	state.mu.Unlock()
+	if err == nil {
+		err = os.WriteFile(filepath.Join(s.historyDir, state.run.RunId+".json"), data, 0644)
+	}
+	if err != nil {
+		s.logger().WithFields(logrus.Fields{"runId": state.run.RunId}).WithError(err).Warn("Cannot Save Run History")
+	}
+}
+
+// snapshot returns a copy of the run with the current results of its jobs.
+func (state *runState) snapshot() *Run {
+	state.mu.Lock()
+	run := state.run
+	running := !state.finished && state.workflow != nil
+	state.mu.Unlock()
+	if running {
+		run.Results = state.workflow.GetResults()
+	}
+	return &run
+}
+
+func (state *runState) addEvent(event workflow.Event) {
+	e := &RunEvent{Time: time.Now()}
+	switch ev := event.(type) {
+	case *workflow.JobEvent:
+		e.Type = "job"
+		e.Time = ev.Occured
+		e.JobId = ev.JobId
+		e.Status = ev.Status
+		e.ExitCode = ev.ExitCode
+		e.Message = ev.Message
+	case *workflow.WorkflowEvent:
+		e.Type = "workflow"
+		e.Status = ev.Status
+		e.ExitCode = ev.ExitCode
+		e.Message = ev.Message
+		if ev.ExecError != nil {
+			e.Status = workflow.Failed
+			e.Message = ev.ExecError.Error()
+		}
+	default:
+		return
+	}
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	state.events = append(state.events, e)
+	state.notify()
+}
+
+// finish records the end of a run.
+func (state *runState) finish(status workflow.JobStatus, results []*workflow.JobResult, message string) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	end := time.Now()
+	state.run.Status = status
+	state.run.End = &end
+	state.run.Message = message
+	if results != nil {
+		state.run.Results = results
+	}
+	state.finished = true
+	state.notify()
+}
+
+// notify wakes up the clients waiting for a change of the run.
+// state.mu must be held.
+func (state *runState) notify() {
+	close(state.changed)
+	state.changed = make(chan struct{})
+}
+
+// Handler returns the handler of the REST API.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/runs", s.handleRuns)
+	mux.HandleFunc("/runs/", s.handleRun)
+	mux.Handle("/metrics", workflow.MetricsHandler())
+	return mux
+}
+
+func (s *Server) handleRuns(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, s.List())
+	case http.MethodPost:
+		var request SubmitRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if len(request.Workflow) == 0 {
+			writeError(w, http.StatusBadRequest, errors.New("workflow is required"))
+			return
+		}
+		run, err := s.Submit(&request)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		w.Header().Set("Location", "/runs/"+run.RunId)
+		writeJSON(w, http.StatusCreated, run)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+	}
+}
+
+func (s *Server) handleRun(w http.ResponseWriter, r *http.Request) {
+	path := strings.Split(strings.TrimPrefix(r.URL.Path, "/runs/"), "/")
+	runId := path[0]
+	switch {
+	case len(path) == 1 && r.Method == http.MethodGet:
+		run, err := s.Get(runId)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, run)
+	case len(path) == 1 && r.Method == http.MethodDelete:
+		canceled, err := s.Cancel(runId)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		if !canceled {
+			writeError(w, http.StatusConflict, fmt.Errorf("run %s has finished", runId))
+			return
+		}
+		run, _ := s.Get(runId)
+		writeJSON(w, http.StatusAccepted, run)
+	case len(path) == 2 && path[1] == "events" && r.Method == http.MethodGet:
+		s.streamEvents(w, r, runId)
+	case len(path) <= 2:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("%s is not found", r.URL.Path))
+	}
+}
+
+// streamEvents sends the events of a run as Server-Sent Events until the run
+// finishes. The id of an event is its index, from which a client resumes
+// by the Last-Event-ID header.
+func (s *Server) streamEvents(w http.ResponseWriter, r *http.Request, runId string) {
+	if _, err := s.get(runId); err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
+	from := 0
+	if id, err := strconv.Atoi(r.Header.Get("Last-Event-ID")); err == nil {
+		from = id + 1
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	s.Watch(r.Context(), runId, from, func(index int, event *RunEvent) error {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", index, event.Type, data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		logrus.WithError(err).Warn("Cannot Write Response")
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"Error": err.Error()})
+}
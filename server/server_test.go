@@ -0,0 +1,216 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bioflowy/flowy-exec/workflow"
+	"github.com/stretchr/testify/assert"
+)
+
+const echoWorkflow = `{
+    "name": "echo",
+    "parameters": {"message": "hello"},
+    "jobs": [
+        {"jobId": "literal", "type": "Literal", "content": "${message}\n", "writeTo": "TEXT"},
+        {"jobId": "check", "inputs": [{"readFrom": "TEXT", "path": "server_echo"}],
+         "command": ["sh", "-c", "test \"$(cat server_echo)\" = ${message}"]}
+    ]
+}`
+
+const sleepWorkflow = `{
+    "name": "sleep",
+    "jobs": [
+        {"jobId": "sleep", "command": ["sleep", "10"]}
+    ]
+}`
+
+func submit(t *testing.T, url string, document string, params map[string]interface{}) *Run {
+	body, err := json.Marshal(map[string]interface{}{
+		"workflow":   json.RawMessage(document),
+		"parameters": params,
+	})
+	assert.NoError(t, err)
+	res, err := http.Post(url+"/runs", "application/json", bytes.NewReader(body))
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+	var run Run
+	assert.NoError(t, json.NewDecoder(res.Body).Decode(&run))
+	return &run
+}
+
+func getRun(t *testing.T, url string, runId string) *Run {
+	res, err := http.Get(url + "/runs/" + runId)
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var run Run
+	assert.NoError(t, json.NewDecoder(res.Body).Decode(&run))
+	return &run
+}
+
+func waitStatus(t *testing.T, url string, runId string, status workflow.JobStatus) {
+	for i := 0; i < 500; i++ {
+		if getRun(t, url, runId).Status == status {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("run %s is not %s", runId, status)
+}
+
+// readEvents reads the Server-Sent Events of a run until the stream ends.
+func readEvents(t *testing.T, url string, runId string) []*RunEvent {
+	res, err := http.Get(url + "/runs/" + runId + "/events")
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+	events := []*RunEvent{}
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		if data := strings.TrimPrefix(scanner.Text(), "data: "); data != scanner.Text() {
+			var event RunEvent
+			assert.NoError(t, json.Unmarshal([]byte(data), &event))
+			events = append(events, &event)
+		}
+	}
+	return events
+}
+
+func TestSubmit(t *testing.T) {
+	s, err := NewServer(2, "")
+	assert.NoError(t, err)
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+	run := submit(t, ts.URL, echoWorkflow, map[string]interface{}{"message": "bye"})
+	assert.Equal(t, "echo", run.Name)
+	events := readEvents(t, ts.URL, run.RunId)
+	statuses := map[string][]workflow.JobStatus{}
+	for _, event := range events[:len(events)-1] {
+		assert.Equal(t, "job", event.Type)
+		statuses[event.JobId] = append(statuses[event.JobId], event.Status)
+	}
+	assert.Equal(t, []workflow.JobStatus{workflow.Running, workflow.Successed}, statuses["literal"])
+	assert.Equal(t, []workflow.JobStatus{workflow.Running, workflow.Successed}, statuses["check"])
+	assert.Equal(t, "workflow", events[len(events)-1].Type)
+	assert.Equal(t, workflow.Successed, events[len(events)-1].Status)
+	s.Wait()
+	run = getRun(t, ts.URL, run.RunId)
+	assert.Equal(t, workflow.Successed, run.Status)
+	assert.Len(t, run.Results, 2)
+	for _, result := range run.Results {
+		assert.Equal(t, workflow.Successed, result.Status, result.JobId)
+	}
+}
+
+func TestSubmitConcurrent(t *testing.T) {
+	s, err := NewServer(2, "")
+	assert.NoError(t, err)
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+	// both runs create the FIFO server_echo, which must not be shared
+	runs := []*Run{}
+	for _, message := range []string{"first", "second"} {
+		runs = append(runs, submit(t, ts.URL, echoWorkflow, map[string]interface{}{"message": message}))
+	}
+	s.Wait()
+	for _, run := range runs {
+		run = getRun(t, ts.URL, run.RunId)
+		assert.Equal(t, workflow.Successed, run.Status, run.Message)
+		for _, result := range run.Results {
+			assert.Equal(t, workflow.Successed, result.Status, result.JobId)
+		}
+	}
+}
+
+func TestSubmitInvalid(t *testing.T) {
+	s, err := NewServer(1, "")
+	assert.NoError(t, err)
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+	for _, body := range []string{
+		`{"workflow": {"jobs": [{"jobId": "a"}, {"jobId": "a"}]}}`,
+		`{"workflow": {"jobs": []}, "parameters": {"undefined": 1}}`,
+		`{}`,
+		`not json`,
+	} {
+		res, err := http.Post(ts.URL+"/runs", "application/json", strings.NewReader(body))
+		assert.NoError(t, err)
+		res.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode, body)
+	}
+	res, err := http.Get(ts.URL + "/runs/undefined")
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+}
+
+func TestMetrics(t *testing.T) {
+	s, err := NewServer(1, "")
+	assert.NoError(t, err)
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+	run := submit(t, ts.URL, echoWorkflow, nil)
+	s.Wait()
+	assert.Equal(t, workflow.Successed, getRun(t, ts.URL, run.RunId).Status)
+	res, err := http.Get(ts.URL + "/metrics")
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	body, err := io.ReadAll(res.Body)
+	assert.NoError(t, err)
+	assert.Contains(t, string(body), `flowyexec_jobs_finished_total{status="Successed"}`)
+	assert.Contains(t, string(body), `flowyexec_pipe_bytes_total{key="TEXT"}`)
+}
+
+func TestCancelAndHistory(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewServer(1, dir)
+	assert.NoError(t, err)
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+	running := submit(t, ts.URL, sleepWorkflow, nil)
+	queued := submit(t, ts.URL, sleepWorkflow, nil)
+	waitStatus(t, ts.URL, running.RunId, workflow.Running)
+	assert.Equal(t, workflow.Created, getRun(t, ts.URL, queued.RunId).Status)
+	start := time.Now()
+	for _, run := range []*Run{queued, running} {
+		req, err := http.NewRequest(http.MethodDelete, ts.URL+"/runs/"+run.RunId, nil)
+		assert.NoError(t, err)
+		res, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		res.Body.Close()
+		assert.Equal(t, http.StatusAccepted, res.StatusCode)
+	}
+	s.Wait()
+	assert.Less(t, time.Since(start), 5*time.Second)
+	for _, run := range []*Run{running, queued} {
+		run = getRun(t, ts.URL, run.RunId)
+		assert.Equal(t, workflow.Aborted, run.Status)
+		assert.Equal(t, workflow.Aborted, run.Results[0].Status)
+	}
+	req, err := http.NewRequest(http.MethodDelete, ts.URL+"/runs/"+running.RunId, nil)
+	assert.NoError(t, err)
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusConflict, res.StatusCode)
+
+	restarted, err := NewServer(1, dir)
+	assert.NoError(t, err)
+	runs := restarted.List()
+	assert.Len(t, runs, 2)
+	assert.Equal(t, running.RunId, runs[0].RunId)
+	assert.Equal(t, queued.RunId, runs[1].RunId)
+	for _, run := range runs {
+		assert.Equal(t, workflow.Aborted, run.Status)
+	}
+}
@@ -0,0 +1,513 @@
+package workflow
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+const agentType = "Agent"
+
+// agentRequest is the first line sent on a connection to an agent, which
+// either runs a workflow or carries a pipe of a workflow run by the agent.
+// Role is the role of the job of the agent accepting the pipe. A request
+// of type cancel, sent after a run request, cancels the workflow.
+// Token is the token shared by the agent and the hosts using it.
+type agentRequest struct {
+	Type     string
+	Token    string       `json:",omitempty"`
+	RunId    string       `json:",omitempty"`
+	Key      string       `json:",omitempty"`
+	Role     string       `json:",omitempty"`
+	Workflow *WorkflowDto `json:",omitempty"`
+	// Trace propagates the trace of the workflow placing the jobs.
+	Trace map[string]string `json:",omitempty"`
+	// JobLogs makes the agent write the log files of the jobs.
+	JobLogs bool `json:",omitempty"`
+}
+
+// agentReply is a line sent by an agent running a workflow: the events
+// of the jobs, and then the result of the workflow or an error.
+type agentReply struct {
+	Event  *JobEvent       `json:",omitempty"`
+	Result *WorkflowResult `json:",omitempty"`
+	Error  string          `json:",omitempty"`
+}
+
+// Agent runs the jobs placed on it by the workflows executed on other hosts.
+// The batch jobs of a workflow run in a directory named after its run id.
+// The workflows and the pipes are accepted only with the token of the agent.
+type Agent struct {
+	listener net.Listener
+	dir      string
+	token    string
+	mu       sync.Mutex
+	// slots are the pipe connections waiting to be accepted
+	// by the jobs, keyed by run id and by slot.
+	slots map[string]map[string]chan net.Conn
+	// ended are the ids of the runs which have ended.
+	ended map[string]bool
+	runs  map[string]*Workflow
+	wg    sync.WaitGroup
+	// Logger is the logger of the agent and the workflows run by it,
+	// which is the standard logger of logrus if it is nil.
+	Logger *logrus.Logger
+}
+
+func (a *Agent) logger() *logrus.Logger {
+	if a.Logger != nil {
+		return a.Logger
+	}
+	return logrus.StandardLogger()
+}
+
+// bufferedConn is a connection whose first line has been read by reader.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// NewAgent creates an agent listening on addr, whose workflows run in dir,
+// which accepts the requests with token.
+func NewAgent(addr string, dir string, token string) (*Agent, error) {
+	if token == "" {
+		return nil, errors.New("agent requires a token")
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &Agent{
+		listener: listener,
+		dir:      dir,
+		token:    token,
+		slots:    map[string]map[string]chan net.Conn{},
+		ended:    map[string]bool{},
+		runs:     map[string]*Workflow{},
+	}, nil
+}
+
+// Addr returns the address the agent listens on.
+func (a *Agent) Addr() string {
+	return a.listener.Addr().String()
+}
+
+// Serve accepts the connections until the agent is closed.
+func (a *Agent) Serve() error {
+	for {
+		conn, err := a.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		a.wg.Add(1)
+		go a.handle(conn)
+	}
+}
+
+// Close stops accepting connections, cancels the running workflows
+// and waits until they have finished.
+func (a *Agent) Close() error {
+	err := a.listener.Close()
+	a.mu.Lock()
+	runs := make([]*Workflow, 0, len(a.runs))
+	for _, wf := range a.runs {
+		runs = append(runs, wf)
+	}
+	a.mu.Unlock()
+	for _, wf := range runs {
+		wf.Cancel()
+	}
+	a.wg.Wait()
+	return err
+}
+
+func (a *Agent) handle(conn net.Conn) {
+	defer a.wg.Done()
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadBytes('\n')
+	var req agentRequest
+	if err == nil {
+		err = json.Unmarshal(line, &req)
+	}
+	if err != nil {
+		a.logger().WithError(err).Warn("Invalid Agent Request")
+		conn.Close()
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(req.Token), []byte(a.token)) != 1 {
+		a.logger().WithFields(logrus.Fields{"type": req.Type, "remote": conn.RemoteAddr().String()}).Warn("Unauthorized Agent Request")
+		if req.Type == "run" {
+			json.NewEncoder(conn).Encode(&agentReply{Error: "invalid token"})
+		}
+		conn.Close()
+		return
+	}
+	switch req.Type {
+	case "pipe":
+		a.deliverPipe(&req, &bufferedConn{Conn: conn, reader: reader})
+	case "run":
+		a.run(conn, reader, &req)
+	default:
+		a.logger().WithFields(logrus.Fields{"type": req.Type}).Warn("Invalid Agent Request")
+		conn.Close()
+	}
+}
+
+// slot returns the slot of the pipe connection of a run,
+// or nil if the run has ended. It is called with mu held.
+func (a *Agent) slot(runId string, key string, role string) chan net.Conn {
+	if a.ended[runId] {
+		return nil
+	}
+	slots, ok := a.slots[runId]
+	if !ok {
+		slots = map[string]chan net.Conn{}
+		a.slots[runId] = slots
+	}
+	id := key + "\x00" + role
+	if _, ok := slots[id]; !ok {
+		slots[id] = make(chan net.Conn, 1)
+	}
+	return slots[id]
+}
+
+// deliverPipe passes a pipe connection to the job accepting it,
+// which may be created after the connection is made.
+func (a *Agent) deliverPipe(req *agentRequest, conn net.Conn) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	slot := a.slot(req.RunId, req.Key, req.Role)
+	if slot == nil {
+		conn.Close()
+		return
+	}
+	select {
+	case slot <- conn:
+	default:
+		a.logger().WithFields(logrus.Fields{"runId": req.RunId, "key": req.Key}).Warn("Duplicated Pipe Connection")
+		conn.Close()
+	}
+}
+
+// acceptPipe waits for the connection of the pipe of key
+// accepted by the job of role, until aborted is closed.
+func (a *Agent) acceptPipe(runId string, key string, role string, aborted chan struct{}) (net.Conn, error) {
+	a.mu.Lock()
+	slot := a.slot(runId, key, role)
+	a.mu.Unlock()
+	if slot == nil {
+		return nil, fmt.Errorf("run %s has ended", runId)
+	}
+	select {
+	case conn, ok := <-slot:
+		if !ok {
+			return nil, fmt.Errorf("run %s has ended", runId)
+		}
+		return conn, nil
+	case <-aborted:
+		return nil, errAborted
+	}
+}
+
+// endRun closes the pipe connections of a run which have not been accepted.
+func (a *Agent) endRun(runId string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, slot := range a.slots[runId] {
+		select {
+		case conn := <-slot:
+			conn.Close()
+		default:
+		}
+		close(slot)
+	}
+	delete(a.slots, runId)
+	delete(a.runs, runId)
+	a.ended[runId] = true
+}
+
+// run executes the workflow of req, and sends the events of its jobs
+// and its result on conn. The workflow is canceled when a cancel
+// request is received or the connection is lost.
+func (a *Agent) run(conn net.Conn, reader *bufio.Reader, req *agentRequest) {
+	defer conn.Close()
+	defer a.endRun(req.RunId)
+	encoder := json.NewEncoder(conn)
+	if req.Workflow == nil || req.RunId == "" {
+		encoder.Encode(&agentReply{Error: "run request requires a run id and a workflow"})
+		return
+	}
+	if !isPathSegment(req.RunId) {
+		encoder.Encode(&agentReply{Error: fmt.Sprintf("invalid run id %s", req.RunId)})
+		return
+	}
+	if err := validateRun(req.Workflow); err != nil {
+		encoder.Encode(&agentReply{Error: err.Error()})
+		return
+	}
+	dir := filepath.Join(a.dir, req.RunId)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		encoder.Encode(&agentReply{Error: err.Error()})
+		return
+	}
+	// the directory is left if the jobs have written files in it
+	defer os.Remove(dir)
+	wf := createWorkflow(req.Workflow, dir, nil)
+	wf.RunId = req.RunId
+	wf.agent = a
+	wf.Logger = a.Logger
+	wf.JobLogs = req.JobLogs
+	// the pipes to the other agents are connected with the same token
+	wf.AgentToken = a.token
+	wf.parent = traceContext.Extract(context.Background(), propagation.MapCarrier(req.Trace))
+	wf.JobEvents = true
+	a.mu.Lock()
+	a.runs[req.RunId] = wf
+	a.mu.Unlock()
+	log := wf.log().WithFields(logrus.Fields{"addr": a.Addr()})
+	log.Info("Start Agent Run")
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			line, err := reader.ReadBytes('\n')
+			if err != nil {
+				if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+					log.WithError(err).Warn("Lost Agent Connection")
+				}
+				break
+			}
+			var cancel agentRequest
+			if json.Unmarshal(line, &cancel) == nil && cancel.Type == "cancel" {
+				break
+			}
+		}
+		select {
+		case <-done:
+		default:
+			wf.Cancel()
+		}
+	}()
+
+	events := make(chan Event, 10)
+	results := make(chan *WorkflowResult, 1)
+	go func() {
+		results <- wf.Execute(events)
+	}()
+	for event := range events {
+		if jobEvent, ok := event.(*JobEvent); ok {
+			encoder.Encode(&agentReply{Event: jobEvent})
+			continue
+		}
+		if workflowEvent, ok := event.(*WorkflowEvent); ok {
+			if workflowEvent.ExecError != nil {
+				<-results
+				encoder.Encode(&agentReply{Error: workflowEvent.ExecError.Error()})
+				return
+			}
+			break
+		}
+	}
+	result := <-results
+	encoder.Encode(&agentReply{Result: result})
+	log.WithFields(logrus.Fields{"status": result.Status}).Info("Finish Agent Run")
+}
+
+// isPathSegment reports whether name can name a directory in another
+// directory without escaping it.
+func isPathSegment(name string) bool {
+	return name != "." && name != ".." && !strings.ContainsAny(name, "/\\")
+}
+
+// validateRun checks the workflow of a run request as LoadWorkflow checks
+// a workflow, except that it has the jobs carrying the pipes from and to
+// the other hosts, and neither sub-workflows nor jobs placed on agents.
+func validateRun(dto *WorkflowDto) error {
+	jobIds := map[string]bool{}
+	for _, job := range dto.Jobs {
+		if jobIds[job.JobId] {
+			return fmt.Errorf("duplicated job id %s", job.JobId)
+		}
+		jobIds[job.JobId] = true
+		switch job.Type {
+		case "Workflow", agentType:
+			return fmt.Errorf("job %s of type %s cannot run on an agent", job.JobId, job.Type)
+		}
+		if err := job.validate(); err != nil {
+			return err
+		}
+		if _, ok := dto.Objectstores[job.Store]; job.Store != "" && !ok {
+			return fmt.Errorf("object store %s of job %s is not defined", job.Store, job.JobId)
+		}
+	}
+	return dto.validateGraph()
+}
+
+// agentJob runs the jobs placed on an agent, and reports their
+// events and results as those of the jobs of its workflow.
+type agentJob struct {
+	streamJob
+	addr     string
+	workflow *WorkflowDto
+	jobIds   []string
+	results  map[string]*JobResult
+	conn     net.Conn
+	// pipes are the accounting of the pipes of the jobs run by the agent.
+	pipes []*PipeResult
+}
+
+func createAgentJob(jobDto *JobDto) Job {
+	job := &agentJob{
+		streamJob: streamJob{jobId: jobDto.JobId, status: Created},
+		addr:      strings.TrimPrefix(jobDto.Url, "tcp://"),
+		workflow:  jobDto.workflow,
+		results:   map[string]*JobResult{},
+	}
+	for _, placed := range jobDto.workflow.Jobs {
+		if placed.Type != netSendType && placed.Type != netReceiveType {
+			job.jobIds = append(job.jobIds, placed.JobId)
+			job.results[placed.JobId] = &JobResult{JobId: placed.JobId, Status: Created}
+		}
+	}
+	job.onAbort = job.cancel
+	return job
+}
+
+func (job *agentJob) Execute(wf *Workflow, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer wf.UnBlock()
+	// the jobs not reported by the agent have not run
+	defer job.abortRemote()
+	if !job.begin() {
+		return
+	}
+	job.finish(job.run(wf))
+}
+
+func (job *agentJob) run(wf *Workflow) error {
+	conn, err := net.DialTimeout("tcp", job.addr, netDialTimeout)
+	if err != nil {
+		return fmt.Errorf("cannot connect to agent %s: %w", job.addr, err)
+	}
+	defer conn.Close()
+	request := &agentRequest{Type: "run", Token: wf.AgentToken, RunId: wf.RunId, Workflow: job.workflow, Trace: injectTrace(wf.jobContext(job.jobId)), JobLogs: wf.JobLogs}
+	if err := json.NewEncoder(conn).Encode(request); err != nil {
+		return err
+	}
+	job.mu.Lock()
+	job.conn = conn
+	aborted := job.status == Aborted
+	job.mu.Unlock()
+	if aborted {
+		job.cancel()
+	}
+	decoder := json.NewDecoder(conn)
+	for {
+		var reply agentReply
+		if err := decoder.Decode(&reply); err != nil {
+			return fmt.Errorf("connection to agent %s is lost: %w", job.addr, err)
+		}
+		switch {
+		case reply.Event != nil:
+			if job.update(reply.Event) {
+				wf.emitJobEvent(reply.Event)
+			}
+		case reply.Result != nil:
+			for _, result := range reply.Result.Results {
+				job.setResult(result)
+			}
+			job.mu.Lock()
+			job.pipes = reply.Result.Pipes
+			job.mu.Unlock()
+			if reply.Result.Status != Successed {
+				return fmt.Errorf("jobs on agent %s have failed", job.addr)
+			}
+			return nil
+		case reply.Error != "":
+			return fmt.Errorf("agent %s: %s", job.addr, reply.Error)
+		}
+	}
+}
+
+// cancel cancels the workflow running on the agent.
+func (job *agentJob) cancel() {
+	job.mu.Lock()
+	conn := job.conn
+	job.mu.Unlock()
+	if conn != nil {
+		json.NewEncoder(conn).Encode(&agentRequest{Type: "cancel"})
+	}
+}
+
+// update updates the result of a job by its event,
+// and reports whether the job is run by the agent.
+func (job *agentJob) update(event *JobEvent) bool {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	result, ok := job.results[event.JobId]
+	if !ok {
+		return false
+	}
+	result.Status = event.Status
+	result.ExitCode = event.ExitCode
+	result.Message = event.Message
+	return true
+}
+
+func (job *agentJob) setResult(result *JobResult) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	if _, ok := job.results[result.JobId]; ok {
+		job.results[result.JobId] = result
+	}
+}
+
+func (job *agentJob) abortRemote() {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	for _, result := range job.results {
+		if !result.Status.IsFinished() {
+			result.Status = Aborted
+			result.ExitCode = Aborted.GetDefaultExitCode()
+		}
+	}
+}
+
+// remotePipes returns the accounting of the pipes of the jobs run by the agent.
+func (job *agentJob) remotePipes() []*PipeResult {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	return job.pipes
+}
+
+// remoteResults returns the results of the jobs run by the agent.
+func (job *agentJob) remoteResults() []*JobResult {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	results := make([]*JobResult, 0, len(job.jobIds))
+	for _, jobId := range job.jobIds {
+		result := *job.results[jobId]
+		results = append(results, &result)
+	}
+	return results
+}
@@ -0,0 +1,230 @@
+package workflow
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// agentToken is the token of the agents started by the tests.
+const agentToken = "secret"
+
+// startAgents starts agents named a and b on localhost, and returns
+// the agents of a workflow using them.
+func startAgents(t *testing.T) string {
+	addrs := []string{}
+	for _, name := range []string{"a", "b"} {
+		agent, err := NewAgent("127.0.0.1:0", t.TempDir(), agentToken)
+		assert.NoError(t, err)
+		go agent.Serve()
+		t.Cleanup(func() { agent.Close() })
+		addrs = append(addrs, `"`+name+`": "`+agent.Addr()+`"`)
+	}
+	return `{` + strings.Join(addrs, ", ") + `}`
+}
+
+func executePlaced(t *testing.T, agents string, jobs string) *WorkflowResult {
+	workflow, err := LoadWorkflow(strings.NewReader(`{"agents": ` + agents + `, "jobs": ` + jobs + `}`))
+	assert.NoError(t, err)
+	workflow.AgentToken = agentToken
+	return workflow.Execute(make(chan Event, 10))
+}
+
+func TestPlacedPipes(t *testing.T) {
+	agents := startAgents(t)
+	out := filepath.Join(t.TempDir(), "sorted.txt")
+	result := executePlaced(t, agents, `[
+		{"jobId": "literal", "type": "Literal", "content": "b\na\nc\n", "writeTo": "TEXT"},
+		{"jobId": "upper", "placement": "a", "command": ["sh", "-c", "tr a-z A-Z < fifo1 > fifo2"],
+		 "inputs": [{"readFrom": "TEXT", "path": "fifo1"}], "outputs": [{"writeTo": "UPPER", "path": "fifo2"}]},
+		{"jobId": "sort", "placement": "b", "command": ["sh", "-c", "sort < fifo1 > fifo2"],
+		 "inputs": [{"readFrom": "UPPER", "path": "fifo1"}], "outputs": [{"writeTo": "SORTED", "path": "fifo2"}]},
+		{"jobId": "sink", "type": "File", "readFrom": "SORTED", "path": "`+out+`"}
+	]`)
+	assert.Equal(t, Successed, result.Status)
+	jobIds := []string{}
+	for _, jr := range result.Results {
+		jobIds = append(jobIds, jr.JobId)
+		assert.Equal(t, Successed, jr.Status, jr.JobId)
+	}
+	assert.Equal(t, []string{"literal", "upper", "sort", "sink"}, jobIds)
+	// the pipes on the agents are reported with their bridges
+	pipes := map[string]int64{}
+	for _, pipe := range result.Pipes {
+		pipes[pipe.Producer+" "+pipe.Key] = pipe.BytesRead
+	}
+	assert.Equal(t, map[string]int64{
+		"literal TEXT":                       6,
+		"<receive TEXT from flowyexec> TEXT": 6,
+		"upper UPPER":                        6,
+		"<receive UPPER from a> UPPER":       6,
+		"sort SORTED":                        6,
+		"<receive SORTED from b> SORTED":     6,
+	}, pipes)
+	data, err := os.ReadFile(out)
+	assert.NoError(t, err)
+	assert.Equal(t, "A\nB\nC\n", string(data))
+}
+
+func TestPlacedPipesBackpressure(t *testing.T) {
+	agents := startAgents(t)
+	out := filepath.Join(t.TempDir(), "count.txt")
+	result := executePlaced(t, agents, `[
+		{"jobId": "seq", "placement": "a", "command": ["sh", "-c", "seq 1 300000 > fifo1"],
+		 "outputs": [{"writeTo": "TEXT", "path": "fifo1"}]},
+		{"jobId": "count", "placement": "b", "command": ["sh", "-c", "wc -l < fifo1 > fifo2"],
+		 "inputs": [{"readFrom": "TEXT", "path": "fifo1"}], "outputs": [{"writeTo": "COUNT", "path": "fifo2"}]},
+		{"jobId": "sink", "type": "File", "readFrom": "COUNT", "path": "`+out+`"}
+	]`)
+	assert.Equal(t, Successed, result.Status)
+	data, err := os.ReadFile(out)
+	assert.NoError(t, err)
+	assert.Equal(t, "300000", strings.TrimSpace(string(data)))
+}
+
+func TestPlacedPipesFailure(t *testing.T) {
+	agents := startAgents(t)
+	result := executePlaced(t, agents, `[
+		{"jobId": "producer", "placement": "a", "command": ["sh", "-c", "echo x > fifo1; exit 1"],
+		 "outputs": [{"writeTo": "TEXT", "path": "fifo1"}]},
+		{"jobId": "consumer", "placement": "b", "command": ["cat", "fifo1"],
+		 "inputs": [{"readFrom": "TEXT", "path": "fifo1"}]},
+		{"jobId": "skipped", "type": "Literal", "when": "false", "content": "x", "writeTo": "SKIPPED"},
+		{"jobId": "skippedConsumer", "placement": "a", "command": ["cat", "fifo2"],
+		 "inputs": [{"readFrom": "SKIPPED", "path": "fifo2"}]}
+	]`)
+	assert.Equal(t, Failed, result.Status)
+	// the producer may be aborted by its pipe if it exits before the pipe is opened
+	assert.True(t, result.Results[0].Status.IsFailed())
+	expected := []JobStatus{Aborted, Skipped, Skipped}
+	for idx, jr := range result.Results[1:] {
+		assert.Equal(t, expected[idx], jr.Status, jr.JobId)
+	}
+}
+
+func TestValidatePlacements(t *testing.T) {
+	tests := []struct {
+		name string
+		jobs string
+		err  string
+	}{
+		{
+			name: "undefined placement",
+			jobs: `[{"jobId": "a", "placement": "c", "command": ["true"]}]`,
+			err:  "job a has undefined placement c",
+		},
+		{
+			name: "dependency on another host",
+			jobs: `[{"jobId": "a", "placement": "a", "command": ["true"]}, {"jobId": "b", "dependsOn": ["a"], "command": ["true"]}]`,
+			err:  "job b cannot depend on job a on another host",
+		},
+		{
+			name: "retried across hosts",
+			jobs: `[{"jobId": "a", "placement": "a", "command": ["true"], "outputs": [{"writeTo": "K", "path": "fifo1"}], "retries": 1}, {"jobId": "b", "command": ["true"], "inputs": [{"readFrom": "K", "path": "fifo1"}]}]`,
+			err:  "job a cannot be retried because its pipes connect jobs on other hosts",
+		},
+		{
+			name: "reserved type",
+			jobs: `[{"jobId": "a", "type": "NetSend", "readFrom": "K"}]`,
+			err:  "job a has reserved type NetSend",
+		},
+		{
+			name: "valid",
+			jobs: `[{"jobId": "a", "placement": "a", "command": ["true"]}, {"jobId": "b", "placement": "a", "dependsOn": ["a"], "command": ["true"]}]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadWorkflow(strings.NewReader(`{"agents": {"a": "127.0.0.1:1"}, "jobs": ` + tt.jobs + `}`))
+			if tt.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestAgentInvalidRun(t *testing.T) {
+	agent, err := NewAgent("127.0.0.1:0", t.TempDir(), agentToken)
+	assert.NoError(t, err)
+	go agent.Serve()
+	defer agent.Close()
+	tests := []struct {
+		name  string
+		token string
+		runId string
+		jobs  string
+		err   string
+	}{
+		{
+			name:  "invalid token",
+			token: "wrong",
+			runId: "run",
+			jobs:  `[{"jobId": "a", "command": ["true"]}]`,
+			err:   "invalid token",
+		},
+		{
+			name:  "pipe without producer",
+			token: agentToken,
+			runId: "run",
+			jobs:  `[{"jobId": "a", "command": ["cat", "fifo1"], "inputs": [{"readFrom": "NOPE", "path": "fifo1"}]}]`,
+			err:   "pipe NOPE read by job a is not written by any job",
+		},
+		{
+			name:  "no command",
+			token: agentToken,
+			runId: "run",
+			jobs:  `[{"jobId": "a"}]`,
+			err:   "batch job a requires command",
+		},
+		{
+			name:  "sub-workflow",
+			token: agentToken,
+			runId: "run",
+			jobs:  `[{"jobId": "a", "type": "Workflow", "path": "wf.json"}]`,
+			err:   "job a of type Workflow cannot run on an agent",
+		},
+		{
+			name:  "run id out of the directory",
+			token: agentToken,
+			runId: "../run",
+			jobs:  `[{"jobId": "a", "command": ["true"]}]`,
+			err:   "invalid run id ../run",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", agent.Addr())
+			assert.NoError(t, err)
+			defer conn.Close()
+			_, err = conn.Write([]byte(`{"type": "run", "token": "` + tt.token + `", "runId": "` + tt.runId + `", "workflow": {"jobs": ` + tt.jobs + `}}` + "\n"))
+			assert.NoError(t, err)
+			var reply agentReply
+			assert.NoError(t, json.NewDecoder(conn).Decode(&reply))
+			assert.Equal(t, tt.err, reply.Error)
+		})
+	}
+}
+
+func TestAgentWithoutToken(t *testing.T) {
+	_, err := NewAgent("127.0.0.1:0", t.TempDir(), "")
+	assert.EqualError(t, err, "agent requires a token")
+}
+
+func TestPlacedPipesInvalidToken(t *testing.T) {
+	agents := startAgents(t)
+	workflow, err := LoadWorkflow(strings.NewReader(`{"agents": ` + agents + `, "jobs": [
+		{"jobId": "a", "placement": "a", "command": ["true"]}
+	]}`))
+	assert.NoError(t, err)
+	workflow.AgentToken = "wrong"
+	result := workflow.Execute(make(chan Event, 10))
+	assert.Equal(t, Failed, result.Status)
+	assert.Equal(t, Aborted, result.Results[0].Status)
+}
@@ -7,23 +7,39 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
 )
 
 type BatchJob struct {
-	JobId    string
-	Command  []string
-	Inputs   []BatchJobInput
-	Outputs  []BatchJobOutput
-	_cancel  context.CancelFunc
+	JobId   string
+	Command []string
+	Inputs  []BatchJobInput
+	Outputs []BatchJobOutput
+	ctx     context.Context
+	_cancel context.CancelFunc
+	// mu guards the status and the result of the job,
+	// which are read by other goroutines while the job is running.
+	mu       sync.Mutex
 	status   JobStatus
 	ExitCode int
+	message  string
 	Start    time.Time
 	End      time.Time
+	// SuccessCodes are the exit codes which mean success in addition to 0.
+	SuccessCodes []int
+	// signal is the signal which has killed the command.
+	signal syscall.Signal
+	// dir is the working directory of the command.
+	dir string
+	// owner is the workflow running the job.
+	owner *Workflow
 }
 type BatchJobOutput struct {
 	job     *BatchJob
@@ -38,6 +54,37 @@ type BatchJobInput struct {
 	key     string
 	blocked bool
 	handler *PipeHandler
+	// materialize makes the input a regular file instead of a FIFO.
+	// The job is started after the file is completely written.
+	materialize  bool
+	materialized *materializedFile
+}
+
+// materializedFile is the regular file of a materialized input.
+// done is closed when the writer of the file is closed.
+type materializedFile struct {
+	file *os.File
+	once sync.Once
+	done chan struct{}
+	err  error
+}
+
+func (m *materializedFile) Write(data []byte) (int, error) {
+	n, err := m.file.Write(data)
+	if err != nil && m.err == nil {
+		m.err = err
+	}
+	return n, err
+}
+
+func (m *materializedFile) Close() error {
+	m.once.Do(func() {
+		if err := m.file.Close(); err != nil && m.err == nil {
+			m.err = err
+		}
+		close(m.done)
+	})
+	return m.err
 }
 
 func (s *BatchJobOutput) Abort() {
@@ -56,53 +103,106 @@ func (s *BatchJobInput) Key() string {
 }
 
 func (s *BatchJobInput) GetWriter() (io.WriteCloser, error) {
-	if s.job.status.IsFinished() {
+	status := s.job.GetStatus()
+	if status == Skipped {
+		return nil, errSkipped
+	}
+	if status.IsFinished() {
 		return nil, fmt.Errorf("Job %s has already finished", s.job.JobId)
 	}
-	logrus.WithFields(logrus.Fields{"jobId": s.job.JobId, "Path": s.path}).Info("Opening Writer")
+	if s.materialize {
+		s.job.log().WithFields(logrus.Fields{"Path": s.path}).Info("Materializing input")
+		f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			s.materialized.err = err
+			close(s.materialized.done)
+			return nil, err
+		}
+		s.materialized.file = f
+		return s.materialized, nil
+	}
+	s.job.log().WithFields(logrus.Fields{"Path": s.path}).Info("Opening Writer")
 	s.blocked = true
+	start := time.Now()
 	w, err := os.OpenFile(s.path, os.O_WRONLY, 0)
+	fifoOpenWait.WithLabelValues("write").Observe(time.Since(start).Seconds())
 	s.blocked = false
-	if s.job.status.IsFinished() {
+	if status := s.job.GetStatus(); status.IsFinished() {
+		if err == nil {
+			w.Close()
+		}
+		if status == Skipped {
+			return nil, errSkipped
+		}
 		return nil, fmt.Errorf("Job %s has already finished", s.job.JobId)
 	}
-	logrus.WithFields(logrus.Fields{"jobId": s.job.JobId, "Path": s.path}).Info("Opened Writer")
+	s.job.log().WithFields(logrus.Fields{"Path": s.path}).Info("Opened Writer")
 	return w, err
 }
 func (s *BatchJobOutput) GetReader() (io.ReadCloser, error) {
-	if s.job.status.IsFinished() {
+	status := s.job.GetStatus()
+	if status == Skipped {
+		return nil, errSkipped
+	}
+	if status == Successed {
+		// The job cannot have written its output before the reader is opened,
+		// since opening the FIFO for writing waits for the reader.
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	if status.IsFinished() {
 		return nil, fmt.Errorf("Job %s has already finished", s.job.JobId)
 	}
-	logrus.WithFields(logrus.Fields{"jobId": s.job.JobId, "Path": s.path}).Info("Opening Reader")
+	s.job.log().WithFields(logrus.Fields{"Path": s.path}).Info("Opening Reader")
 	s.blocked = true
+	start := time.Now()
 	w, err := os.OpenFile(s.path, os.O_RDONLY, 0)
+	fifoOpenWait.WithLabelValues("read").Observe(time.Since(start).Seconds())
 	s.blocked = false
-	if s.job.status.IsFinished() {
+	// A job which has successfully finished may have written its output
+	// before UnBlock opened the other end, so that it is still readable.
+	if status := s.job.GetStatus(); status.IsFailed() || status == Skipped {
+		if err == nil {
+			w.Close()
+		}
+		if status == Skipped {
+			return nil, errSkipped
+		}
 		return nil, fmt.Errorf("Job %s has already finished", s.job.JobId)
 	}
-	logrus.WithFields(logrus.Fields{"jobId": s.job.JobId, "Path": s.path}).Info("Opened Reader")
+	s.job.log().WithFields(logrus.Fields{"Path": s.path}).Info("Opened Reader")
 	return w, err
 }
 
 func (s *BatchJobInput) UnBlock() {
-	if s.blocked && s.job.status.IsFinished() {
-		logrus.WithFields(logrus.Fields{"jobId": s.job.JobId, "Path": s.path}).Info("Unblock opening in write mode")
-		r, err := os.OpenFile(s.path, os.O_RDONLY, 0)
+	if s.blocked && s.job.GetStatus().IsFinished() {
+		s.job.log().WithFields(logrus.Fields{"Path": s.path}).Info("Unblock opening in write mode")
+		// O_NONBLOCK keeps UnBlock from waiting for a writer
+		// if the writer has already been opened and closed.
+		r, err := os.OpenFile(s.path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
 		if err == nil {
 			r.Close()
 		}
 	}
 }
 func (s *BatchJobOutput) UnBlock() {
-	if s.blocked && s.job.status.IsFinished() {
-		logrus.WithFields(logrus.Fields{"jobId": s.job.JobId, "Path": s.path}).Info("Unblock opening in read mode")
-		r, err := os.OpenFile(s.path, os.O_WRONLY, 0)
+	if s.blocked && s.job.GetStatus().IsFinished() {
+		s.job.log().WithFields(logrus.Fields{"Path": s.path}).Info("Unblock opening in read mode")
+		r, err := os.OpenFile(s.path, os.O_WRONLY|syscall.O_NONBLOCK, 0)
 		if err == nil {
 			r.Close()
 		}
 	}
 }
 
+func (job *BatchJob) setOwner(w *Workflow) {
+	job.owner = w
+}
+
+// log returns the entry of the log lines of the job.
+func (job *BatchJob) log() *logrus.Entry {
+	return job.owner.jobLog(job.JobId)
+}
+
 func (job *BatchJob) GetId() string {
 	return job.JobId
 }
@@ -111,11 +211,15 @@ func (job *BatchJob) GetInputs() []Input {
 	inputs := make([]Input, 0, len(job.Inputs))
 	for _, input := range job.Inputs {
 		inputs = append(inputs, &BatchJobInput{
-			job:  job,
-			key:  input.key,
-			path: input.path,
+			job:          job,
+			key:          input.key,
+			path:         input.path,
+			materialize:  input.materialize,
+			materialized: input.materialized,
 		})
-		syscall.Mkfifo(input.path, 0600)
+		if !input.materialize {
+			syscall.Mkfifo(input.path, 0600)
+		}
 	}
 	return inputs
 }
@@ -138,6 +242,15 @@ func (job *BatchJobOutput) Label() string {
 func (job *BatchJobInput) Label() string {
 	return job.job.JobId
 }
+
+// inDir returns path relative to dir unless it is absolute.
+func inDir(dir string, path string) string {
+	if dir == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
 func Exists(name string) bool {
 	_, err := os.Stat(name)
 	return !os.IsNotExist(err)
@@ -153,66 +266,191 @@ func (job *BatchJobInput) Clear() {
 	}
 }
 func (job *BatchJobOutput) IsFailed() bool {
-	return job.job.status.IsFailed()
+	return job.job.GetStatus().IsFailed()
 }
 func (job *BatchJob) GetStatus() JobStatus {
+	job.mu.Lock()
+	defer job.mu.Unlock()
 	return job.status
 }
 func (job *BatchJob) Abort() {
-	job._cancel()
+	if job._cancel != nil {
+		job._cancel()
+	}
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	if job.status == Successed {
+		// the job is aborted after it has finished, e.g. because its producer has failed
+		job.ExitCode = Aborted.GetDefaultExitCode()
+	}
 	job.status = Aborted
 }
 
+// Skip marks the job as skipped unless it has been started.
+func (job *BatchJob) Skip() {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	if job.status == Created {
+		job.status = Skipped
+	}
+}
+
 func (job *BatchJob) GetResult() *JobResult {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	// the times are copied as the job may be running
+	start, end := job.Start, job.End
 	return &JobResult{
 		JobId:    job.JobId,
 		Status:   job.status,
-		Start:    &job.Start,
-		End:      &job.End,
+		Start:    &start,
+		End:      &end,
 		ExitCode: job.ExitCode,
-		Message:  "",
+		Message:  job.message,
+		Signal:   job.signalName(),
+	}
+}
+
+func (job *BatchJob) signalName() string {
+	if job.signal == 0 {
+		return ""
+	}
+	return unix.SignalName(job.signal)
+}
+
+func (job *BatchJob) isSuccessCode(code int) bool {
+	for _, c := range job.SuccessCodes {
+		if c == code {
+			return true
+		}
 	}
+	return false
+}
+
+// waitMaterialized waits until the files of the materialized inputs are written
+// and their producers have finished, since a producer may fail after closing
+// its output, leaving the file truncated. The producers writing other pipes
+// are not waited for, since they may wait for the job through those pipes.
+// It returns errAborted if the job is aborted before or while waiting,
+// or if a producer has failed.
+func (job *BatchJob) waitMaterialized() error {
+	if job.GetStatus() == Aborted {
+		return errAborted
+	}
+	for _, input := range job.Inputs {
+		if !input.materialize {
+			continue
+		}
+		select {
+		case <-input.materialized.done:
+		case <-job.ctx.Done():
+		}
+		if job.GetStatus() == Aborted {
+			return errAborted
+		}
+		if input.materialized.err != nil {
+			return fmt.Errorf("cannot materialize %s: %w", input.path, input.materialized.err)
+		}
+		if handler := job.owner.pipeHandler(input.key); handler != nil && handler.waitProducer(job.ctx) {
+			job.Abort()
+		}
+		if job.GetStatus() == Aborted {
+			return errAborted
+		}
+	}
+	return nil
 }
 
 func (job *BatchJob) Execute(wf *Workflow, wg *sync.WaitGroup) {
 	defer wg.Done()
 	defer wf.UnBlock()
-	logrus.WithFields(logrus.Fields{"jobId": job.JobId, "command": job.Command}).Info("Start Job")
-	ctx := context.Background()
-	ctx2, cancel := context.WithCancel(ctx)
-	job._cancel = cancel
-	cmd := exec.CommandContext(ctx2, job.Command[0], job.Command[1:]...)
+	if job.ctx == nil {
+		job.ctx, job._cancel = context.WithCancel(context.Background())
+	}
+	job.mu.Lock()
 	job.Start = time.Now()
+	if job.status == Skipped {
+		job.End = job.Start
+		job.mu.Unlock()
+		job.log().Info("Job Skipped")
+		return
+	}
+	job.mu.Unlock()
+	if err := job.waitMaterialized(); err != nil {
+		job.mu.Lock()
+		defer job.mu.Unlock()
+		job.End = time.Now()
+		if job.status == Aborted {
+			job.ExitCode = Aborted.GetDefaultExitCode()
+			job.log().WithFields(logrus.Fields{"status": job.status, "exitCode": job.ExitCode}).Warn("Job Aborted")
+			return
+		}
+		job.status = Failed
+		job.ExitCode = Failed.GetDefaultExitCode()
+		job.message = err.Error()
+		job.log().WithFields(logrus.Fields{"status": job.status, "exitCode": job.ExitCode}).WithError(err).Warn("Job Failed")
+		return
+	}
+	job.log().WithFields(logrus.Fields{"command": job.Command}).Info("Start Job")
+	cmd := exec.CommandContext(job.ctx, job.Command[0], job.Command[1:]...)
+	cmd.Dir = job.dir
+	if logFile, err := wf.jobLogFile(job.JobId); err != nil {
+		job.log().WithError(err).Warn("Cannot open job log")
+	} else if logFile != nil {
+		cmd.Stdout = logFile
+		cmd.Stderr = logFile
+	}
+	if env := traceEnv(wf.jobContext(job.JobId)); len(env) > 0 {
+		// the command continues the trace of the job
+		cmd.Env = append(os.Environ(), env...)
+	}
 	err := cmd.Start()
+	job.mu.Lock()
 	if err != nil {
 		job.status = Failed
-		logrus.WithFields(logrus.Fields{"jobId": job.JobId, "status": job.status, "exitCode": job.ExitCode}).WithError(err).Warn("Finished Job")
+		job.log().WithFields(logrus.Fields{"status": job.status, "exitCode": job.ExitCode}).WithError(err).Warn("Finished Job")
+		job.mu.Unlock()
 		return
 	}
 	job.status = Running
+	job.mu.Unlock()
 	err = cmd.Wait()
+	job.mu.Lock()
+	defer job.mu.Unlock()
 	job.End = time.Now()
 	if err != nil {
 		if e2, ok := err.(*exec.ExitError); ok {
 			if s, ok := e2.Sys().(syscall.WaitStatus); ok {
+				if s.Signaled() && job.status != Aborted {
+					job.signal = s.Signal()
+				}
 				if job.status == Aborted {
 					job.status = Aborted
 					job.ExitCode = Aborted.GetDefaultExitCode()
-					logrus.WithFields(logrus.Fields{"jobId": job.JobId, "status": job.status, "exitCode": job.ExitCode}).WithError(err).Warn("Job Aborted")
+					job.log().WithFields(logrus.Fields{"status": job.status, "exitCode": job.ExitCode}).WithError(err).Warn("Job Aborted")
+				} else if job.isSuccessCode(s.ExitStatus()) {
+					job.status = Successed
+					job.ExitCode = s.ExitStatus()
+					job.log().WithFields(logrus.Fields{"status": job.status, "exitCode": job.ExitCode}).Info("Finished Job")
 				} else {
 					job.status = Failed
 					job.ExitCode = s.ExitStatus()
-					logrus.WithFields(logrus.Fields{"jobId": job.JobId, "status": job.status, "exitCode": job.ExitCode}).WithError(err).Warn("Job Failed")
+					job.log().WithFields(logrus.Fields{"status": job.status, "exitCode": job.ExitCode}).WithError(err).Warn("Job Failed")
 				}
 			} else {
 				panic(errors.New("unimplemented for system where exec.ExitError.Sys() is not syscall.WaitStatus"))
 			}
+		} else if errors.Is(err, job.ctx.Err()) {
+			// the job is aborted after it has exited successfully
+			job.status = Aborted
+			job.ExitCode = Aborted.GetDefaultExitCode()
+			job.log().WithFields(logrus.Fields{"status": job.status, "exitCode": job.ExitCode}).WithError(err).Warn("Job Aborted")
 		} else {
 			panic(errors.New("unimplemented for system where exec.ExitError.Sys() is not syscall.WaitStatus"))
 		}
 	} else {
 		job.status = Successed
 		job.ExitCode = 0
-		logrus.WithFields(logrus.Fields{"jobId": job.JobId, "status": job.status, "exitCode": job.ExitCode}).Info("Finished Job")
+		job.log().WithFields(logrus.Fields{"status": job.status, "exitCode": job.ExitCode}).Info("Finished Job")
 	}
 }
@@ -0,0 +1,775 @@
+package workflow
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The CWL loader converts a subset of CWL v1.2 to a workflow.
+//
+// A CommandLineTool becomes a batch job whose command line is built from
+// its baseCommand, arguments and the inputBinding of its inputs, with
+// stdin and stdout redirected by sh. A Workflow becomes the jobs of its
+// steps, which run CommandLineTools.
+//
+// A File is streamed through a pipe instead of being written to the disk
+// if it is declared streamable, or if it is the stdout of a tool. A File at
+// an s3:// location is downloaded by an ObjectStore job. Each job reading a
+// streamed File has a FIFO for it, which is a regular file written before
+// the job starts unless the input of the job is streamable. The other Files
+// are passed by path, and the jobs reading them depend on the jobs writing them.
+//
+// The outputs of the document are written to CwlOptions.Outdir, a local
+// directory or an s3:// prefix, by File or ObjectStore jobs.
+//
+// The constructs out of the subset are reported by UnsupportedCwlError.
+
+// CwlOptions configures the conversion of a CWL document.
+type CwlOptions struct {
+	// Inputs are the values of the inputs of the document, the job order.
+	Inputs map[string]interface{}
+	// Outdir is the directory or the s3:// prefix where the outputs are written.
+	// The outputs are written to the current directory if it is empty.
+	Outdir string
+	// Objectstore is the object store of s3:// locations,
+	// which is configured by the environment if it is nil.
+	Objectstore *ObjectStore
+}
+
+// UnsupportedCwlError lists the constructs of a CWL document
+// which cannot be converted to a workflow.
+type UnsupportedCwlError struct {
+	Constructs []string
+}
+
+func (e *UnsupportedCwlError) Error() string {
+	return "unsupported CWL constructs:\n  " + strings.Join(e.Constructs, "\n  ")
+}
+
+var cwlVersions = []string{"v1.0", "v1.1", "v1.2"}
+
+var cwlParameterReference = regexp.MustCompile(`\$\(([^)]*)\)`)
+
+// cwlValue is the value of a workflow input or of an output of a step.
+type cwlValue struct {
+	// value is the value of an input which is not a File.
+	value interface{}
+	file  bool
+	// key is the pipe streaming the File, or path is the File on the disk.
+	key  string
+	path string
+	// producer is the job writing the File.
+	producer string
+}
+
+// cwlEntry is an entry of a field which is either a list of objects
+// with ids or a map from ids to objects.
+type cwlEntry struct {
+	id     string
+	fields map[string]interface{}
+}
+
+type cwlConverter struct {
+	options     *CwlOptions
+	dto         *WorkflowDto
+	unsupported []string
+	// values are the workflow inputs by id and the outputs of the steps by step/output.
+	values map[string]*cwlValue
+	// writers are the steps writing the Files on the disk by path.
+	writers map[string]string
+	usesS3  bool
+}
+
+// LoadCwlFile converts the CWL document at path to a workflow.
+func LoadCwlFile(path string, options *CwlOptions) (*Workflow, error) {
+	dto, err := ConvertCwl(path, options)
+	if err != nil {
+		return nil, err
+	}
+	if err := dto.validate(filepath.Dir(path), nil); err != nil {
+		return nil, err
+	}
+	return CreateWorkflow(dto), nil
+}
+
+// LoadCwlInputs reads the job order of a CWL document from a YAML or JSON file.
+// The relative locations of the Files are relative to the directory of the file.
+func LoadCwlInputs(path string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	inputs := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &inputs); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, value := range inputs {
+		if file, ok := value.(map[string]interface{}); ok && file["class"] == "File" {
+			for _, field := range []string{"location", "path"} {
+				if location, ok := file[field].(string); ok && !strings.Contains(location, "://") && !filepath.IsAbs(location) {
+					file[field] = filepath.Join(filepath.Dir(path), location)
+				}
+			}
+		}
+	}
+	return inputs, nil
+}
+
+// ConvertCwl converts the CWL CommandLineTool or Workflow at path to a workflow.
+func ConvertCwl(path string, options *CwlOptions) (*WorkflowDto, error) {
+	if options == nil {
+		options = &CwlOptions{}
+	}
+	doc, err := readCwl(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &cwlConverter{
+		options: options,
+		dto:     &WorkflowDto{Name: strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))},
+		values:  map[string]*cwlValue{},
+		writers: map[string]string{},
+	}
+	c.checkDocument(doc, path)
+	if err := c.convertInputs(doc, path); err != nil {
+		return nil, err
+	}
+	var outputs map[string]string
+	switch doc["class"] {
+	case "CommandLineTool":
+		step := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		in := map[string]string{}
+		for _, input := range cwlEntries(doc["inputs"], "type") {
+			in[input.id] = input.id
+		}
+		c.convertStep(step, doc, path, in)
+		outputs = map[string]string{}
+		for _, output := range cwlEntries(doc["outputs"], "type") {
+			outputs[output.id] = step + "/" + output.id
+		}
+	case "Workflow":
+		if err := c.convertSteps(doc, path); err != nil {
+			return nil, err
+		}
+		outputs = c.workflowOutputs(doc, path)
+	}
+	if len(c.unsupported) > 0 {
+		return nil, &UnsupportedCwlError{Constructs: c.unsupported}
+	}
+	for _, id := range sortedStringKeys(outputs) {
+		c.convertOutput(id, outputs[id])
+	}
+	if len(c.unsupported) > 0 {
+		return nil, &UnsupportedCwlError{Constructs: c.unsupported}
+	}
+	if c.usesS3 {
+		c.dto.Objectstore = options.Objectstore
+		if c.dto.Objectstore == nil {
+			c.dto.Objectstore = &ObjectStore{}
+		}
+	}
+	return c.dto, nil
+}
+
+func readCwl(path string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	doc := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return doc, nil
+}
+
+func (c *cwlConverter) unsupport(where string, format string, args ...interface{}) {
+	c.unsupported = append(c.unsupported, where+": "+fmt.Sprintf(format, args...))
+}
+
+// checkDocument reports the constructs of a document which are not supported
+// wherever they are used.
+func (c *cwlConverter) checkDocument(doc map[string]interface{}, where string) {
+	version, _ := doc["cwlVersion"].(string)
+	if version != "" && !contains(cwlVersions, version) {
+		c.unsupport(where, "cwlVersion %s", version)
+	}
+	switch doc["class"] {
+	case "CommandLineTool", "Workflow":
+	default:
+		c.unsupport(where, "class %v", doc["class"])
+	}
+	for _, requirement := range cwlEntries(doc["requirements"], "class") {
+		c.unsupport(where, "requirement %s", requirement.id)
+	}
+	for _, key := range sortedKeys(doc) {
+		if strings.HasPrefix(key, "$") {
+			c.unsupport(where, "directive %s", key)
+		}
+	}
+}
+
+// cwlEntries returns the entries of a field which is either a list of
+// objects with ids or a map from ids to objects. An entry which is just
+// a string is the value of the field named shorthand, or the id in a list.
+func cwlEntries(field interface{}, shorthand string) []cwlEntry {
+	entries := []cwlEntry{}
+	switch f := field.(type) {
+	case []interface{}:
+		for _, item := range f {
+			switch v := item.(type) {
+			case string:
+				entries = append(entries, cwlEntry{id: cwlId(v), fields: map[string]interface{}{}})
+			case map[string]interface{}:
+				id, _ := v["id"].(string)
+				if id == "" {
+					id, _ = v[shorthand].(string)
+				}
+				entries = append(entries, cwlEntry{id: cwlId(id), fields: v})
+			}
+		}
+	case map[string]interface{}:
+		for _, id := range sortedKeys(f) {
+			fields, ok := f[id].(map[string]interface{})
+			if !ok {
+				fields = map[string]interface{}{shorthand: f[id]}
+			}
+			entries = append(entries, cwlEntry{id: id, fields: fields})
+		}
+	}
+	return entries
+}
+
+// cwlId returns an id or a source without the # of a URI fragment.
+func cwlId(id string) string {
+	return strings.TrimPrefix(id, "#")
+}
+
+// cwlType returns the type of a parameter without null, and whether it is optional.
+// It returns an empty type if the type is not a simple type.
+func cwlType(field interface{}) (string, bool) {
+	switch t := field.(type) {
+	case string:
+		if strings.HasSuffix(t, "?") {
+			return strings.TrimSuffix(t, "?"), true
+		}
+		return t, false
+	case []interface{}:
+		types := []string{}
+		optional := false
+		for _, item := range t {
+			if item == "null" {
+				optional = true
+			} else if s, ok := item.(string); ok {
+				types = append(types, s)
+			} else {
+				return "", false
+			}
+		}
+		if len(types) == 1 {
+			return types[0], optional
+		}
+	}
+	return "", false
+}
+
+// convertInputs converts the inputs of the document with the values in the job order.
+func (c *cwlConverter) convertInputs(doc map[string]interface{}, where string) error {
+	for _, input := range cwlEntries(doc["inputs"], "type") {
+		typ, optional := cwlType(input.fields["type"])
+		value, ok := c.options.Inputs[input.id]
+		if !ok {
+			value, ok = input.fields["default"]
+		}
+		if !ok || value == nil {
+			if !optional && typ != "" {
+				return fmt.Errorf("input %s of %s has no value", input.id, where)
+			}
+			continue
+		}
+		if typ != "File" {
+			c.values[input.id] = &cwlValue{value: value}
+			continue
+		}
+		file, _ := value.(map[string]interface{})
+		location, _ := file["location"].(string)
+		if location == "" {
+			location, _ = file["path"].(string)
+		}
+		if location == "" {
+			return fmt.Errorf("input %s of %s has no location", input.id, where)
+		}
+		switch {
+		case strings.HasPrefix(location, "s3://"):
+			key := "inputs/" + input.id
+			c.dto.Jobs = append(c.dto.Jobs, &JobDto{
+				JobId:   "download-" + input.id,
+				Type:    "ObjectStore",
+				Url:     location,
+				WriteTo: key,
+			})
+			c.usesS3 = true
+			c.values[input.id] = &cwlValue{file: true, key: key, path: filepath.Base(location)}
+		case strings.HasPrefix(location, "file://"):
+			c.values[input.id] = &cwlValue{file: true, path: strings.TrimPrefix(location, "file://")}
+		case strings.Contains(location, "://"):
+			c.unsupport(where, "location %s of input %s", location, input.id)
+		default:
+			c.values[input.id] = &cwlValue{file: true, path: location}
+		}
+	}
+	return nil
+}
+
+// convertSteps converts the steps of a Workflow in the order of their dependencies.
+func (c *cwlConverter) convertSteps(doc map[string]interface{}, path string) error {
+	steps := cwlEntries(doc["steps"], "run")
+	converted := map[string]bool{}
+	for len(converted) < len(steps) {
+		progress := false
+		for _, step := range steps {
+			if converted[step.id] {
+				continue
+			}
+			in, ready := c.stepSources(step)
+			if !ready {
+				continue
+			}
+			converted[step.id] = true
+			progress = true
+			where := path + " step " + step.id
+			c.checkStep(step, where)
+			var tool map[string]interface{}
+			toolPath := where
+			switch run := step.fields["run"].(type) {
+			case string:
+				toolPath = run
+				if !filepath.IsAbs(toolPath) {
+					toolPath = filepath.Join(filepath.Dir(path), run)
+				}
+				var err error
+				if tool, err = readCwl(toolPath); err != nil {
+					return err
+				}
+			case map[string]interface{}:
+				tool = run
+			default:
+				c.unsupport(where, "run %v", step.fields["run"])
+				continue
+			}
+			c.checkDocument(tool, toolPath)
+			if tool["class"] != "CommandLineTool" {
+				c.unsupport(where, "run of class %v", tool["class"])
+				continue
+			}
+			c.convertStep(step.id, tool, toolPath, in)
+		}
+		if !progress {
+			for _, step := range steps {
+				if !converted[step.id] {
+					return fmt.Errorf("step %s of %s has undefined sources or a cycle", step.id, path)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// stepSources returns the sources of the inputs of a step
+// and whether the values of the sources are known.
+func (c *cwlConverter) stepSources(step cwlEntry) (map[string]string, bool) {
+	in := map[string]string{}
+	ready := true
+	for _, input := range cwlEntries(step.fields["in"], "source") {
+		var source string
+		switch s := input.fields["source"].(type) {
+		case string:
+			source = cwlId(s)
+		case nil:
+			if value, ok := input.fields["default"]; ok {
+				source = step.id + "/defaults/" + input.id
+				c.values[source] = &cwlValue{value: value}
+			}
+		}
+		if source == "" {
+			continue
+		}
+		if _, ok := c.values[source]; !ok {
+			ready = false
+		}
+		in[input.id] = source
+	}
+	return in, ready
+}
+
+// checkStep reports the constructs of a step which are not supported.
+func (c *cwlConverter) checkStep(step cwlEntry, where string) {
+	for _, key := range []string{"scatter", "scatterMethod", "when", "requirements"} {
+		if _, ok := step.fields[key]; ok {
+			c.unsupport(where, "%s", key)
+		}
+	}
+	for _, input := range cwlEntries(step.fields["in"], "source") {
+		inputWhere := where + " input " + input.id
+		for _, key := range []string{"valueFrom", "linkMerge", "pickValue"} {
+			if _, ok := input.fields[key]; ok {
+				c.unsupport(inputWhere, "%s", key)
+			}
+		}
+		if _, ok := input.fields["source"].([]interface{}); ok {
+			c.unsupport(inputWhere, "multiple sources")
+		}
+	}
+}
+
+// cwlArgument is an argument or an input binding
+// sorted by position as the command line is built.
+type cwlArgument struct {
+	position int
+	input    bool
+	index    int
+	name     string
+	args     []string
+}
+
+// convertStep converts a CommandLineTool run by the step named id, whose inputs
+// are the values of the sources in, to a batch job and its pipes.
+func (c *cwlConverter) convertStep(id string, tool map[string]interface{}, where string, in map[string]string) {
+	job := &JobDto{JobId: id}
+	dependsOn := map[string]bool{}
+	inputs := map[string]interface{}{}
+	paths := map[string]string{}
+	var bindings []cwlArgument
+	for _, input := range cwlEntries(tool["inputs"], "type") {
+		inputWhere := where + " input " + input.id
+		typ, _ := cwlType(input.fields["type"])
+		switch typ {
+		case "File", "string", "int", "long", "float", "double", "boolean":
+		default:
+			c.unsupport(inputWhere, "type %v", input.fields["type"])
+			continue
+		}
+		for _, key := range []string{"secondaryFiles", "loadContents", "format"} {
+			if _, ok := input.fields[key]; ok {
+				c.unsupport(inputWhere, "%s", key)
+			}
+		}
+		var value *cwlValue
+		if source, ok := in[input.id]; ok {
+			value = c.values[source]
+		} else if v, ok := input.fields["default"]; ok {
+			value = &cwlValue{value: v}
+		}
+		if value == nil {
+			continue
+		}
+		if typ == "File" {
+			if !value.file {
+				c.unsupport(inputWhere, "File value %v", value.value)
+				continue
+			}
+			path := value.path
+			if value.key != "" {
+				path = id + "." + input.id
+				streamable, _ := input.fields["streamable"].(bool)
+				job.Inputs = append(job.Inputs, JobInput{Path: path, ReadFrom: value.key, Materialize: !streamable})
+			} else if value.producer != "" {
+				dependsOn[value.producer] = true
+			}
+			paths[input.id] = path
+			inputs[input.id] = path
+		} else {
+			inputs[input.id] = value.value
+		}
+		binding, ok := input.fields["inputBinding"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if _, ok := binding["valueFrom"]; ok {
+			c.unsupport(inputWhere, "valueFrom of inputBinding")
+			continue
+		}
+		position, _ := binding["position"].(int)
+		bindings = append(bindings, cwlArgument{
+			position: position,
+			input:    true,
+			name:     input.id,
+			args:     bindArgument(binding, inputs[input.id]),
+		})
+	}
+	refer := func(s string, refWhere string) string {
+		return c.resolveReferences(s, inputs, paths, refWhere)
+	}
+	command := []string{}
+	switch base := tool["baseCommand"].(type) {
+	case string:
+		command = append(command, base)
+	case []interface{}:
+		for _, arg := range base {
+			command = append(command, fmt.Sprint(arg))
+		}
+	}
+	if args, ok := tool["arguments"].([]interface{}); ok {
+		for idx, arg := range args {
+			switch a := arg.(type) {
+			case map[string]interface{}:
+				value, _ := a["valueFrom"].(string)
+				position, _ := a["position"].(int)
+				bindings = append(bindings, cwlArgument{
+					position: position,
+					index:    idx,
+					args:     bindArgument(a, refer(value, where+" arguments")),
+				})
+			default:
+				bindings = append(bindings, cwlArgument{index: idx, args: []string{refer(fmt.Sprint(a), where+" arguments")}})
+			}
+		}
+	}
+	sort.SliceStable(bindings, func(i, j int) bool {
+		a, b := bindings[i], bindings[j]
+		if a.position != b.position {
+			return a.position < b.position
+		}
+		if a.input != b.input {
+			return !a.input
+		}
+		if a.input {
+			return a.name < b.name
+		}
+		return a.index < b.index
+	})
+	for _, binding := range bindings {
+		command = append(command, binding.args...)
+	}
+	if len(command) == 0 {
+		c.unsupport(where, "no baseCommand or arguments")
+		return
+	}
+	stdin := ""
+	if s, ok := tool["stdin"].(string); ok {
+		stdin = refer(s, where+" stdin")
+	}
+	stdout := ""
+	for _, key := range []string{"stderr", "permanentFailCodes", "temporaryFailCodes"} {
+		if _, ok := tool[key]; ok {
+			c.unsupport(where, "%s", key)
+		}
+	}
+	if codes, ok := tool["successCodes"].([]interface{}); ok {
+		for _, code := range codes {
+			if n, ok := code.(int); ok && n != 0 {
+				job.SuccessCodes = append(job.SuccessCodes, n)
+			}
+		}
+	}
+	for _, output := range cwlEntries(tool["outputs"], "type") {
+		outputWhere := where + " output " + output.id
+		typ, _ := cwlType(output.fields["type"])
+		key := id + "/" + output.id
+		switch typ {
+		case "stdout":
+			if stdout == "" {
+				stdout = id + ".stdout"
+				if s, ok := tool["stdout"].(string); ok {
+					stdout = refer(s, where+" stdout")
+				}
+			}
+			job.Outputs = append(job.Outputs, JobOutput{Path: stdout, WriteTo: key})
+			c.values[key] = &cwlValue{file: true, key: key, path: stdout}
+		case "File":
+			binding, _ := output.fields["outputBinding"].(map[string]interface{})
+			glob, _ := binding["glob"].(string)
+			for _, field := range []string{"outputEval", "loadContents"} {
+				if _, ok := binding[field]; ok {
+					c.unsupport(outputWhere, "%s", field)
+				}
+			}
+			if _, ok := output.fields["secondaryFiles"]; ok {
+				c.unsupport(outputWhere, "secondaryFiles")
+			}
+			path := refer(glob, outputWhere)
+			if path == "" || strings.ContainsAny(path, "*?[") {
+				c.unsupport(outputWhere, "glob %v", binding["glob"])
+				continue
+			}
+			if streamable, _ := output.fields["streamable"].(bool); streamable {
+				job.Outputs = append(job.Outputs, JobOutput{Path: path, WriteTo: key})
+				c.values[key] = &cwlValue{file: true, key: key, path: path}
+				continue
+			}
+			if writer, ok := c.writers[path]; ok {
+				c.unsupport(outputWhere, "file %s also written by step %s", path, writer)
+			}
+			c.writers[path] = id
+			c.values[key] = &cwlValue{file: true, path: path, producer: id}
+		default:
+			c.unsupport(outputWhere, "type %v", output.fields["type"])
+		}
+	}
+	job.Command = redirect(command, stdin, stdout)
+	for _, producer := range sortedBoolKeys(dependsOn) {
+		job.DependsOn = append(job.DependsOn, JobDependency{JobId: producer})
+	}
+	c.dto.Jobs = append(c.dto.Jobs, job)
+}
+
+// bindArgument returns the arguments of a value bound with the prefix
+// and separate of binding.
+func bindArgument(binding map[string]interface{}, value interface{}) []string {
+	prefix, _ := binding["prefix"].(string)
+	separate := true
+	if s, ok := binding["separate"].(bool); ok {
+		separate = s
+	}
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case bool:
+		if v && prefix != "" {
+			return []string{prefix}
+		}
+		return nil
+	}
+	s := fmt.Sprint(value)
+	if prefix == "" {
+		return []string{s}
+	}
+	if separate {
+		return []string{prefix, s}
+	}
+	return []string{prefix + s}
+}
+
+// resolveReferences replaces the parameter references in s, which are
+// $(inputs.<id>), $(inputs.<id>.path), $(inputs.<id>.basename) and $(runtime.outdir).
+func (c *cwlConverter) resolveReferences(s string, inputs map[string]interface{}, paths map[string]string, where string) string {
+	if strings.Contains(s, "${") {
+		c.unsupport(where, "expression %s", s)
+		return s
+	}
+	return cwlParameterReference.ReplaceAllStringFunc(s, func(ref string) string {
+		expr := strings.TrimSpace(ref[2 : len(ref)-1])
+		if expr == "runtime.outdir" {
+			return "."
+		}
+		parts := strings.Split(expr, ".")
+		if parts[0] == "inputs" && len(parts) >= 2 {
+			if path, ok := paths[parts[1]]; ok {
+				switch {
+				case len(parts) == 3 && parts[2] == "path":
+					return path
+				case len(parts) == 3 && parts[2] == "basename":
+					return filepath.Base(path)
+				}
+			} else if value, ok := inputs[parts[1]]; ok && len(parts) == 2 {
+				return fmt.Sprint(value)
+			}
+		}
+		c.unsupport(where, "expression %s", ref)
+		return ref
+	})
+}
+
+// redirect runs command by sh with its stdin and stdout redirected to files,
+// which are passed as arguments so that they are not interpreted by sh.
+func redirect(command []string, stdin string, stdout string) []string {
+	if stdin == "" && stdout == "" {
+		return command
+	}
+	script := ""
+	args := []string{}
+	redirection := ""
+	if stdin != "" {
+		script += `i=$1; shift; `
+		args = append(args, stdin)
+		redirection += ` <"$i"`
+	}
+	if stdout != "" {
+		script += `o=$1; shift; `
+		args = append(args, stdout)
+		redirection += ` >"$o"`
+	}
+	return append(append([]string{"sh", "-c", script + `exec "$@"` + redirection, "sh"}, args...), command...)
+}
+
+// workflowOutputs returns the sources of the outputs of a Workflow.
+func (c *cwlConverter) workflowOutputs(doc map[string]interface{}, path string) map[string]string {
+	outputs := map[string]string{}
+	for _, output := range cwlEntries(doc["outputs"], "type") {
+		source, ok := output.fields["outputSource"].(string)
+		if !ok {
+			c.unsupport(path+" output "+output.id, "outputSource %v", output.fields["outputSource"])
+			continue
+		}
+		outputs[output.id] = cwlId(strings.TrimPrefix(source, "#"))
+	}
+	return outputs
+}
+
+// convertOutput writes the File of the source of an output of the document to the outdir.
+func (c *cwlConverter) convertOutput(id string, source string) {
+	value, ok := c.values[source]
+	if !ok || !value.file || value.producer == "" && value.key == "" {
+		c.unsupport("output "+id, "source %s which is not a File written by a step", source)
+		return
+	}
+	outdir := c.options.Outdir
+	if outdir == "" {
+		outdir = "."
+	}
+	key := value.key
+	if key == "" {
+		if !strings.HasPrefix(outdir, "s3://") && filepath.Join(outdir, filepath.Base(value.path)) == filepath.Clean(value.path) {
+			// the File has been written to the outdir
+			return
+		}
+		key = "outputs/" + id
+		c.dto.Jobs = append(c.dto.Jobs, &JobDto{
+			JobId:     "read-" + id,
+			Type:      "File",
+			Path:      value.path,
+			WriteTo:   key,
+			DependsOn: []JobDependency{{JobId: value.producer}},
+		})
+	}
+	if strings.HasPrefix(outdir, "s3://") {
+		c.usesS3 = true
+		c.dto.Jobs = append(c.dto.Jobs, &JobDto{
+			JobId:    "upload-" + id,
+			Type:     "ObjectStore",
+			Url:      strings.TrimSuffix(outdir, "/") + "/" + filepath.Base(value.path),
+			ReadFrom: key,
+		})
+		return
+	}
+	c.dto.Jobs = append(c.dto.Jobs, &JobDto{
+		JobId:    "write-" + id,
+		Type:     "File",
+		Path:     filepath.Join(outdir, filepath.Base(value.path)),
+		ReadFrom: key,
+	})
+}
+
+func sortedStringKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedBoolKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,60 @@
+package workflow
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConvertCwl(t *testing.T) {
+	inputs, err := LoadCwlInputs("../testdata/cwl/inputs.yml")
+	assert.NoError(t, err)
+	dto, err := ConvertCwl("../testdata/cwl/workflow.cwl", &CwlOptions{Inputs: inputs, Outdir: "out"})
+	assert.NoError(t, err)
+	jobIds := []string{}
+	for _, job := range dto.Jobs {
+		jobIds = append(jobIds, job.JobId)
+	}
+	assert.Equal(t, []string{"upper", "sort", "count", "write-count", "read-sorted", "write-sorted"}, jobIds)
+	assert.Equal(t, []string{"sh", "-c", `i=$1; shift; o=$1; shift; exec "$@" <"$i" >"$o"`, "sh", "../testdata/cwl/reads.txt", "upper.txt", "tr", "a-z", "A-Z"}, dto.Jobs[0].Command)
+	assert.Equal(t, []JobOutput{{Path: "upper.txt", WriteTo: "upper/upper"}}, dto.Jobs[0].Outputs)
+	assert.Equal(t, []string{"sort", "-o", "sorted.txt", "sort.reads"}, dto.Jobs[1].Command)
+	assert.Equal(t, []JobInput{{Path: "sort.reads", ReadFrom: "upper/upper", Materialize: true}}, dto.Jobs[1].Inputs)
+	assert.Equal(t, []JobDependency{{JobId: "sort"}}, dto.Jobs[2].DependsOn)
+	assert.Empty(t, dto.Jobs[2].Inputs)
+	assert.Equal(t, filepath.Join("out", "sorted.txt"), dto.Jobs[5].Path)
+}
+
+func TestCwlWorkflow(t *testing.T) {
+	defer os.Remove("sorted.txt")
+	outdir := t.TempDir()
+	inputs, err := LoadCwlInputs("../testdata/cwl/inputs.yml")
+	assert.NoError(t, err)
+	workflow, err := LoadCwlFile("../testdata/cwl/workflow.cwl", &CwlOptions{Inputs: inputs, Outdir: outdir})
+	assert.NoError(t, err)
+	result := workflow.Execute(make(chan Event, 10))
+	assert.Equal(t, Successed, result.Status)
+	data, err := os.ReadFile(filepath.Join(outdir, "sorted.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "A\nB\nC\n", string(data))
+	data, err = os.ReadFile(filepath.Join(outdir, "count.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "3\n", string(data))
+}
+
+func TestUnsupportedCwl(t *testing.T) {
+	_, err := ConvertCwl("../testdata/cwl/unsupported.cwl", &CwlOptions{
+		Inputs: map[string]interface{}{"reads": map[string]interface{}{"class": "File", "location": "reads.txt"}},
+	})
+	var unsupported *UnsupportedCwlError
+	assert.True(t, errors.As(err, &unsupported))
+	assert.Equal(t, []string{
+		"../testdata/cwl/unsupported.cwl: requirement ScatterFeatureRequirement",
+		"../testdata/cwl/unsupported.cwl step count: scatter",
+		"../testdata/cwl/unsupported.cwl step count input reads: type Directory",
+		"../testdata/cwl/unsupported.cwl step count arguments: expression ${return 1}",
+	}, unsupported.Constructs)
+}
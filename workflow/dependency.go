@@ -0,0 +1,400 @@
+package workflow
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	ConditionSuccess    = "success"
+	ConditionFailure    = "failure"
+	ConditionCompletion = "completion"
+)
+
+// JobDependency is an entry of the dependsOn of a job, which starts the job
+// after the job of JobId has finished. Unless the dependency has finished
+// as required by Condition, which is success by default, the job is aborted
+// if the dependency has failed and skipped otherwise.
+// In a workflow file, a dependency is either an object or just a job id.
+type JobDependency struct {
+	JobId     string
+	Condition string
+}
+
+func (d *JobDependency) UnmarshalJSON(data []byte) error {
+	var jobId string
+	if err := json.Unmarshal(data, &jobId); err == nil {
+		d.JobId = jobId
+		return nil
+	}
+	type dependency JobDependency
+	return json.Unmarshal(data, (*dependency)(d))
+}
+
+func (d *JobDependency) condition() string {
+	if d.Condition == "" {
+		return ConditionSuccess
+	}
+	return d.Condition
+}
+
+// isSatisfiedBy reports whether a dependency finished with status satisfies the condition.
+func (d *JobDependency) isSatisfiedBy(status JobStatus) bool {
+	switch d.condition() {
+	case ConditionSuccess:
+		return status == Successed
+	case ConditionFailure:
+		return status.IsFailed()
+	}
+	return status.IsFinished()
+}
+
+// dependencies returns the dependsOn of a job and the jobs referenced
+// by its when expression, which the job depends on for their completion.
+func (jobDto *JobDto) dependencies() []JobDependency {
+	dependencies := append([]JobDependency{}, jobDto.DependsOn...)
+	if jobDto.When != "" {
+		// the expression has been validated by LoadWorkflow
+		when, _ := parseExpression(jobDto.When)
+		for _, jobId := range jobReferences(when) {
+			dependencies = append(dependencies, JobDependency{JobId: jobId, Condition: ConditionCompletion})
+		}
+	}
+	return dependencies
+}
+
+// inputKeys returns the keys of the pipes read by a job.
+func (jobDto *JobDto) inputKeys() []string {
+	keys := []string{}
+	if jobDto.ReadFrom != "" {
+		keys = append(keys, jobDto.ReadFrom)
+	}
+	for _, input := range jobDto.Inputs {
+		keys = append(keys, input.ReadFrom)
+	}
+	return keys
+}
+
+// outputKeys returns the keys of the pipes written by a job.
+func (jobDto *JobDto) outputKeys() []string {
+	keys := []string{}
+	if jobDto.WriteTo != "" {
+		keys = append(keys, jobDto.WriteTo)
+	}
+	for _, output := range jobDto.Outputs {
+		keys = append(keys, output.WriteTo)
+	}
+	return keys
+}
+
+// pipeKeys returns the keys of the pipes read and written by a job.
+func (jobDto *JobDto) pipeKeys() []string {
+	return append(jobDto.inputKeys(), jobDto.outputKeys()...)
+}
+
+// pipeUpstreams returns the ids of the jobs writing the pipes read by every job.
+func pipeUpstreams(jobs []*JobDto) map[string][]string {
+	producers := map[string]string{}
+	for _, job := range jobs {
+		for _, key := range job.outputKeys() {
+			producers[key] = job.JobId
+		}
+	}
+	upstreams := map[string][]string{}
+	for _, job := range jobs {
+		for _, key := range job.inputKeys() {
+			if producer, ok := producers[key]; ok {
+				upstreams[job.JobId] = append(upstreams[job.JobId], producer)
+			}
+		}
+	}
+	return upstreams
+}
+
+// pipeComponents groups the jobs connected by pipes, which run together.
+// It returns the index of the group of every job id and every pipe key.
+func pipeComponents(jobs []*JobDto) (map[string]int, map[string]int) {
+	parents := make([]int, len(jobs))
+	var find func(int) int
+	find = func(idx int) int {
+		if parents[idx] != idx {
+			parents[idx] = find(parents[idx])
+		}
+		return parents[idx]
+	}
+	keyJobs := map[string]int{}
+	for idx, job := range jobs {
+		parents[idx] = idx
+		for _, key := range job.pipeKeys() {
+			if other, ok := keyJobs[key]; ok {
+				parents[find(idx)] = find(other)
+			} else {
+				keyJobs[key] = idx
+			}
+		}
+	}
+	jobComponents := map[string]int{}
+	for idx, job := range jobs {
+		jobComponents[job.JobId] = find(idx)
+	}
+	keyComponents := map[string]int{}
+	for key, idx := range keyJobs {
+		keyComponents[key] = find(idx)
+	}
+	return jobComponents, keyComponents
+}
+
+// validatePipes checks that every pipe is written by exactly one job,
+// unless it is read from the inputs of the workflow.
+func validatePipes(jobs []*JobDto, inputs []string) error {
+	producers := map[string]string{}
+	for _, job := range jobs {
+		for _, key := range job.outputKeys() {
+			if producer, ok := producers[key]; ok {
+				return fmt.Errorf("pipe %s is written by both job %s and job %s", key, producer, job.JobId)
+			}
+			producers[key] = job.JobId
+		}
+	}
+	for _, job := range jobs {
+		for _, key := range job.inputKeys() {
+			if _, ok := producers[key]; !ok && !contains(inputs, key) {
+				return fmt.Errorf("pipe %s read by job %s is not written by any job", key, job.JobId)
+			}
+		}
+	}
+	return nil
+}
+
+// validateDependencies checks the dependsOn of jobs.
+// A job cannot depend on a job connected to it by pipes,
+// because they must run at the same time, and dependencies
+// between the groups of connected jobs cannot make a cycle.
+func validateDependencies(jobs []*JobDto) error {
+	jobComponents, _ := pipeComponents(jobs)
+	edges := map[int][]int{}
+	for _, job := range jobs {
+		for _, dependency := range job.dependencies() {
+			switch dependency.condition() {
+			case ConditionSuccess, ConditionFailure, ConditionCompletion:
+			default:
+				return fmt.Errorf("job %s has unsupported condition %s", job.JobId, dependency.Condition)
+			}
+			to, ok := jobComponents[dependency.JobId]
+			if !ok {
+				return fmt.Errorf("job %s depends on undefined job %s", job.JobId, dependency.JobId)
+			}
+			from := jobComponents[job.JobId]
+			if from == to {
+				return fmt.Errorf("job %s cannot depend on job %s connected by pipes", job.JobId, dependency.JobId)
+			}
+			edges[from] = append(edges[from], to)
+		}
+	}
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	states := map[int]int{}
+	var visit func(int) bool
+	visit = func(component int) bool {
+		switch states[component] {
+		case visiting:
+			return false
+		case visited:
+			return true
+		}
+		states[component] = visiting
+		for _, next := range edges[component] {
+			if !visit(next) {
+				return false
+			}
+		}
+		states[component] = visited
+		return true
+	}
+	for component := range edges {
+		if !visit(component) {
+			return fmt.Errorf("dependencies of jobs make a cycle")
+		}
+	}
+	return nil
+}
+
+// jobGroup is a group of jobs connected by pipes and their PipeHandlers.
+// done is closed when all the jobs have finished and the handlers
+// have settled the final status of the jobs. attempt counts the
+// executions of the jobs, which are restarted together to retry a job.
+type jobGroup struct {
+	mu       sync.Mutex
+	running  int
+	runs     []*jobRun
+	handlers []*PipeHandler
+	attempt  int
+	done     chan struct{}
+}
+
+// jobFinished reports whether all the jobs of the group have finished.
+func (g *jobGroup) jobFinished() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.running--
+	return g.running == 0
+}
+
+// jobRun holds the state of a job during the execution of the workflow.
+// decided is closed when it is decided whether the job runs.
+// attempts are the results of the previous attempts of the job.
+type jobRun struct {
+	job      Job
+	group    *jobGroup
+	decided  chan struct{}
+	decision JobStatus
+	attempts []*JobAttempt
+	// span is the span of the current attempt of the job.
+	span trace.Span
+}
+
+// createJobRuns groups the jobs and the handlers of the workflow.
+func (w *Workflow) createJobRuns() map[string]*jobRun {
+	groups := map[int]*jobGroup{}
+	runs := map[string]*jobRun{}
+	for _, job := range w.Jobs {
+		component, ok := w.jobComponents[job.GetId()]
+		if !ok {
+			// a job without a group of its own in a workflow not created by CreateWorkflow
+			component = -1
+		}
+		group, ok := groups[component]
+		if !ok {
+			group = &jobGroup{attempt: 1, done: make(chan struct{})}
+			groups[component] = group
+		}
+		group.running++
+		run := &jobRun{job: job, group: group, decided: make(chan struct{})}
+		group.runs = append(group.runs, run)
+		runs[job.GetId()] = run
+	}
+	for _, handler := range w.handlers {
+		component, ok := w.keyComponents[handler.output.Key()]
+		if !ok {
+			component = -1
+		}
+		if group, ok := groups[component]; ok {
+			group.handlers = append(group.handlers, handler)
+		}
+	}
+	return runs
+}
+
+// runJob executes a job after its dependencies have finished.
+func (w *Workflow) runJob(run *jobRun, runs map[string]*jobRun, wg *sync.WaitGroup) {
+	defer wg.Done()
+	run.decision = w.decide(run, runs)
+	close(run.decided)
+	w.executeJob(run, wg)
+}
+
+// executeJob executes a job as decided, and finishes its group
+// if it is the last job of the group.
+func (w *Workflow) executeJob(run *jobRun, wg *sync.WaitGroup) {
+	w.startJobSpan(run)
+	switch run.decision {
+	case Skipped:
+		run.job.Skip()
+	case Aborted:
+		run.job.Abort()
+	default:
+		w.emitJobEvent(&JobEvent{JobId: run.job.GetId(), Status: Running})
+		jobsRunning.Inc()
+		defer jobsRunning.Dec()
+	}
+	var jobWg sync.WaitGroup
+	jobWg.Add(1)
+	run.job.Execute(w, &jobWg)
+	for _, handler := range run.group.handlers {
+		if handler.producer == run.job.GetId() && handler.produced != nil {
+			close(handler.produced)
+		}
+	}
+	if run.group.jobFinished() {
+		w.finishGroup(run.group, wg)
+	}
+}
+
+// finishGroup settles the final status of the jobs of a group, sends their
+// JobEvents, and retries the jobs of the group if a job has failed to be retried.
+func (w *Workflow) finishGroup(group *jobGroup, wg *sync.WaitGroup) {
+	retry := w.hasRetries(group)
+	if retry {
+		// the handlers must not open the FIFOs of the next attempt
+		for _, handler := range group.handlers {
+			handler.wait()
+		}
+	}
+	for _, handler := range group.handlers {
+		handler.Finished()
+	}
+	for _, run := range group.runs {
+		result := run.job.GetResult()
+		if !w.hidden[result.JobId] {
+			observeJob(result)
+		}
+		endSpan(run.span, result.Status, result.Message)
+		w.emitJobEvent(&JobEvent{
+			JobId:    result.JobId,
+			Status:   result.Status,
+			ExitCode: result.ExitCode,
+			Message:  result.Message,
+		})
+	}
+	if retry && !w.isCanceled() && w.shouldRetry(group) {
+		w.retryGroup(group, wg)
+		return
+	}
+	close(group.done)
+}
+
+// decide waits until it can be decided whether a job runs, and returns
+// Created if it runs, Aborted if a dependency has failed, or Skipped
+// if it has not satisfied its dependencies or its when expression,
+// or a job writing a pipe read by the job has been skipped.
+func (w *Workflow) decide(run *jobRun, runs map[string]*jobRun) JobStatus {
+	jobId := run.job.GetId()
+	for _, dependency := range w.dependencies[jobId] {
+		<-runs[dependency.JobId].group.done
+		status := runs[dependency.JobId].job.GetStatus()
+		if dependency.isSatisfiedBy(status) {
+			continue
+		}
+		if dependency.condition() == ConditionSuccess && status.IsFailed() {
+			return Aborted
+		}
+		return Skipped
+	}
+	if when, ok := w.conditions[jobId]; ok {
+		ctx := &expressionContext{
+			jobs: func(jobId string) *JobResult {
+				return runs[jobId].job.GetResult()
+			},
+			params: w.Parameters,
+		}
+		if !truthy(when.eval(ctx)) {
+			return Skipped
+		}
+	}
+	for _, upstream := range w.upstreams[jobId] {
+		<-runs[upstream].decided
+		if runs[upstream].decision == Skipped {
+			return Skipped
+		}
+	}
+	if remote, ok := run.job.(remoteDecider); ok {
+		return remote.remoteDecision(w)
+	}
+	return Created
+}
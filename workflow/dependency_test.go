@@ -0,0 +1,90 @@
+package workflow
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateDependencies(t *testing.T) {
+	tests := []struct {
+		name string
+		jobs string
+		err  string
+	}{
+		{
+			name: "undefined job",
+			jobs: `[{"jobId": "a", "command": ["true"], "dependsOn": ["b"]}]`,
+			err:  "job a depends on undefined job b",
+		},
+		{
+			name: "unsupported condition",
+			jobs: `[{"jobId": "a", "command": ["true"]}, {"jobId": "b", "command": ["true"], "dependsOn": [{"jobId": "a", "condition": "never"}]}]`,
+			err:  "job b has unsupported condition never",
+		},
+		{
+			name: "connected by pipes",
+			jobs: `[{"jobId": "a", "type": "Literal", "writeTo": "K"}, {"jobId": "b", "type": "File", "readFrom": "K", "path": "x", "dependsOn": ["a"]}]`,
+			err:  "job b cannot depend on job a connected by pipes",
+		},
+		{
+			name: "cycle",
+			jobs: `[{"jobId": "a", "command": ["true"], "dependsOn": ["c"]}, {"jobId": "b", "command": ["true"], "dependsOn": ["a"]}, {"jobId": "c", "command": ["true"], "dependsOn": ["b"]}]`,
+			err:  "dependencies of jobs make a cycle",
+		},
+		{
+			name: "cycle through pipes",
+			jobs: `[{"jobId": "a", "type": "Literal", "writeTo": "K", "dependsOn": ["c"]}, {"jobId": "b", "type": "File", "readFrom": "K", "path": "x"}, {"jobId": "c", "command": ["true"], "dependsOn": ["b"]}]`,
+			err:  "dependencies of jobs make a cycle",
+		},
+		{
+			name: "valid",
+			jobs: `[{"jobId": "a", "command": ["true"]}, {"jobId": "b", "command": ["true"], "dependsOn": ["a", {"jobId": "a", "condition": "completion"}]}]`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := LoadWorkflow(strings.NewReader(`{"jobs": ` + test.jobs + `}`))
+			if test.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, test.err)
+			}
+		})
+	}
+}
+
+func TestValidatePipes(t *testing.T) {
+	tests := []struct {
+		name string
+		jobs string
+		err  string
+	}{
+		{
+			name: "no producer",
+			jobs: `[{"jobId": "a", "type": "File", "readFrom": "K", "path": "x"}]`,
+			err:  "pipe K read by job a is not written by any job",
+		},
+		{
+			name: "two producers",
+			jobs: `[{"jobId": "a", "type": "Literal", "writeTo": "K"}, {"jobId": "b", "type": "Literal", "writeTo": "K"}]`,
+			err:  "pipe K is written by both job a and job b",
+		},
+		{
+			name: "consumer before producer",
+			jobs: `[{"jobId": "a", "command": ["cat", "fifo1"], "inputs": [{"readFrom": "K", "path": "fifo1"}]}, {"jobId": "b", "type": "Literal", "content": "x", "writeTo": "K"}]`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			wf, err := LoadWorkflow(strings.NewReader(`{"jobs": ` + test.jobs + `}`))
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, Successed, wf.Execute(make(chan Event, 10)).Status)
+		})
+	}
+}
@@ -0,0 +1,314 @@
+package workflow
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// EncryptionConfig configures the encryption of objects transferred by ObjectStore jobs.
+type EncryptionConfig struct {
+	// KeyFile is a local file holding a 256 bit key encryption key.
+	// If it is set, objects are encrypted on the node before upload
+	// and decrypted after download, using the envelope format below.
+	KeyFile string
+	// ServerSide is the S3 server-side encryption, AES256 (SSE-S3) or aws:kms (SSE-KMS).
+	ServerSide string
+	// KMSKeyId is the KMS key used by SSE-KMS.
+	KMSKeyId string
+	// CustomerKeyFile is a local file holding a 256 bit key for SSE-C.
+	CustomerKeyFile string
+}
+
+// sseParams holds the server-side encryption parameters of S3 requests.
+type sseParams struct {
+	serverSide        *string
+	kmsKeyId          *string
+	customerAlgorithm *string
+	customerKey       *string
+}
+
+func (c *EncryptionConfig) sseParams() (*sseParams, error) {
+	params := &sseParams{}
+	if c == nil {
+		return params, nil
+	}
+	if c.ServerSide != "" {
+		params.serverSide = aws.String(c.ServerSide)
+	}
+	if c.KMSKeyId != "" {
+		params.kmsKeyId = aws.String(c.KMSKeyId)
+	}
+	if c.CustomerKeyFile != "" {
+		key, err := readKeyFile(c.CustomerKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		params.customerAlgorithm = aws.String("AES256")
+		params.customerKey = aws.String(string(key))
+	}
+	return params, nil
+}
+
+func (c *EncryptionConfig) encryptionKey() ([]byte, error) {
+	if c == nil || c.KeyFile == "" {
+		return nil, nil
+	}
+	return readKeyFile(c.KeyFile)
+}
+
+// readKeyFile reads a 256 bit key stored as raw bytes, hex or base64.
+func readKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 32 {
+		return data, nil
+	}
+	text := string(bytes.TrimSpace(data))
+	if key, err := hex.DecodeString(text); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	return nil, fmt.Errorf("key file %s does not contain a 256 bit key", path)
+}
+
+// The envelope format of client-side encrypted objects is
+//
+//	magic "FLOWYENC", version (1 byte), chunk size (4 bytes, little endian),
+//	wrapped data key nonce (12 bytes), wrapped data key (48 bytes),
+//	chunk nonce prefix (7 bytes), followed by chunks.
+//
+// A random data key is generated for every object and wrapped by the key
+// encryption key with AES-GCM, authenticating the preceding header bytes.
+// Every chunk holds up to chunk size bytes of plain text sealed by AES-GCM
+// with the data key. The nonce of a chunk is the prefix, the chunk index
+// (4 bytes, big endian) and a byte which is 1 only for the last chunk,
+// so that reordered, dropped or truncated chunks are detected.
+const (
+	envelopeMagic     = "FLOWYENC"
+	envelopeVersion   = 1
+	envelopeChunkSize = 64 * 1024
+	envelopeHeaderLen = 8 + 1 + 4 + 12 + 48 + 7
+)
+
+var errEnvelopeTruncated = errors.New("encrypted object is truncated")
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(prefix []byte, index uint32, last bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[7:11], index)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// encryptingWriter encrypts data written to it and writes the envelope to w.
+// Close writes the last chunk and closes w.
+type encryptingWriter struct {
+	writer io.WriteCloser
+	aead   cipher.AEAD
+	prefix []byte
+	index  uint32
+	buf    []byte
+	header []byte
+}
+
+func newEncryptingWriter(w io.WriteCloser, kek []byte) (*encryptingWriter, error) {
+	dataKey := make([]byte, 32)
+	keyNonce := make([]byte, 12)
+	prefix := make([]byte, 7)
+	for _, b := range [][]byte{dataKey, keyNonce, prefix} {
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+	}
+	kekAead, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, 0, envelopeHeaderLen)
+	header = append(header, envelopeMagic...)
+	header = append(header, envelopeVersion)
+	header = header[:13]
+	binary.LittleEndian.PutUint32(header[9:13], envelopeChunkSize)
+	header = append(header, keyNonce...)
+	header = kekAead.Seal(header, keyNonce, dataKey, append([]byte{}, header[:13]...))
+	header = append(header, prefix...)
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptingWriter{
+		writer: w,
+		aead:   aead,
+		prefix: prefix,
+		buf:    make([]byte, 0, envelopeChunkSize),
+		header: header,
+	}, nil
+}
+
+func (w *encryptingWriter) seal(last bool) error {
+	out := w.aead.Seal(w.header, chunkNonce(w.prefix, w.index, last), w.buf, nil)
+	w.header = nil
+	w.index++
+	w.buf = w.buf[:0]
+	_, err := w.writer.Write(out)
+	return err
+}
+
+func (w *encryptingWriter) Write(data []byte) (int, error) {
+	written := 0
+	for len(data) > 0 {
+		// a full chunk is sealed only when more data follows,
+		// because the last chunk must be marked as last.
+		if len(w.buf) == envelopeChunkSize {
+			if err := w.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := envelopeChunkSize - len(w.buf)
+		if n > len(data) {
+			n = len(data)
+		}
+		w.buf = append(w.buf, data[:n]...)
+		data = data[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (w *encryptingWriter) Close() error {
+	if err := w.seal(true); err != nil {
+		w.writer.Close()
+		return err
+	}
+	return w.writer.Close()
+}
+
+// decryptingReader reads an envelope from r and returns the plain text.
+type decryptingReader struct {
+	reader    *bufio.Reader
+	closer    io.Closer
+	kek       []byte
+	aead      cipher.AEAD
+	prefix    []byte
+	chunkSize int
+	index     uint32
+	chunk     []byte
+	buf       []byte
+	plain     []byte
+	done      bool
+}
+
+func newDecryptingReader(r io.ReadCloser, kek []byte) *decryptingReader {
+	return &decryptingReader{
+		reader: bufio.NewReaderSize(r, envelopeChunkSize+aes.BlockSize+1),
+		closer: r,
+		kek:    kek,
+	}
+}
+
+func (r *decryptingReader) readHeader() error {
+	header := make([]byte, envelopeHeaderLen)
+	if _, err := io.ReadFull(r.reader, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return errEnvelopeTruncated
+		}
+		return err
+	}
+	if string(header[:8]) != envelopeMagic || header[8] != envelopeVersion {
+		return fmt.Errorf("object is not encrypted by flowyexec")
+	}
+	r.chunkSize = int(binary.LittleEndian.Uint32(header[9:13]))
+	if r.chunkSize <= 0 || r.chunkSize > 16*1024*1024 {
+		return fmt.Errorf("invalid chunk size %d of encrypted object", r.chunkSize)
+	}
+	kekAead, err := newGCM(r.kek)
+	if err != nil {
+		return err
+	}
+	dataKey, err := kekAead.Open(nil, header[13:25], header[25:73], header[:13])
+	if err != nil {
+		return fmt.Errorf("cannot decrypt the data key, the key file may be wrong: %w", err)
+	}
+	r.aead, err = newGCM(dataKey)
+	if err != nil {
+		return err
+	}
+	r.prefix = header[73:80]
+	r.chunk = make([]byte, r.chunkSize+r.aead.Overhead())
+	return nil
+}
+
+func (r *decryptingReader) readChunk() error {
+	n, err := io.ReadFull(r.reader, r.chunk)
+	if err == io.EOF {
+		return errEnvelopeTruncated
+	}
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	last := err == io.ErrUnexpectedEOF
+	if !last {
+		if _, err := r.reader.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	}
+	r.buf, err = r.aead.Open(r.buf[:0], chunkNonce(r.prefix, r.index, last), r.chunk[:n], nil)
+	if err != nil {
+		return fmt.Errorf("encrypted chunk %d is corrupted or truncated", r.index)
+	}
+	r.plain = r.buf
+	r.index++
+	r.done = last
+	return nil
+}
+
+func (r *decryptingReader) Read(p []byte) (int, error) {
+	if r.aead == nil {
+		if err := r.readHeader(); err != nil {
+			return 0, err
+		}
+	}
+	for len(r.plain) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.readChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+func (r *decryptingReader) Close() error {
+	return r.closer.Close()
+}
@@ -0,0 +1,70 @@
+package workflow
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+func encrypt(t *testing.T, kek []byte, plain []byte) []byte {
+	var buf bytes.Buffer
+	w, err := newEncryptingWriter(nopWriteCloser{&buf}, kek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(plain); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decrypt(kek []byte, encrypted []byte) ([]byte, error) {
+	return io.ReadAll(newDecryptingReader(io.NopCloser(bytes.NewReader(encrypted)), kek))
+}
+
+func TestEnvelopeEncryption(t *testing.T) {
+	kek := bytes.Repeat([]byte{7}, 32)
+	for _, size := range []int{0, 1, envelopeChunkSize, 3*envelopeChunkSize + 5} {
+		plain := bytes.Repeat([]byte("ACGT"), size/4+1)[:size]
+		encrypted := encrypt(t, kek, plain)
+		decrypted, err := decrypt(kek, encrypted)
+		assert.NoError(t, err)
+		assert.Equal(t, plain, decrypted)
+		if size > envelopeChunkSize {
+			// drop the last chunk
+			_, err = decrypt(kek, encrypted[:len(encrypted)-size%envelopeChunkSize-16])
+			assert.Error(t, err, "truncated object must not be decrypted")
+			_, err = decrypt(kek, encrypted[:len(encrypted)-100])
+			assert.Error(t, err, "truncated object must not be decrypted")
+		}
+	}
+	encrypted := encrypt(t, kek, []byte("secret"))
+	_, err := decrypt(bytes.Repeat([]byte{8}, 32), encrypted)
+	assert.Error(t, err, "object must not be decrypted with a wrong key")
+}
+
+func TestReadKeyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key")
+	os.WriteFile(path, []byte("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f\n"), 0600)
+	key, err := readKeyFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, byte(0x1f), key[31])
+	os.WriteFile(path, []byte("short"), 0600)
+	_, err = readKeyFile(path)
+	assert.Error(t, err)
+}
@@ -0,0 +1,324 @@
+package workflow
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// expression is a parsed when expression of a job, e.g.
+//
+//	jobs.align.status == "Successed" && params.mode != "dry-run"
+//
+// It consists of string, number and boolean literals, references to
+// the results of jobs (jobs.<jobId>.status, .exitCode and .message)
+// and to the parameters of the workflow (params.<name>), the comparison
+// operators ==, !=, <, <=, > and >=, the logical operators &&, || and !
+// and parentheses. A value used as a condition is true unless it is
+// false, 0, an empty string or a missing parameter.
+type expression interface {
+	eval(ctx *expressionContext) interface{}
+}
+
+// expressionContext provides the values of references.
+type expressionContext struct {
+	jobs   func(jobId string) *JobResult
+	params map[string]interface{}
+}
+
+type literalExpr struct {
+	value interface{}
+}
+
+type referenceExpr struct {
+	path []string
+}
+
+type notExpr struct {
+	operand expression
+}
+
+type binaryExpr struct {
+	op    string
+	left  expression
+	right expression
+}
+
+func (e *literalExpr) eval(ctx *expressionContext) interface{} {
+	return e.value
+}
+
+func (e *referenceExpr) eval(ctx *expressionContext) interface{} {
+	if e.path[0] == "params" {
+		return normalizeValue(ctx.params[e.path[1]])
+	}
+	result := ctx.jobs(e.path[1])
+	switch e.path[2] {
+	case "status":
+		return result.Status.String()
+	case "exitCode":
+		return float64(result.ExitCode)
+	}
+	return result.Message
+}
+
+func (e *notExpr) eval(ctx *expressionContext) interface{} {
+	return !truthy(e.operand.eval(ctx))
+}
+
+func (e *binaryExpr) eval(ctx *expressionContext) interface{} {
+	switch e.op {
+	case "&&":
+		return truthy(e.left.eval(ctx)) && truthy(e.right.eval(ctx))
+	case "||":
+		return truthy(e.left.eval(ctx)) || truthy(e.right.eval(ctx))
+	}
+	left, right := e.left.eval(ctx), e.right.eval(ctx)
+	switch e.op {
+	case "==":
+		return left == right
+	case "!=":
+		return left != right
+	}
+	var cmp int
+	switch l := left.(type) {
+	case float64:
+		r, ok := right.(float64)
+		if !ok {
+			return false
+		}
+		if l < r {
+			cmp = -1
+		} else if l > r {
+			cmp = 1
+		}
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			return false
+		}
+		cmp = strings.Compare(l, r)
+	default:
+		return false
+	}
+	switch e.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	}
+	return cmp >= 0
+}
+
+// normalizeValue converts the numbers of parameters to float64,
+// so that they can be compared with number literals.
+// Values other than strings, numbers and booleans are treated as missing.
+func normalizeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case float64, string, bool:
+		return v
+	}
+	return nil
+}
+
+func truthy(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	}
+	return false
+}
+
+// jobReferences returns the ids of the jobs referenced by an expression.
+func jobReferences(e expression) []string {
+	switch e := e.(type) {
+	case *referenceExpr:
+		if e.path[0] == "jobs" {
+			return []string{e.path[1]}
+		}
+	case *notExpr:
+		return jobReferences(e.operand)
+	case *binaryExpr:
+		return append(jobReferences(e.left), jobReferences(e.right)...)
+	}
+	return nil
+}
+
+type exprParser struct {
+	tokens []string
+	pos    int
+}
+
+func parseExpression(text string) (expression, error) {
+	tokens, err := tokenizeExpression(text)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %s in expression %s", p.tokens[p.pos], text)
+	}
+	return e, nil
+}
+
+func tokenizeExpression(text string) ([]string, error) {
+	tokens := []string{}
+	for i := 0; i < len(text); {
+		c := rune(text[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '"':
+			end := i + 1
+			for end < len(text) && text[end] != '"' {
+				if text[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(text) {
+				return nil, fmt.Errorf("unterminated string in expression %s", text)
+			}
+			tokens = append(tokens, text[i:end+1])
+			i = end + 1
+		case strings.ContainsRune("()", c):
+			tokens = append(tokens, string(c))
+			i++
+		case strings.ContainsRune("=!<>&|", c):
+			op := text[i : i+1]
+			if i+1 < len(text) {
+				switch text[i : i+2] {
+				case "==", "!=", "<=", ">=", "&&", "||":
+					op = text[i : i+2]
+				}
+			}
+			if op == "=" || op == "&" || op == "|" {
+				return nil, fmt.Errorf("unexpected %s in expression %s", op, text)
+			}
+			tokens = append(tokens, op)
+			i += len(op)
+		case isWordChar(c):
+			end := i
+			for end < len(text) && (isWordChar(rune(text[end])) || text[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, text[i:end])
+			i = end
+		default:
+			return nil, fmt.Errorf("unexpected %c in expression %s", c, text)
+		}
+	}
+	return tokens, nil
+}
+
+func isWordChar(c rune) bool {
+	return c == '_' || c == '-' || c == '+' || unicode.IsLetter(c) || unicode.IsDigit(c)
+}
+
+func (p *exprParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *exprParser) parseOr() (expression, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.peek() == "||" {
+		p.pos++
+		var right expression
+		right, err = p.parseAnd()
+		left = &binaryExpr{op: "||", left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *exprParser) parseAnd() (expression, error) {
+	left, err := p.parseNot()
+	for err == nil && p.peek() == "&&" {
+		p.pos++
+		var right expression
+		right, err = p.parseNot()
+		left = &binaryExpr{op: "&&", left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *exprParser) parseNot() (expression, error) {
+	if p.peek() == "!" {
+		p.pos++
+		operand, err := p.parseNot()
+		return &notExpr{operand: operand}, err
+	}
+	return p.parseComparison()
+}
+
+func (p *exprParser) parseComparison() (expression, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	switch op := p.peek(); op {
+	case "==", "!=", "<", "<=", ">", ">=":
+		p.pos++
+		right, err := p.parsePrimary()
+		return &binaryExpr{op: op, left: left, right: right}, err
+	}
+	return left, nil
+}
+
+func (p *exprParser) parsePrimary() (expression, error) {
+	token := p.peek()
+	p.pos++
+	switch {
+	case token == "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	case token == "(":
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("missing ) in expression")
+		}
+		p.pos++
+		return e, nil
+	case token[0] == '"':
+		s, err := strconv.Unquote(token)
+		if err != nil {
+			return nil, fmt.Errorf("invalid string %s in expression", token)
+		}
+		return &literalExpr{value: s}, nil
+	case token == "true" || token == "false":
+		return &literalExpr{value: token == "true"}, nil
+	case unicode.IsDigit(rune(token[0])) || token[0] == '-' || token[0] == '+':
+		n, err := strconv.ParseFloat(token, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s in expression", token)
+		}
+		return &literalExpr{value: n}, nil
+	}
+	path := strings.Split(token, ".")
+	switch {
+	case path[0] == "params" && len(path) == 2:
+	case path[0] == "jobs" && len(path) == 3 && (path[2] == "status" || path[2] == "exitCode" || path[2] == "message"):
+	default:
+		return nil, fmt.Errorf("invalid reference %s in expression", token)
+	}
+	return &referenceExpr{path: path}, nil
+}
@@ -0,0 +1,72 @@
+package workflow
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpression(t *testing.T) {
+	results := map[string]*JobResult{
+		"align": {JobId: "align", Status: Successed, ExitCode: 0},
+		"grep":  {JobId: "grep", Status: Successed, ExitCode: 1, Message: "no match"},
+		"fail":  {JobId: "fail", Status: Failed, ExitCode: 2},
+	}
+	ctx := &expressionContext{
+		jobs: func(jobId string) *JobResult {
+			return results[jobId]
+		},
+		params: map[string]interface{}{
+			"mode":    "full",
+			"threads": float64(8),
+			"dryRun":  false,
+			"list":    []interface{}{"a"},
+		},
+	}
+	tests := []struct {
+		expr  string
+		value bool
+	}{
+		{`jobs.align.status == "Successed"`, true},
+		{`jobs.fail.status == "Successed"`, false},
+		{`jobs.grep.exitCode == 1 && jobs.grep.message == "no match"`, true},
+		{`jobs.fail.exitCode > 1`, true},
+		{`params.threads >= 8 && params.threads < 16`, true},
+		{`params.mode != "full" || params.threads <= 4`, false},
+		{`!params.dryRun`, true},
+		{`params.missing`, false},
+		{`params.list == "a"`, false},
+		{`params.mode > 1`, false},
+		{`params.mode == "full" && (jobs.fail.status == "Successed" || true)`, true},
+		{`!(params.threads == 8)`, false},
+		{`"b" > "a"`, true},
+	}
+	for _, test := range tests {
+		e, err := parseExpression(test.expr)
+		if assert.NoError(t, err, test.expr) {
+			assert.Equal(t, test.value, truthy(e.eval(ctx)), test.expr)
+		}
+	}
+}
+
+func TestExpressionReferences(t *testing.T) {
+	e, err := parseExpression(`jobs.a.status == "Successed" && (params.x || jobs.b-1.exitCode == 0)`)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b-1"}, jobReferences(e))
+}
+
+func TestInvalidExpression(t *testing.T) {
+	for _, expr := range []string{
+		`jobs.a == 1`,
+		`jobs.a.status =`,
+		`params.a = 1`,
+		`(params.a`,
+		`params.a == "b`,
+		`params.a == 1 1`,
+		`steps.a.status`,
+		`params.a $ 1`,
+	} {
+		_, err := parseExpression(expr)
+		assert.Error(t, err, expr)
+	}
+}
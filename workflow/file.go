@@ -0,0 +1,155 @@
+package workflow
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// FileSourceJob streams a local file to a pipe.
+type FileSourceJob struct {
+	streamJob
+	path string
+}
+
+// FileSinkJob writes a pipe to a local file.
+// The data is written to a temporary file in the same directory,
+// which is renamed to path when the workflow finishes, unless the job
+// or the job writing the pipe has failed, so that path never holds
+// a partial file.
+type FileSinkJob struct {
+	streamJob
+	path  string
+	mode  os.FileMode
+	fsync bool
+	temp  string
+}
+
+// fileSinkInput commits the file of a FileSinkJob when it is cleared,
+// which is after PipeHandler.Finished has aborted the job if the job
+// writing the pipe has failed.
+type fileSinkInput struct {
+	*pipeInput
+	job *FileSinkJob
+}
+
+func CreateFileJob(jobDto *JobDto) Job {
+	if jobDto.ReadFrom != "" {
+		job := &FileSinkJob{
+			streamJob: streamJob{jobId: jobDto.JobId, status: Created},
+			path:      jobDto.Path,
+			mode:      0644,
+			fsync:     jobDto.Fsync,
+		}
+		if jobDto.Mode != "" {
+			// the mode has been validated by LoadWorkflow
+			mode, _ := strconv.ParseUint(jobDto.Mode, 8, 32)
+			job.mode = os.FileMode(mode)
+		}
+		job.addInput(jobDto.ReadFrom)
+		return job
+	}
+	job := &FileSourceJob{
+		streamJob: streamJob{jobId: jobDto.JobId, status: Created},
+		path:      jobDto.Path,
+	}
+	job.addOutput(jobDto.WriteTo)
+	return job
+}
+
+func (job *FileSourceJob) Execute(wf *Workflow, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer wf.UnBlock()
+	if !job.begin() {
+		return
+	}
+	job.finish(job.read())
+}
+
+func (job *FileSourceJob) read() error {
+	f, err := os.Open(job.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(job.outputs[0].writer, f)
+	return err
+}
+
+func (job *FileSinkJob) GetInputs() []Input {
+	return []Input{&fileSinkInput{pipeInput: job.inputs[0], job: job}}
+}
+
+func (job *FileSinkJob) Execute(wf *Workflow, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer wf.UnBlock()
+	if !job.begin() {
+		return
+	}
+	job.finish(job.write())
+}
+
+func (job *FileSinkJob) write() error {
+	if err := os.MkdirAll(filepath.Dir(job.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(job.path), "."+filepath.Base(job.path)+".*"+localTempSuffix)
+	if err != nil {
+		return err
+	}
+	job.mu.Lock()
+	job.temp = f.Name()
+	job.mu.Unlock()
+	_, err = io.Copy(f, job.inputs[0].reader)
+	if err == nil && job.fsync {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = f.Chmod(job.mode)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// commit renames the temporary file to the path of the job if the job
+// has succeeded, and removes it otherwise.
+func (job *FileSinkJob) commit() {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	if job.temp == "" {
+		return
+	}
+	temp := job.temp
+	job.temp = ""
+	if job.status != Successed {
+		os.Remove(temp)
+		return
+	}
+	err := os.Rename(temp, job.path)
+	if err == nil && job.fsync {
+		err = syncDir(filepath.Dir(job.path))
+	}
+	if err != nil {
+		os.Remove(temp)
+		job.status = Failed
+		job.message = err.Error()
+	}
+}
+
+// syncDir flushes the directory entries of dir, which makes a rename durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func (s *fileSinkInput) Clear() {
+	s.job.commit()
+}
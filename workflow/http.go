@@ -0,0 +1,232 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// httpMaxAttempts is the number of attempts of an HTTP download,
+// which is resumed from the received length after a network error.
+const httpMaxAttempts = 5
+
+var httpRetryDelay = time.Second
+
+// HTTPSourceJob streams the body of a GET request to a pipe.
+// If the connection is lost, the download is resumed with a Range request.
+type HTTPSourceJob struct {
+	streamJob
+	url            string
+	headers        map[string]string
+	headersFromEnv map[string]string
+	ctx            context.Context
+}
+
+// HTTPSinkJob streams a pipe as the body of a PUT or POST request
+// with the chunked transfer encoding.
+type HTTPSinkJob struct {
+	streamJob
+	url            string
+	method         string
+	headers        map[string]string
+	headersFromEnv map[string]string
+	ctx            context.Context
+}
+
+func CreateHTTPJob(jobDto *JobDto) Job {
+	ctx, cancel := context.WithCancel(context.Background())
+	if jobDto.ReadFrom != "" {
+		job := &HTTPSinkJob{
+			streamJob:      streamJob{jobId: jobDto.JobId, status: Created, onAbort: cancel},
+			url:            jobDto.Url,
+			method:         jobDto.Method,
+			headers:        jobDto.Headers,
+			headersFromEnv: jobDto.HeadersFromEnv,
+			ctx:            ctx,
+		}
+		if job.method == "" {
+			job.method = http.MethodPut
+		}
+		job.addInput(jobDto.ReadFrom)
+		return job
+	}
+	job := &HTTPSourceJob{
+		streamJob:      streamJob{jobId: jobDto.JobId, status: Created, onAbort: cancel},
+		url:            jobDto.Url,
+		headers:        jobDto.Headers,
+		headersFromEnv: jobDto.HeadersFromEnv,
+		ctx:            ctx,
+	}
+	job.addOutput(jobDto.WriteTo)
+	return job
+}
+
+// newHTTPRequest creates a request with the headers of a job, whose values
+// are sent as they are, and the headers whose values are read from the
+// environment variables named by headersFromEnv.
+func newHTTPRequest(ctx context.Context, method string, url string, headers map[string]string, headersFromEnv map[string]string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	for k, name := range headersFromEnv {
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return nil, fmt.Errorf("environment variable %s of header %s is not set", name, k)
+		}
+		req.Header.Set(k, v)
+	}
+	return req, nil
+}
+
+// httpStatusError returns an error describing an unexpected response.
+func httpStatusError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	message := strings.TrimSpace(string(body))
+	if message == "" {
+		return fmt.Errorf("%s %s: %s", resp.Request.Method, resp.Request.URL, resp.Status)
+	}
+	return fmt.Errorf("%s %s: %s: %s", resp.Request.Method, resp.Request.URL, resp.Status, message)
+}
+
+func (job *HTTPSourceJob) Execute(wf *Workflow, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer wf.UnBlock()
+	if !job.begin() {
+		return
+	}
+	job.finish(job.download())
+}
+
+// noRetryError wraps an error which is not recovered by resuming the download,
+// such as an error writing to the output or an error status.
+type noRetryError struct {
+	err error
+}
+
+func (e *noRetryError) Error() string {
+	return e.err.Error()
+}
+
+func (job *HTTPSourceJob) download() error {
+	writer := job.outputs[0].writer
+	var received int64
+	var validator string
+	var err error
+	for attempt := 1; ; attempt++ {
+		var n int64
+		n, validator, err = job.get(writer, received, validator)
+		received += n
+		if err == nil {
+			return nil
+		}
+		var noRetry *noRetryError
+		if errors.As(err, &noRetry) {
+			return noRetry.err
+		}
+		if job.ctx.Err() != nil || attempt == httpMaxAttempts {
+			return err
+		}
+		job.log().WithFields(logrus.Fields{"received": received}).WithError(err).Warn("Resume HTTP download")
+		select {
+		case <-time.After(httpRetryDelay * time.Duration(attempt)):
+		case <-job.ctx.Done():
+			return job.ctx.Err()
+		}
+	}
+}
+
+// get requests the body from offset and copies it to w.
+// validator is the ETag or Last-Modified of the first response,
+// which ensures that a resumed download reads the same resource.
+func (job *HTTPSourceJob) get(w io.Writer, offset int64, validator string) (int64, string, error) {
+	req, err := newHTTPRequest(job.ctx, http.MethodGet, job.url, job.headers, job.headersFromEnv, nil)
+	if err != nil {
+		return 0, validator, &noRetryError{err}
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		if validator != "" {
+			req.Header.Set("If-Range", validator)
+		}
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, validator, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case offset == 0 && resp.StatusCode == http.StatusOK:
+		validator = resp.Header.Get("ETag")
+		if validator == "" {
+			validator = resp.Header.Get("Last-Modified")
+		}
+	case offset > 0 && resp.StatusCode == http.StatusPartialContent:
+		if !strings.HasPrefix(resp.Header.Get("Content-Range"), "bytes "+strconv.FormatInt(offset, 10)+"-") {
+			return 0, validator, &noRetryError{fmt.Errorf("GET %s: unexpected Content-Range %s", job.url, resp.Header.Get("Content-Range"))}
+		}
+	case offset > 0 && resp.StatusCode == http.StatusOK:
+		return 0, validator, &noRetryError{fmt.Errorf("GET %s: cannot resume the download, the server does not support ranges or the resource has changed", job.url)}
+	case resp.StatusCode >= 500:
+		return 0, validator, httpStatusError(resp)
+	default:
+		return 0, validator, &noRetryError{httpStatusError(resp)}
+	}
+	var written int64
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return written, validator, &noRetryError{err}
+			}
+			written += int64(n)
+		}
+		if err == io.EOF {
+			return written, validator, nil
+		}
+		if err != nil {
+			return written, validator, err
+		}
+	}
+}
+
+func (job *HTTPSinkJob) Execute(wf *Workflow, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer wf.UnBlock()
+	if !job.begin() {
+		return
+	}
+	job.finish(job.upload())
+}
+
+func (job *HTTPSinkJob) upload() error {
+	// the length of the body is unknown,
+	// so net/http sends it with the chunked transfer encoding.
+	req, err := newHTTPRequest(job.ctx, job.method, job.url, job.headers, job.headersFromEnv, job.inputs[0].reader)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return httpStatusError(resp)
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
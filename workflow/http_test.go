@@ -0,0 +1,139 @@
+package workflow
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newHTTPTestServer serves data at /data, dropping the connection of the
+// first request in the middle of the body, and stores the body of
+// requests to /upload.
+func newHTTPTestServer(t *testing.T, data []byte) (*httptest.Server, func() ([]byte, []string)) {
+	var mu sync.Mutex
+	var uploaded []byte
+	var encodings []string
+	dropped := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/data":
+			w.Header().Set("ETag", `"v1"`)
+			mu.Lock()
+			drop := !dropped
+			dropped = true
+			mu.Unlock()
+			if drop {
+				w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+				w.Write(data[:len(data)/2])
+				w.(http.Flusher).Flush()
+				conn, _, _ := w.(http.Hijacker).Hijack()
+				conn.Close()
+				return
+			}
+			http.ServeContent(w, r, "data", time.Time{}, bytes.NewReader(data))
+		case "/upload":
+			if r.Header.Get("Authorization") != "Bearer secret" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			body, _ := io.ReadAll(r.Body)
+			mu.Lock()
+			uploaded = body
+			encodings = r.TransferEncoding
+			mu.Unlock()
+			w.WriteHeader(http.StatusCreated)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, func() ([]byte, []string) {
+		mu.Lock()
+		defer mu.Unlock()
+		return uploaded, encodings
+	}
+}
+
+func runHTTPWorkflow(t *testing.T, source string, sink string) *WorkflowResult {
+	wf, err := LoadWorkflow(strings.NewReader(fmt.Sprintf(`{"jobs": [
+		{"jobId": "get", "type": "HTTP", "url": %q, "writeTo": "DATA"},
+		{"jobId": "put", "type": "HTTP", "url": %q, "readFrom": "DATA", "headersFromEnv": {"Authorization": "FLOWY_TEST_TOKEN"}}
+	]}`, source, sink)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wf.Execute(make(chan Event, 10))
+}
+
+func TestHTTPResumeAndUpload(t *testing.T) {
+	httpRetryDelay = 10 * time.Millisecond
+	t.Setenv("FLOWY_TEST_TOKEN", "Bearer secret")
+	data := bytes.Repeat([]byte("0123456789abcdef"), 256*1024)
+	server, uploaded := newHTTPTestServer(t, data)
+	result := runHTTPWorkflow(t, server.URL+"/data", server.URL+"/upload")
+	assertWorkflowResult(t, &WorkflowResult{
+		Status: Successed,
+		Results: []*JobResult{
+			{JobId: "get", Status: Successed, ExitCode: 0},
+			{JobId: "put", Status: Successed, ExitCode: 0},
+		},
+	}, result)
+	body, encodings := uploaded()
+	assert.True(t, bytes.Equal(data, body), "uploaded %d bytes of %d", len(body), len(data))
+	assert.Equal(t, []string{"chunked"}, encodings)
+}
+
+func TestHTTPErrorStatus(t *testing.T) {
+	httpRetryDelay = 10 * time.Millisecond
+	t.Setenv("FLOWY_TEST_TOKEN", "Bearer wrong")
+	server, _ := newHTTPTestServer(t, []byte("data"))
+
+	result := runHTTPWorkflow(t, server.URL+"/missing", server.URL+"/upload")
+	assert.Equal(t, Failed, result.Status)
+	assert.Equal(t, Failed, result.Results[0].Status)
+	assert.Contains(t, result.Results[0].Message, "404 Not Found")
+
+	result = runHTTPWorkflow(t, server.URL+"/data", server.URL+"/upload")
+	assert.Equal(t, Failed, result.Status)
+	assert.Equal(t, Failed, result.Results[1].Status)
+	assert.Contains(t, result.Results[1].Message, "403 Forbidden")
+}
+
+func TestHTTPHeaders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s", r.Header.Get("X-Signature"), r.Header.Get("Authorization"))
+	}))
+	defer server.Close()
+	t.Setenv("FLOWY_TEST_TOKEN", "Bearer secret")
+	out := filepath.Join(t.TempDir(), "headers.txt")
+	wf, err := LoadWorkflow(strings.NewReader(fmt.Sprintf(`{"jobs": [
+		{"jobId": "get", "type": "HTTP", "url": %q, "writeTo": "DATA",
+		 "headers": {"X-Signature": "a$b${FLOWY_TEST_TOKEN}"}, "headersFromEnv": {"Authorization": "FLOWY_TEST_TOKEN"}},
+		{"jobId": "sink", "type": "File", "readFrom": "DATA", "path": %q}
+	]}`, server.URL, out)))
+	assert.NoError(t, err)
+	assert.Equal(t, Successed, wf.Execute(make(chan Event, 10)).Status)
+	data, err := os.ReadFile(out)
+	assert.NoError(t, err)
+	// the values of headers are sent as they are
+	assert.Equal(t, "a$b${FLOWY_TEST_TOKEN} Bearer secret", string(data))
+
+	wf, err = LoadWorkflow(strings.NewReader(fmt.Sprintf(`{"jobs": [
+		{"jobId": "get", "type": "HTTP", "url": %q, "writeTo": "DATA", "headersFromEnv": {"Authorization": "FLOWY_TEST_UNSET"}},
+		{"jobId": "sink", "type": "File", "readFrom": "DATA", "path": %q}
+	]}`, server.URL, out)))
+	assert.NoError(t, err)
+	result := wf.Execute(make(chan Event, 10))
+	assert.Equal(t, Failed, result.Results[0].Status)
+	assert.Equal(t, "environment variable FLOWY_TEST_UNSET of header Authorization is not set", result.Results[0].Message)
+}
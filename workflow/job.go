@@ -16,6 +16,9 @@ const (
 	Successed
 	Failed
 	Aborted
+	// Skipped means that the job has not run because of its when expression
+	// or because the jobs it depends on have been skipped.
+	Skipped
 )
 
 var job_statuses = []JobStatus{
@@ -24,12 +27,13 @@ var job_statuses = []JobStatus{
 	Successed,
 	Failed,
 	Aborted,
+	Skipped,
 }
 
 type FileType int
 
 func (j JobStatus) IsFinished() bool {
-	return j == Successed || j == Failed || j == Aborted
+	return j == Successed || j == Failed || j == Aborted || j == Skipped
 }
 func (j JobStatus) IsFailed() bool {
 	return j == Failed || j == Aborted
@@ -46,6 +50,8 @@ func (r JobStatus) String() string {
 		return "Failed"
 	case Aborted:
 		return "Aborted"
+	case Skipped:
+		return "Skipped"
 	default:
 		return "unknown"
 	}
@@ -78,6 +84,8 @@ func (r JobStatus) GetDefaultExitCode() int {
 		return -1
 	case Aborted:
 		return -1
+	case Skipped:
+		return 0
 	default:
 		return -123
 	}
@@ -90,6 +98,12 @@ type JobResult struct {
 	End      *time.Time
 	ExitCode int
 	Message  string
+	// Signal is the name of the signal which has killed the job.
+	Signal string `json:",omitempty"`
+	// Attempts are the results of all the attempts of a retried job.
+	Attempts []*JobAttempt `json:",omitempty"`
+	// Jobs are the results of the jobs of the workflow run by the job.
+	Jobs []*JobResult `json:",omitempty"`
 }
 type EventType int
 
@@ -115,6 +129,19 @@ func (*JobEvent) GetEventType() EventType {
 	return JobEvents
 }
 
+// PipeEvent reports the progress of the transfer of a pipe. It is sent
+// periodically while the data is transferred, and Finished is set
+// on the last event of the pipe.
+type PipeEvent struct {
+	Pipe     *PipeResult
+	Occured  time.Time
+	Finished bool
+}
+
+func (*PipeEvent) GetEventType() EventType {
+	return PipeEvents
+}
+
 type Stream interface {
 	Abort()
 	Clear()
@@ -137,6 +164,9 @@ type Job interface {
 	GetOutputs() []Output
 	Execute(wf *Workflow, wg *sync.WaitGroup)
 	Abort()
+	// Skip marks the job as skipped before it is executed.
+	// Its inputs discard the data written to them.
+	Skip()
 	GetStatus() JobStatus
 	GetResult() *JobResult
 }
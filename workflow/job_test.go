@@ -0,0 +1,21 @@
+package workflow
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobStatusJSON(t *testing.T) {
+	for _, status := range job_statuses {
+		data, err := json.Marshal(status)
+		assert.NoError(t, err)
+		assert.Equal(t, `"`+status.String()+`"`, string(data))
+		var decoded JobStatus
+		assert.NoError(t, json.Unmarshal(data, &decoded))
+		assert.Equal(t, status, decoded)
+	}
+	assert.True(t, Skipped.IsFinished())
+	assert.False(t, Skipped.IsFailed())
+}
@@ -0,0 +1,37 @@
+package workflow
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"sync"
+)
+
+// LiteralJob streams data embedded in the workflow to a pipe.
+type LiteralJob struct {
+	streamJob
+	content []byte
+}
+
+func CreateLiteralJob(jobDto *JobDto) Job {
+	job := &LiteralJob{
+		streamJob: streamJob{jobId: jobDto.JobId, status: Created},
+		content:   []byte(jobDto.Content),
+	}
+	if jobDto.ContentBase64 != "" {
+		// the content has been validated by LoadWorkflow
+		job.content, _ = base64.StdEncoding.DecodeString(jobDto.ContentBase64)
+	}
+	job.addOutput(jobDto.WriteTo)
+	return job
+}
+
+func (job *LiteralJob) Execute(wf *Workflow, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer wf.UnBlock()
+	if !job.begin() {
+		return
+	}
+	_, err := io.Copy(job.outputs[0].writer, bytes.NewReader(job.content))
+	job.finish(err)
+}
@@ -0,0 +1,218 @@
+package workflow
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// LogOptions configures a logger created by NewLogger.
+type LogOptions struct {
+	// Level is the minimum level of the log lines, e.g. debug, info or warn.
+	// It defaults to info.
+	Level string
+	// Format is the format of the log lines, json or text. It defaults to json.
+	Format string
+	// File is the file the log lines are appended to instead of stderr.
+	File string
+}
+
+// NewLogger creates a logger configured by options.
+func NewLogger(options LogOptions) (*logrus.Logger, error) {
+	logger := logrus.New()
+	if options.Level != "" {
+		level, err := logrus.ParseLevel(options.Level)
+		if err != nil {
+			return nil, err
+		}
+		logger.SetLevel(level)
+	}
+	switch options.Format {
+	case "", "json":
+		logger.SetFormatter(&logrus.JSONFormatter{DisableTimestamp: true})
+	case "text":
+		logger.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+	default:
+		return nil, fmt.Errorf("unsupported log format %s", options.Format)
+	}
+	if options.File != "" {
+		f, err := os.OpenFile(options.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		logger.SetOutput(f)
+	}
+	return logger, nil
+}
+
+// logger returns the logger of the workflow.
+func (w *Workflow) logger() *logrus.Logger {
+	if w.Logger != nil {
+		return w.Logger
+	}
+	return logrus.StandardLogger()
+}
+
+// log returns the entry tagging the log lines of the workflow with its run id.
+func (w *Workflow) log() *logrus.Entry {
+	w.mu.Lock()
+	logger := w.runLogger
+	w.mu.Unlock()
+	if logger == nil {
+		logger = w.logger()
+	}
+	return logger.WithField("runId", w.RunId)
+}
+
+// jobLog returns the entry tagging the log lines of a job with the run id
+// and the job id. The jobs created without a workflow log to the standard logger.
+func (w *Workflow) jobLog(jobId string) *logrus.Entry {
+	if w == nil {
+		return logrus.WithField("jobId", jobId)
+	}
+	return w.log().WithField("jobId", jobId)
+}
+
+// startLogs prepares the logger of an execution of the workflow,
+// which writes the log lines of the jobs to their log files if JobLogs is set.
+func (w *Workflow) startLogs() {
+	logger := w.logger()
+	var files *jobLogFiles
+	if w.JobLogs {
+		files = &jobLogFiles{dir: w.dir, formatter: logger.Formatter, files: map[string]*os.File{}}
+		hooks := logrus.LevelHooks{}
+		for level, levelHooks := range logger.Hooks {
+			hooks[level] = append([]logrus.Hook{}, levelHooks...)
+		}
+		hooks.Add(files)
+		logger = &logrus.Logger{
+			Out:          logger.Out,
+			Hooks:        hooks,
+			Formatter:    logger.Formatter,
+			ReportCaller: logger.ReportCaller,
+			Level:        logger.GetLevel(),
+			ExitFunc:     logger.ExitFunc,
+		}
+	}
+	w.mu.Lock()
+	w.runLogger = logger
+	w.jobLogs = files
+	w.mu.Unlock()
+}
+
+// stopLogs closes the log files of the jobs.
+func (w *Workflow) stopLogs() {
+	w.mu.Lock()
+	files := w.jobLogs
+	w.mu.Unlock()
+	if files != nil {
+		files.close()
+	}
+}
+
+// jobLogFile returns the log file of a job, to which the output of its
+// command is written, or nil if the jobs have no log files.
+func (w *Workflow) jobLogFile(jobId string) (*os.File, error) {
+	w.mu.Lock()
+	files := w.jobLogs
+	w.mu.Unlock()
+	if files == nil {
+		return nil, nil
+	}
+	return files.file(jobId)
+}
+
+// jobLogFiles is the hook writing the log lines of each job to
+// <dir>/<jobId>.log. The files are opened when the first lines are written
+// and appended to by the attempts of the jobs which are retried.
+type jobLogFiles struct {
+	dir       string
+	formatter logrus.Formatter
+	mu        sync.Mutex
+	files     map[string]*os.File
+	closed    bool
+}
+
+func (h *jobLogFiles) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *jobLogFiles) Fire(entry *logrus.Entry) error {
+	jobId, ok := entry.Data["jobId"].(string)
+	if !ok || isReservedJobId(jobId) {
+		return nil
+	}
+	line, err := h.formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+	f, err := h.file(jobId)
+	if err != nil || f == nil {
+		return err
+	}
+	_, err = f.Write(line)
+	return err
+}
+
+// file returns the log file of a job, or nil if the files have been closed.
+func (h *jobLogFiles) file(jobId string) (*os.File, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil, nil
+	}
+	if f, ok := h.files[jobId]; ok {
+		return f, nil
+	}
+	f, err := os.OpenFile(inDir(h.dir, jobId+".log"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	h.files[jobId] = f
+	return f, nil
+}
+
+func (h *jobLogFiles) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for _, f := range h.files {
+		f.Close()
+	}
+}
+
+// progressInterval is the minimum interval between the progress
+// log lines of a transfer.
+var progressInterval = 10 * time.Second
+
+// progressLog throttles the progress log lines or events of a transfer
+// to one per interval, which is progressInterval if it is zero.
+type progressLog struct {
+	mu       sync.Mutex
+	last     time.Time
+	interval time.Duration
+}
+
+// due reports whether a progress line is to be logged now,
+// an interval after the transfer has started or the previous line.
+func (p *progressLog) due() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	if p.last.IsZero() {
+		p.last = now
+		return false
+	}
+	interval := p.interval
+	if interval == 0 {
+		interval = progressInterval
+	}
+	if now.Sub(p.last) < interval {
+		return false
+	}
+	p.last = now
+	return true
+}
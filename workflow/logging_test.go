@@ -0,0 +1,66 @@
+package workflow
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobLogs(t *testing.T) {
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "flowyexec.log")
+	logger, err := NewLogger(LogOptions{Level: "debug", Format: "json", File: logFile})
+	assert.NoError(t, err)
+	out := filepath.Join(dir, "out.txt")
+	workflow, err := LoadWorkflow(strings.NewReader(`{"jobs": [
+		{"jobId": "echo", "command": ["sh", "-c", "echo hello; echo oops >&2; echo x > ` + dir + `/fifo1"],
+		 "outputs": [{"writeTo": "TEXT", "path": "` + dir + `/fifo1"}]},
+		{"jobId": "sink", "type": "File", "readFrom": "TEXT", "path": "` + out + `"}
+	]}`))
+	assert.NoError(t, err)
+	workflow.dir = dir
+	workflow.Logger = logger
+	workflow.JobLogs = true
+	result := workflow.Execute(make(chan Event, 10))
+	assert.Equal(t, Successed, result.Status)
+
+	f, err := os.Open(logFile)
+	assert.NoError(t, err)
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	lines := 0
+	for scanner.Scan() {
+		var line map[string]interface{}
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+		assert.Equal(t, workflow.RunId, line["runId"], scanner.Text())
+		assert.Contains(t, line, "jobId", scanner.Text())
+		lines++
+	}
+	assert.Greater(t, lines, 0)
+
+	data, err := os.ReadFile(filepath.Join(dir, "echo.log"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"msg":"Start Job"`)
+	assert.Contains(t, string(data), "hello\noops\n")
+	assert.Contains(t, string(data), `"msg":"Finished Job"`)
+	data, err = os.ReadFile(filepath.Join(dir, "sink.log"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"jobId":"sink"`)
+	assert.NotContains(t, string(data), `"jobId":"echo"`)
+}
+
+func TestNewLogger(t *testing.T) {
+	_, err := NewLogger(LogOptions{Format: "xml"})
+	assert.EqualError(t, err, "unsupported log format xml")
+	_, err = NewLogger(LogOptions{Level: "verbose"})
+	assert.Error(t, err)
+	logger, err := NewLogger(LogOptions{Level: "warn", Format: "text"})
+	assert.NoError(t, err)
+	assert.False(t, logger.IsLevelEnabled(logrus.InfoLevel))
+}
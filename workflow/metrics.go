@@ -0,0 +1,107 @@
+package workflow
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// The metrics of the workflows executed by the process are registered
+// to the default Prometheus registry and served by MetricsHandler.
+var (
+	jobsRunning = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "flowyexec",
+		Name:      "jobs_running",
+		Help:      "Number of jobs running.",
+	})
+	jobsFinished = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "flowyexec",
+		Name:      "jobs_finished_total",
+		Help:      "Number of jobs finished by status.",
+	}, []string{"status"})
+	jobDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "flowyexec",
+		Name:      "job_duration_seconds",
+		Help:      "Duration of the jobs which have run by status.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 4, 10),
+	}, []string{"status"})
+	pipeBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "flowyexec",
+		Name:      "pipe_bytes_total",
+		Help:      "Bytes transferred from the producers of the pipes by key.",
+	}, []string{"key"})
+	pipeThroughput = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "flowyexec",
+		Name:      "pipe_throughput_bytes_per_second",
+		Help:      "Throughput of the last finished transfer of the pipes by key.",
+	}, []string{"key"})
+	s3Requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "flowyexec",
+		Name:      "s3_requests_total",
+		Help:      "Number of S3 requests by operation.",
+	}, []string{"operation"})
+	s3RequestErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "flowyexec",
+		Name:      "s3_request_errors_total",
+		Help:      "Number of failed S3 requests by operation.",
+	}, []string{"operation"})
+	s3RequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "flowyexec",
+		Name:      "s3_request_duration_seconds",
+		Help:      "Latency of the S3 requests by operation, including retries.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+	multipartPartsInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "flowyexec",
+		Name:      "s3_multipart_parts_in_flight",
+		Help:      "Number of parts of multipart uploads being uploaded.",
+	})
+	fifoOpenWait = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "flowyexec",
+		Name:      "fifo_open_wait_seconds",
+		Help:      "Time waiting for the other end of the FIFOs to be opened by mode, read or write.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+	}, []string{"mode"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		jobsRunning,
+		jobsFinished,
+		jobDuration,
+		pipeBytes,
+		pipeThroughput,
+		s3Requests,
+		s3RequestErrors,
+		s3RequestDuration,
+		multipartPartsInFlight,
+		fifoOpenWait,
+	)
+}
+
+// MetricsHandler returns the handler serving the metrics in the Prometheus text format.
+func MetricsHandler() http.Handler {
+	return promhttp.Handler()
+}
+
+// observeJob records the result of a finished job.
+func observeJob(result *JobResult) {
+	status := result.Status.String()
+	jobsFinished.WithLabelValues(status).Inc()
+	if result.Status != Skipped && result.Start != nil && result.End != nil && !result.Start.IsZero() {
+		jobDuration.WithLabelValues(status).Observe(result.End.Sub(*result.Start).Seconds())
+	}
+}
+
+// observeS3Request records an S3 request when it has completed.
+func observeS3Request(r *request.Request) {
+	operation := r.Operation.Name
+	s3Requests.WithLabelValues(operation).Inc()
+	s3RequestDuration.WithLabelValues(operation).Observe(time.Since(r.Time).Seconds())
+	if r.Error != nil {
+		s3RequestErrors.WithLabelValues(operation).Inc()
+	}
+}
@@ -0,0 +1,24 @@
+package workflow
+
+import (
+	"os"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	finished := testutil.ToFloat64(jobsFinished.WithLabelValues("Successed"))
+	transferred := testutil.ToFloat64(pipeBytes.WithLabelValues("BED"))
+	j, err := os.Open("../testdata/literal.json")
+	assert.NoError(t, err)
+	workflow, err := LoadWorkflow(j)
+	assert.NoError(t, err)
+	result := workflow.Execute(make(chan Event, 10))
+	assert.Equal(t, Successed, result.Status)
+	assert.Equal(t, finished+3, testutil.ToFloat64(jobsFinished.WithLabelValues("Successed")))
+	assert.Equal(t, transferred+float64(len("chr1\t100\t200\nchr2\t300\t400\n")), testutil.ToFloat64(pipeBytes.WithLabelValues("BED")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(jobsRunning))
+	assert.Greater(t, testutil.CollectAndCount(fifoOpenWait), 0)
+}
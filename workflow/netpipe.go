@@ -0,0 +1,381 @@
+package workflow
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A pipe between jobs on different hosts is carried by a TCP connection
+// between a NetSend job, which reads the pipe on the host of the producer,
+// and a NetReceive job, which writes the pipe on the host of the consumers.
+// The connection is dialed by the sender unless the receiver runs in
+// flowyexec itself, which does not accept connections.
+//
+// The data is sent in frames of a type byte and the length of the payload.
+// The sender first sends whether the producer runs, so that the consumers
+// are skipped or aborted with the producer. The data frames are followed
+// by an end frame and then by the final status of the producer, which
+// aborts the consumers if the producer has failed. TCP flow control blocks
+// the producer while the consumers are slow, and the producer is aborted
+// when the connection is closed by the consumers as when they abort a
+// local pipe.
+
+const (
+	netSendType    = "NetSend"
+	netReceiveType = "NetReceive"
+)
+
+const (
+	frameRunning   byte = 'R'
+	frameSkipped   byte = 'K'
+	frameData      byte = 'D'
+	frameEnd       byte = 'E'
+	frameSucceeded byte = 'S'
+	frameFailed    byte = 'F'
+)
+
+// maxFrameSize limits the payload of a frame read from a connection.
+const maxFrameSize = 1 << 20
+
+const netDialTimeout = 10 * time.Second
+
+// netPollInterval is the interval at which a NetSend job polls the status of the producer.
+const netPollInterval = 100 * time.Millisecond
+
+func sendBridgeId(key string, host string) string {
+	return "<send " + key + " to " + hostName(host) + ">"
+}
+
+func receiveBridgeId(key string, host string) string {
+	return "<receive " + key + " from " + hostName(host) + ">"
+}
+
+// remoteDecider is implemented by the jobs whose decision
+// to run is made by a job on another host.
+type remoteDecider interface {
+	remoteDecision(wf *Workflow) JobStatus
+}
+
+// frameConn reads and writes the frames of a pipe.
+type frameConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	mu     sync.Mutex
+	closed bool
+}
+
+func newFrameConn(conn net.Conn) *frameConn {
+	return &frameConn{conn: conn, reader: bufio.NewReader(conn)}
+}
+
+func (c *frameConn) write(typ byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+	header := make([]byte, 5)
+	header[0] = typ
+	binary.BigEndian.PutUint32(header[1:], uint32(len(payload)))
+	if _, err := c.conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *frameConn) read() (byte, []byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		return 0, nil, err
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > maxFrameSize {
+		return 0, nil, fmt.Errorf("frame of %d bytes is too large", size)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[0], payload, nil
+}
+
+// finish writes the last frame unless the connection has been closed, and closes it.
+func (c *frameConn) finish(typ byte, payload []byte) {
+	c.write(typ, payload)
+	c.close()
+}
+
+func (c *frameConn) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		c.closed = true
+		c.conn.Close()
+	}
+}
+
+// netEndpoint is the connection of a NetSend or a NetReceive job.
+// The connection is dialed to addr, or accepted by the agent if addr is empty.
+// aborted is closed when the job is aborted, which interrupts accepting it.
+type netEndpoint struct {
+	key     string
+	addr    string
+	role    string
+	mu      sync.Mutex
+	conn    *frameConn
+	aborted chan struct{}
+	once    sync.Once
+}
+
+func newNetEndpoint(jobDto *JobDto, key string, role string) *netEndpoint {
+	return &netEndpoint{
+		key:     key,
+		addr:    strings.TrimPrefix(jobDto.Url, "tcp://"),
+		role:    role,
+		aborted: make(chan struct{}),
+	}
+}
+
+// connect dials or accepts the connection of the pipe, which
+// the job on the other host accepts or dials with the other role.
+// It returns an error if the job is aborted while it is connecting.
+func (e *netEndpoint) connect(wf *Workflow) (*frameConn, error) {
+	var conn net.Conn
+	if e.addr == "" {
+		if wf.agent == nil {
+			return nil, fmt.Errorf("pipe %s cannot be accepted out of an agent", e.key)
+		}
+		var err error
+		conn, err = wf.agent.acceptPipe(wf.RunId, e.key, e.role, e.aborted)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		conn, err = net.DialTimeout("tcp", e.addr, netDialTimeout)
+		if err != nil {
+			return nil, err
+		}
+		peer := netReceiveType
+		if e.role == netReceiveType {
+			peer = netSendType
+		}
+		err = json.NewEncoder(conn).Encode(&agentRequest{Type: "pipe", Token: wf.AgentToken, RunId: wf.RunId, Key: e.key, Role: peer})
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	frames := newFrameConn(conn)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	select {
+	case <-e.aborted:
+		frames.finish(frameFailed, []byte(errAborted.Error()))
+		return nil, errAborted
+	default:
+	}
+	e.conn = frames
+	return frames, nil
+}
+
+// abort interrupts connecting, and sends the frame of typ
+// and closes the connection if it has been made.
+func (e *netEndpoint) abort(typ byte, message string) {
+	e.once.Do(func() { close(e.aborted) })
+	e.mu.Lock()
+	conn := e.conn
+	e.mu.Unlock()
+	if conn != nil {
+		conn.finish(typ, []byte(message))
+	}
+}
+
+// NetSendJob sends a pipe to a NetReceiveJob on another host.
+type NetSendJob struct {
+	streamJob
+	endpoint *netEndpoint
+}
+
+// NetReceiveJob writes a pipe sent by a NetSendJob on another host.
+type NetReceiveJob struct {
+	streamJob
+	endpoint *netEndpoint
+}
+
+func createNetSendJob(jobDto *JobDto) Job {
+	job := &NetSendJob{
+		streamJob: streamJob{jobId: jobDto.JobId, status: Created},
+		endpoint:  newNetEndpoint(jobDto, jobDto.ReadFrom, netSendType),
+	}
+	job.addInput(jobDto.ReadFrom)
+	job.onAbort = func() {
+		job.endpoint.abort(frameFailed, errAborted.Error())
+	}
+	return job
+}
+
+func createNetReceiveJob(jobDto *JobDto) Job {
+	job := &NetReceiveJob{
+		streamJob: streamJob{jobId: jobDto.JobId, status: Created},
+		endpoint:  newNetEndpoint(jobDto, jobDto.WriteTo, netReceiveType),
+	}
+	job.addOutput(jobDto.WriteTo)
+	job.onAbort = func() {
+		// the sender fails to write to the closed connection
+		job.endpoint.abort(frameFailed, "")
+	}
+	return job
+}
+
+func (job *NetSendJob) Execute(wf *Workflow, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer wf.UnBlock()
+	conn, err := job.endpoint.connect(wf)
+	if err != nil {
+		job.mu.Lock()
+		if job.status == Created {
+			job.status = Running
+		}
+		job.mu.Unlock()
+		job.finish(fmt.Errorf("cannot send pipe %s: %w", job.endpoint.key, err))
+		return
+	}
+	if job.GetStatus() == Skipped {
+		conn.finish(frameSkipped, nil)
+	}
+	if !job.begin() {
+		conn.finish(frameFailed, []byte(errAborted.Error()))
+		return
+	}
+	job.finish(job.send(wf, conn))
+}
+
+// send sends the pipe and then the final status of the producer.
+func (job *NetSendJob) send(wf *Workflow, conn *frameConn) error {
+	if err := conn.write(frameRunning, nil); err != nil {
+		return err
+	}
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := job.inputs[0].reader.Read(buf)
+		if n > 0 {
+			if werr := conn.write(frameData, buf[:n]); werr != nil {
+				return fmt.Errorf("cannot send pipe %s: %w", job.endpoint.key, werr)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			conn.finish(frameFailed, []byte(err.Error()))
+			return err
+		}
+	}
+	if err := conn.write(frameEnd, nil); err != nil {
+		return err
+	}
+	if producer := wf.producer(job.endpoint.key); producer != nil {
+		for !producer.GetStatus().IsFinished() {
+			select {
+			case <-job.endpoint.aborted:
+				return errAborted
+			case <-time.After(netPollInterval):
+			}
+		}
+		if producer.GetStatus().IsFailed() {
+			conn.finish(frameFailed, []byte(fmt.Sprintf("job %s has failed", producer.GetId())))
+			return nil
+		}
+	}
+	conn.finish(frameSucceeded, nil)
+	return nil
+}
+
+// remoteDecision waits until the sender sends whether the producer runs,
+// so that the consumers are skipped or aborted with the producer.
+func (job *NetReceiveJob) remoteDecision(wf *Workflow) JobStatus {
+	conn, err := job.endpoint.connect(wf)
+	if err == nil {
+		var typ byte
+		typ, _, err = conn.read()
+		switch {
+		case err != nil:
+		case typ == frameRunning:
+			return Created
+		case typ == frameSkipped:
+			return Skipped
+		case typ == frameFailed:
+			return Aborted
+		default:
+			err = fmt.Errorf("unexpected frame %c", typ)
+		}
+	}
+	job.mu.Lock()
+	if job.status == Created {
+		job.status = Failed
+		job.message = fmt.Sprintf("cannot receive pipe %s: %v", job.endpoint.key, err)
+	}
+	job.mu.Unlock()
+	return Aborted
+}
+
+func (job *NetReceiveJob) Execute(wf *Workflow, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer wf.UnBlock()
+	if !job.begin() {
+		job.endpoint.abort(frameFailed, "")
+		return
+	}
+	job.finish(job.receive())
+}
+
+// receive writes the pipe and returns when the producer has finished.
+func (job *NetReceiveJob) receive() error {
+	conn := job.endpoint.conn
+	defer conn.close()
+	writer := job.outputs[0].writer
+	for {
+		typ, payload, err := conn.read()
+		if err != nil {
+			return fmt.Errorf("cannot receive pipe %s: %w", job.endpoint.key, err)
+		}
+		switch typ {
+		case frameData:
+			if _, err := writer.Write(payload); err != nil {
+				return err
+			}
+		case frameEnd:
+			// the consumers read the end of the data while the producer is finishing
+			writer.Close()
+		case frameSucceeded:
+			return nil
+		case frameFailed:
+			return errors.New(string(payload))
+		default:
+			return fmt.Errorf("unexpected frame %c", typ)
+		}
+	}
+}
+
+// producer returns the job writing the pipe of key.
+func (w *Workflow) producer(key string) Job {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, job := range w.Jobs {
+		for _, output := range job.GetOutputs() {
+			if output.Key() == key {
+				return job
+			}
+		}
+	}
+	return nil
+}
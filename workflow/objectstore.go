@@ -0,0 +1,472 @@
+package workflow
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// ObjectStore configures an object store used by ObjectStore jobs.
+// An Endpoint with the file scheme, e.g. file:///data/store, selects
+// a local directory whose subdirectories are the buckets.
+//
+// The credentials are AccessKey and SecretKey if they are set.
+// Otherwise they are looked up by the default credential chain of the
+// AWS SDK, i.e. environment variables, shared config profiles,
+// web identity tokens and instance metadata.
+type ObjectStore struct {
+	Region    string
+	Endpoint  string
+	AccessKey string
+	SecretKey string
+	// Profile is the shared config profile used by the credential chain.
+	Profile string
+	// CredentialsFile is a shared credentials or config file used
+	// instead of ~/.aws/credentials and ~/.aws/config.
+	CredentialsFile string
+	// ForcePathStyle selects path-style addressing of buckets.
+	// It defaults to true if Endpoint is set.
+	ForcePathStyle *bool
+	// DisableSSL selects plain HTTP for endpoints without a scheme.
+	DisableSSL bool
+	// CABundle is a PEM file of the certificate authorities trusted instead of the system ones.
+	CABundle string
+	// InsecureSkipVerify disables the verification of the server certificate.
+	InsecureSkipVerify bool
+}
+
+// ObjectStoreBackend stores the objects read and written by ObjectStore jobs.
+type ObjectStoreBackend interface {
+	// List returns the keys of the objects whose key starts with prefix.
+	List(ctx context.Context, bucket string, prefix string) ([]string, error)
+	// Open returns a reader of an object.
+	Open(ctx context.Context, bucket string, key string, sse *sseParams) (io.ReadCloser, error)
+	// Create returns a writer of an object.
+	// The object becomes visible only when the writer is successfully closed.
+	Create(ctx context.Context, bucket string, key string, props *objectProperties) (ObjectWriter, error)
+}
+
+// ObjectWriter writes an object.
+// Abort discards the data written so far instead of storing the object.
+type ObjectWriter interface {
+	io.WriteCloser
+	Abort() error
+}
+
+// objectProperties holds the properties of an object to create.
+type objectProperties struct {
+	contentType     string
+	contentEncoding string
+	storageClass    string
+	acl             string
+	metadata        map[string]string
+	tags            map[string]string
+	sse             *sseParams
+}
+
+// newBackend returns a backend connected to the object store at endpoint.
+func (o *ObjectStore) newBackend(endpoint string) (ObjectStoreBackend, error) {
+	if strings.HasPrefix(endpoint, "file:") {
+		root, err := parseFileUrl(endpoint)
+		if err != nil {
+			return nil, err
+		}
+		return &localBackend{root: root}, nil
+	}
+	var c *credentials.Credentials
+	if o.AccessKey != "" && o.SecretKey != "" {
+		c = credentials.NewStaticCredentials(o.AccessKey, o.SecretKey, "")
+	}
+	var endpoints *string
+	if endpoint != "" {
+		endpoints = &endpoint
+	}
+	pathStyle := endpoint != ""
+	if o.ForcePathStyle != nil {
+		pathStyle = *o.ForcePathStyle
+	}
+	config := &aws.Config{
+		Credentials:      c,
+		Endpoint:         endpoints,
+		S3ForcePathStyle: aws.Bool(pathStyle),
+		DisableSSL:       aws.Bool(o.DisableSSL),
+	}
+	if o.Region != "" {
+		// otherwise the region is looked up in the environment and shared config
+		config.Region = aws.String(o.Region)
+	}
+	if o.InsecureSkipVerify {
+		config.HTTPClient = &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+		}
+	}
+	options := session.Options{
+		Config:            *config,
+		Profile:           o.Profile,
+		SharedConfigState: session.SharedConfigEnable,
+	}
+	if o.CredentialsFile != "" {
+		options.SharedConfigFiles = []string{o.CredentialsFile}
+	}
+	if o.CABundle != "" {
+		bundle, err := os.Open(o.CABundle)
+		if err != nil {
+			return nil, err
+		}
+		defer bundle.Close()
+		options.CustomCABundle = bundle
+	}
+	sess, err := session.NewSessionWithOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	sess.Handlers.Build.PushFront(startS3Span)
+	sess.Handlers.Complete.PushBack(observeS3Request)
+	sess.Handlers.Complete.PushBack(endS3Span)
+	return &s3Backend{client: s3.New(sess)}, nil
+}
+
+// parseFileUrl returns the path of a file URL.
+// file:///data/x is an absolute path and file:data/x is a relative path.
+func parseFileUrl(rawUrl string) (string, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "file" || (u.Host != "" && u.Host != "localhost") {
+		return "", fmt.Errorf("%s is not a local file URL", rawUrl)
+	}
+	if u.Opaque != "" {
+		return filepath.FromSlash(u.Opaque), nil
+	}
+	return filepath.FromSlash(u.Path), nil
+}
+
+// parseObjectUrl returns the backend, the bucket and the key of an object URL.
+// s3://bucket/key is an object of the object store of the workflow,
+// which is returned as a nil backend. file: URLs are local files.
+func parseObjectUrl(rawUrl string) (ObjectStoreBackend, string, string, error) {
+	if strings.HasPrefix(rawUrl, "file:") {
+		path, err := parseFileUrl(rawUrl)
+		if err != nil {
+			return nil, "", "", err
+		}
+		dir, file := filepath.Split(path)
+		if dir == "" {
+			dir = "."
+		}
+		return &localBackend{root: dir}, "", file, nil
+	}
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, "", "", err
+	}
+	if u.Scheme != "s3" || u.Host == "" {
+		return nil, "", "", fmt.Errorf("unsupported object URL %s", rawUrl)
+	}
+	return nil, u.Host, strings.TrimPrefix(u.Path, "/"), nil
+}
+
+type s3Backend struct {
+	client *s3.S3
+}
+
+func (b *s3Backend) List(ctx context.Context, bucket string, prefix string) ([]string, error) {
+	keys := []string{}
+	err := b.client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			keys = append(keys, aws.StringValue(object.Key))
+		}
+		return true
+	})
+	return keys, err
+}
+
+func (b *s3Backend) Open(ctx context.Context, bucket string, key string, sse *sseParams) (io.ReadCloser, error) {
+	out, err := b.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket:               aws.String(bucket),
+		Key:                  aws.String(key),
+		SSECustomerAlgorithm: sse.customerAlgorithm,
+		SSECustomerKey:       sse.customerKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.ContentLength != nil {
+		return &objectReader{ReadCloser: out.Body, length: *out.ContentLength}, nil
+	}
+	return out.Body, nil
+}
+
+// objectReader is the reader of an object whose size is known.
+type objectReader struct {
+	io.ReadCloser
+	length int64
+}
+
+// objectSize returns the size of the object read by reader, or 0 if it is unknown.
+func objectSize(reader io.ReadCloser) int64 {
+	switch r := reader.(type) {
+	case *objectReader:
+		return r.length
+	case *os.File:
+		if info, err := r.Stat(); err == nil && info.Mode().IsRegular() {
+			return info.Size()
+		}
+	}
+	return 0
+}
+
+func (b *s3Backend) Create(ctx context.Context, bucket string, key string, props *objectProperties) (ObjectWriter, error) {
+	output, err := b.client.CreateMultipartUploadWithContext(ctx, createMultipartUploadInput(bucket, key, props))
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectStoreUploader{
+		ctx:        ctx,
+		client:     b.client,
+		sse:        props.sse,
+		output:     output,
+		buff:       make([]byte, 10*1024*1024),
+		len:        0,
+		partNumber: 1,
+	}, nil
+}
+
+func createMultipartUploadInput(bucket string, key string, props *objectProperties) *s3.CreateMultipartUploadInput {
+	input := &s3.CreateMultipartUploadInput{
+		Bucket:               aws.String(bucket),
+		Key:                  aws.String(key),
+		ContentType:          aws.String(props.contentType),
+		Metadata:             aws.StringMap(props.metadata),
+		ServerSideEncryption: props.sse.serverSide,
+		SSEKMSKeyId:          props.sse.kmsKeyId,
+		SSECustomerAlgorithm: props.sse.customerAlgorithm,
+		SSECustomerKey:       props.sse.customerKey,
+	}
+	if props.contentEncoding != "" {
+		input.ContentEncoding = aws.String(props.contentEncoding)
+	}
+	if props.storageClass != "" {
+		input.StorageClass = aws.String(props.storageClass)
+	}
+	if props.acl != "" {
+		input.ACL = aws.String(props.acl)
+	}
+	if len(props.tags) > 0 {
+		tags := url.Values{}
+		for k, v := range props.tags {
+			tags.Set(k, v)
+		}
+		input.Tagging = aws.String(tags.Encode())
+	}
+	return input
+}
+
+// ObjectStoreUploader uploads an object with a multipart upload,
+// buffering the data written to it in parts.
+type ObjectStoreUploader struct {
+	ctx             context.Context
+	output          *s3.CreateMultipartUploadOutput
+	client          *s3.S3
+	sse             *sseParams
+	buff            []byte
+	len             int64
+	compuletedParts []*s3.CompletedPart
+	partNumber      int64
+	total_uploaded  int
+	total_writed    int
+}
+
+func (p *ObjectStoreUploader) upload() error {
+	if p.len <= 0 {
+		return nil
+	}
+	PartNumber := aws.Int64(p.partNumber)
+	input := s3.UploadPartInput{
+		Body:                 bytes.NewReader(p.buff[:p.len]),
+		Bucket:               p.output.Bucket,
+		Key:                  p.output.Key,
+		PartNumber:           PartNumber,
+		UploadId:             p.output.UploadId,
+		ContentLength:        aws.Int64(p.len),
+		SSECustomerAlgorithm: p.sse.customerAlgorithm,
+		SSECustomerKey:       p.sse.customerKey,
+	}
+	multipartPartsInFlight.Inc()
+	resp, err := p.client.UploadPartWithContext(p.ctx, &input)
+	multipartPartsInFlight.Dec()
+	if err != nil {
+		return err
+	}
+	completedPart := s3.CompletedPart{
+		ETag:       resp.ETag,
+		PartNumber: PartNumber,
+	}
+	p.total_uploaded += int(p.len)
+	p.compuletedParts = append(p.compuletedParts, &completedPart)
+	p.len = 0
+	p.partNumber++
+	return err
+}
+func (p *ObjectStoreUploader) Write(data []byte) (n int, err error) {
+	p.total_writed += len(data)
+	curr := data
+	buff := p.buff[p.len:]
+	var total_writed int = 0
+	for {
+		if len(curr) < len(buff) {
+			copy(buff, curr)
+			p.len += int64(len(curr))
+			// copy to buffer and return if buffer is not full
+			total_writed += len(curr)
+			return total_writed, nil
+		} else {
+			copy(buff, curr[:len(buff)])
+			p.len += int64(len(buff))
+			total_writed += len(buff)
+			err := p.upload()
+			if err != nil {
+				return total_writed, err
+			}
+			curr = curr[len(buff):]
+			buff = p.buff
+		}
+	}
+}
+func (p *ObjectStoreUploader) Abort() error {
+	_, err := p.client.AbortMultipartUploadWithContext(p.ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   p.output.Bucket,
+		Key:      p.output.Key,
+		UploadId: p.output.UploadId,
+	})
+	return err
+}
+func (p *ObjectStoreUploader) Close() error {
+	err := p.upload()
+	if err != nil {
+		p.Abort()
+		return err
+	}
+	resp := p.output
+	completeInput := s3.CompleteMultipartUploadInput{
+		Bucket:   resp.Bucket,
+		Key:      resp.Key,
+		UploadId: resp.UploadId,
+		MultipartUpload: &s3.CompletedMultipartUpload{
+			Parts: p.compuletedParts,
+		},
+		SSECustomerAlgorithm: p.sse.customerAlgorithm,
+		SSECustomerKey:       p.sse.customerKey,
+	}
+	_, err = p.client.CompleteMultipartUploadWithContext(p.ctx, &completeInput)
+	return err
+}
+
+// localBackend stores objects as files in a directory.
+// The subdirectories of root are the buckets, and keys are paths in them.
+type localBackend struct {
+	root string
+}
+
+const localTempSuffix = ".flowytmp"
+
+func (b *localBackend) path(bucket string, key string) string {
+	return filepath.Join(b.root, bucket, filepath.FromSlash(key))
+}
+
+func (b *localBackend) List(ctx context.Context, bucket string, prefix string) ([]string, error) {
+	base := filepath.Join(b.root, bucket)
+	// walk only the deepest directory containing all the keys with the prefix
+	dir := filepath.Join(base, filepath.FromSlash(prefix[:strings.LastIndex(prefix, "/")+1]))
+	keys := []string{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == dir {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasSuffix(path, localTempSuffix) {
+			return nil
+		}
+		rel, err := filepath.Rel(base, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	sort.Strings(keys)
+	return keys, err
+}
+
+func (b *localBackend) Open(ctx context.Context, bucket string, key string, sse *sseParams) (io.ReadCloser, error) {
+	return os.Open(b.path(bucket, key))
+}
+
+func (b *localBackend) Create(ctx context.Context, bucket string, key string, props *objectProperties) (ObjectWriter, error) {
+	path := b.path(bucket, key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*"+localTempSuffix)
+	if err != nil {
+		return nil, err
+	}
+	return &localObjectWriter{File: f, path: path}, nil
+}
+
+// localObjectWriter writes a temporary file, which is renamed to the path
+// of the object when it is closed.
+type localObjectWriter struct {
+	*os.File
+	path string
+}
+
+func (w *localObjectWriter) Close() error {
+	err := w.File.Sync()
+	if err == nil {
+		err = w.File.Close()
+	} else {
+		w.File.Close()
+	}
+	if err == nil {
+		err = os.Chmod(w.File.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(w.File.Name(), w.path)
+	}
+	if err != nil {
+		os.Remove(w.File.Name())
+	}
+	return err
+}
+
+func (w *localObjectWriter) Abort() error {
+	w.File.Close()
+	return os.Remove(w.File.Name())
+}
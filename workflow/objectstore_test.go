@@ -0,0 +1,114 @@
+package workflow
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTLSObjectStore starts an HTTPS server which answers any request
+// with an empty listing of objects.
+func newTLSObjectStore(t *testing.T) *httptest.Server {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><ListBucketResult><Name>bucket</Name><KeyCount>0</KeyCount><IsTruncated>false</IsTruncated></ListBucketResult>`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestObjectStoreTLS(t *testing.T) {
+	server := newTLSObjectStore(t)
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	assert.NoError(t, os.WriteFile(bundle, certificate, 0644))
+
+	tests := []struct {
+		name  string
+		store ObjectStore
+		ok    bool
+	}{
+		{"untrusted", ObjectStore{}, false},
+		{"ca bundle", ObjectStore{CABundle: bundle}, true},
+		{"insecure", ObjectStore{InsecureSkipVerify: true}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := test.store
+			store.Region = "us-east-1"
+			store.AccessKey = "access"
+			store.SecretKey = "secret"
+			backend, err := store.newBackend(server.URL)
+			assert.NoError(t, err)
+			_, err = backend.List(context.Background(), "bucket", "")
+			if test.ok {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestObjectStoreCredentialsFile(t *testing.T) {
+	server := newTLSObjectStore(t)
+	file := filepath.Join(t.TempDir(), "credentials")
+	assert.NoError(t, os.WriteFile(file, []byte("[test]\naws_access_key_id = access\naws_secret_access_key = secret\nregion = us-east-1\n"), 0600))
+	store := ObjectStore{CredentialsFile: file, Profile: "test", InsecureSkipVerify: true}
+	backend, err := store.newBackend(server.URL)
+	assert.NoError(t, err)
+	creds, err := backend.(*s3Backend).client.Config.Credentials.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, "access", creds.AccessKeyID)
+	assert.Equal(t, "us-east-1", *backend.(*s3Backend).client.Config.Region)
+	_, err = backend.List(context.Background(), "bucket", "")
+	assert.NoError(t, err)
+}
+
+// countingBackend counts the requests and fails all of them.
+type countingBackend struct {
+	requests int
+}
+
+func (b *countingBackend) List(ctx context.Context, bucket string, prefix string) ([]string, error) {
+	b.requests++
+	return nil, errors.New("unexpected list")
+}
+
+func (b *countingBackend) Open(ctx context.Context, bucket string, key string, sse *sseParams) (io.ReadCloser, error) {
+	b.requests++
+	return nil, errors.New("unexpected open")
+}
+
+func (b *countingBackend) Create(ctx context.Context, bucket string, key string, props *objectProperties) (ObjectWriter, error) {
+	b.requests++
+	return nil, errors.New("unexpected create")
+}
+
+func TestObjectStoreDownloadAbortedBeforeStart(t *testing.T) {
+	workflow, err := LoadWorkflow(strings.NewReader(`{"jobs": [{"jobId": "download", "type": "ObjectStore", "bucket": "b", "key": "*.txt", "writeTo": "OUT"}]}`))
+	assert.NoError(t, err)
+	job := workflow.Jobs[0].(*ObjectStoreDownloadJob)
+	backend := &countingBackend{}
+	job.store = backend
+	job.Abort()
+	assert.Equal(t, Aborted, job.GetStatus())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	job.Execute(workflow, &wg)
+	_, err = job.outputs[0].GetReader()
+	assert.Error(t, err)
+	assert.Equal(t, 0, backend.requests)
+	assert.Equal(t, Aborted, job.GetResult().Status)
+	assert.True(t, job.Start.IsZero())
+}
@@ -1,19 +1,158 @@
 package workflow
 
 import (
+	"context"
+	"errors"
 	"io"
+	"sync"
+	"time"
 
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type PipeHandler struct {
 	output Output
 	inputs []Input
-	Status JobStatus
+	// producer and consumers are the ids of the jobs of output and inputs.
+	producer  string
+	consumers []string
+	Status    JobStatus
+	// done is closed when Handle returns.
+	done chan struct{}
+	// produced is closed when the producer has finished, after which its
+	// status is final. It is nil unless output is the only output of the
+	// producer, since the producer writing other pipes may not finish until
+	// the consumers waiting for it have read the pipes written by them.
+	produced chan struct{}
+	// onlyOutput is set if output is the only output of the producer.
+	onlyOutput bool
+	// ctx is the context of the span of the workflow, under which
+	// Handle records the span of the pipe.
+	ctx context.Context
+	// owner is the workflow whose logger logs the pipe.
+	owner *Workflow
+	// mu guards the accounting of the transfer: the bytes read from the
+	// producer, the bytes written to each consumer, the time blocked on
+	// reading and on writing to each consumer, and whether each consumer
+	// has been closed before the producer has finished.
+	mu           sync.Mutex
+	bytesRead    int64
+	readBlocked  time.Duration
+	written      []int64
+	writeBlocked []time.Duration
+	closedEarly  []bool
+	start        time.Time
+	end          time.Time
+	size         int64
 }
 
-func (p *PipeHandler) addInput(input Input) {
+// pipeEventInterval is the interval between the PipeEvents of a transfer.
+var pipeEventInterval = time.Second
+
+// sizedReader is a reader of a producer which knows the size of its data.
+type sizedReader interface {
+	size() int64
+}
+
+// PipeResult is the accounting of the data transferred by a pipe.
+// ClosedEarly is set if any consumer has been closed before the producer
+// has finished, which has truncated the data read by the consumer.
+type PipeResult struct {
+	Key                 string
+	Producer            string
+	Consumers           []*PipeConsumerResult
+	BytesRead           int64
+	ReadBlockedSeconds  float64
+	WriteBlockedSeconds float64
+	// Throughput is the bytes read per second of the transfer.
+	Throughput  float64
+	ClosedEarly bool
+	// Size is the size of the data of the producer if it is known in advance,
+	// e.g. the Content-Length of a downloaded object.
+	Size int64 `json:",omitempty"`
+}
+
+// PipeConsumerResult is the accounting of the data written to a consumer of a pipe.
+// The data of a skipped consumer is discarded and not counted.
+type PipeConsumerResult struct {
+	JobId               string
+	BytesWritten        int64
+	WriteBlockedSeconds float64
+	ClosedEarly         bool
+}
+
+func (p *PipeHandler) addInput(jobId string, input Input) {
 	p.inputs = append(p.inputs, input)
+	p.consumers = append(p.consumers, jobId)
+	p.written = append(p.written, 0)
+	p.writeBlocked = append(p.writeBlocked, 0)
+	p.closedEarly = append(p.closedEarly, false)
+}
+
+// Result returns the accounting of the transfer so far.
+func (p *PipeHandler) Result() *PipeResult {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	result := &PipeResult{
+		Key:                p.output.Key(),
+		Producer:           p.producer,
+		Consumers:          make([]*PipeConsumerResult, 0, len(p.consumers)),
+		BytesRead:          p.bytesRead,
+		ReadBlockedSeconds: p.readBlocked.Seconds(),
+		Size:               p.size,
+	}
+	var writeBlocked time.Duration
+	for idx, jobId := range p.consumers {
+		writeBlocked += p.writeBlocked[idx]
+		result.ClosedEarly = result.ClosedEarly || p.closedEarly[idx]
+		result.Consumers = append(result.Consumers, &PipeConsumerResult{
+			JobId:               jobId,
+			BytesWritten:        p.written[idx],
+			WriteBlockedSeconds: p.writeBlocked[idx].Seconds(),
+			ClosedEarly:         p.closedEarly[idx],
+		})
+	}
+	result.WriteBlockedSeconds = writeBlocked.Seconds()
+	if !p.start.IsZero() {
+		end := p.end
+		if end.IsZero() {
+			end = time.Now()
+		}
+		if elapsed := end.Sub(p.start).Seconds(); elapsed > 0 {
+			result.Throughput = float64(p.bytesRead) / elapsed
+		}
+	}
+	return result
+}
+
+// countRead records a read from the producer.
+func (p *PipeHandler) countRead(n int, blocked time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.bytesRead += int64(n)
+	p.readBlocked += blocked
+}
+
+// countWrite records a write to the consumer idx.
+func (p *PipeHandler) countWrite(idx int, n int, blocked time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.written[idx] += int64(n)
+	p.writeBlocked[idx] += blocked
+}
+
+// closeEarly records that the consumer idx is closed before the producer has finished.
+func (p *PipeHandler) closeEarly(idx int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closedEarly[idx] = true
+}
+
+// log returns the entry of the log lines of the pipe about a job.
+func (p *PipeHandler) log(jobId string) *logrus.Entry {
+	return p.owner.jobLog(jobId).WithField("key", p.output.Key())
 }
 
 func CreateHandlers(jobs []Job) []*PipeHandler {
@@ -23,14 +162,19 @@ func CreateHandlers(jobs []Job) []*PipeHandler {
 		outputs := job.GetOutputs()
 		for _, output := range outputs {
 			handler := &PipeHandler{
-				output: output,
+				output:     output,
+				producer:   job.GetId(),
+				onlyOutput: len(outputs) == 1,
 			}
 			m[output.Key()] = handler
 			handlers = append(handlers, handler)
 		}
+	}
+	// a job may be defined before the job writing its inputs
+	for _, job := range jobs {
 		for _, input := range job.GetInputs() {
 			h := m[input.Key()]
-			h.addInput(input)
+			h.addInput(job.GetId(), input)
 		}
 	}
 	return handlers
@@ -62,6 +206,10 @@ func (p *PipeHandler) checkWriters(writers []io.WriteCloser) bool {
 	return closedall
 }
 func (p *PipeHandler) Init() {
+	p.done = make(chan struct{})
+	if p.onlyOutput {
+		p.produced = make(chan struct{})
+	}
 }
 func (p *PipeHandler) UnBlock() {
 	p.output.UnBlock()
@@ -71,44 +219,122 @@ func (p *PipeHandler) UnBlock() {
 }
 
 func (p *PipeHandler) Handle() {
+	defer close(p.done)
+	ctx := p.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, span := tracer().Start(ctx, "pipe "+p.output.Key(), trace.WithAttributes(
+		attribute.String("flowyexec.key", p.output.Key()),
+		attribute.String("flowyexec.producer", p.output.Label()),
+		attribute.Int("flowyexec.consumers", len(p.inputs)),
+	))
+	status := Successed
+	var total int64
+	defer func() {
+		span.SetAttributes(attribute.Int64("flowyexec.bytes", total))
+		endSpan(span, status, "")
+		if p.owner != nil {
+			p.owner.emitPipeEvent(&PipeEvent{Pipe: p.Result(), Finished: true})
+		}
+	}()
 	writers := make([]io.WriteCloser, len(p.inputs))
+	_, openSpan := tracer().Start(ctx, "open reader", trace.WithAttributes(attribute.String("flowyexec.stream", p.output.Label())))
 	reader, err := p.output.GetReader()
+	if err != nil && !errors.Is(err, errSkipped) {
+		openSpan.RecordError(err)
+	}
+	openSpan.End()
+	if errors.Is(err, errSkipped) {
+		// the consumers are skipped as well
+		p.log(p.producer).Info("Producer is skipped")
+		status = Skipped
+		return
+	}
 	if err != nil {
-		logrus.WithError(err).Warn("Cannot get reader")
+		p.log(p.producer).WithError(err).Warn("Cannot get reader")
 		p.AbortAll()
+		status = Failed
 		return
 	}
 	defer reader.Close()
+	if sized, ok := reader.(sizedReader); ok {
+		p.mu.Lock()
+		p.size = sized.size()
+		p.mu.Unlock()
+	}
 	for idx, input := range p.inputs {
+		_, openSpan := tracer().Start(ctx, "open writer", trace.WithAttributes(attribute.String("flowyexec.stream", input.Label())))
 		writer, err := input.GetWriter()
-		if err == nil {
+		if err != nil && !errors.Is(err, errSkipped) {
+			openSpan.RecordError(err)
+		}
+		openSpan.End()
+		if errors.Is(err, errSkipped) {
+			writers[idx] = discardWriter{}
+		} else if err == nil {
 			writers[idx] = writer
 			defer writer.Close()
 		} else {
-			logrus.WithError(err).Warn("Cannot get writer")
+			p.log(p.consumers[idx]).WithError(err).Warn("Cannot get writer")
+			p.closeEarly(idx)
 		}
 	}
 	if p.checkWriters(writers) {
+		status = Aborted
 		return
 	}
 	p.Status = Running
+	transferred := pipeBytes.WithLabelValues(p.output.Key())
+	start := time.Now()
+	p.mu.Lock()
+	p.start = start
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		p.end = time.Now()
+		p.mu.Unlock()
+		if elapsed := time.Since(start).Seconds(); elapsed > 0 {
+			pipeThroughput.WithLabelValues(p.output.Key()).Set(float64(total) / elapsed)
+		}
+	}()
+	var progress progressLog
+	events := progressLog{interval: pipeEventInterval}
 	buf := make([]byte, 32*1024)
 	for {
+		readStart := time.Now()
 		n, err := reader.Read(buf)
+		p.countRead(n, time.Since(readStart))
 		if n > 0 {
+			transferred.Add(float64(n))
+			total += int64(n)
+			if progress.due() {
+				p.log(p.producer).WithField("bytes", total).Info("Transfer Progress")
+			}
+			if p.owner != nil && events.due() {
+				p.owner.emitPipeEvent(&PipeEvent{Pipe: p.Result()})
+			}
 			for idx, writer := range writers {
 				if writer != nil {
+					writeStart := time.Now()
 					writed, err := writer.Write(buf[:n])
+					if _, discarded := writer.(discardWriter); !discarded {
+						p.countWrite(idx, writed, time.Since(writeStart))
+					}
 					if err == nil && n != writed {
 						if n != writed {
 							err = io.ErrShortWrite
 						}
 
 					}
-					if err != nil {
+					if errors.Is(err, errSkipped) {
+						writers[idx] = discardWriter{}
+					} else if err != nil {
 						writers[idx].Close()
 						writers[idx] = nil
+						p.closeEarly(idx)
 						if p.checkWriters(writers) {
+							status = Aborted
 							return
 						}
 					}
@@ -116,15 +342,59 @@ func (p *PipeHandler) Handle() {
 			}
 		}
 		if err != nil {
-			if err != io.EOF {
-				logrus.WithError(err).Warn("error while reading or writing")
+			if errors.Is(err, errSkipped) {
+				p.log(p.producer).Info("Producer is skipped")
+			} else if err != io.EOF {
+				p.log(p.producer).WithError(err).Warn("error while reading or writing")
 				p.AbortAll()
+				span.RecordError(err)
+				status = Failed
 			}
-			logrus.Info("reading is finished")
+			p.log(p.producer).Info("reading is finished")
 			break
 		}
 	}
 }
+
+// discardWriter takes the place of the writer of a skipped consumer,
+// so that the producer and the other consumers are not aborted.
+type discardWriter struct{}
+
+func (discardWriter) Write(data []byte) (int, error) {
+	return len(data), nil
+}
+func (discardWriter) Close() error {
+	return nil
+}
+
+// waitProducer waits until the producer has finished or ctx is done,
+// and reports whether the producer has failed. It returns false at once
+// if the producer writes other pipes.
+func (p *PipeHandler) waitProducer(ctx context.Context) bool {
+	if p.produced == nil {
+		return false
+	}
+	select {
+	case <-p.produced:
+		return p.output.IsFailed()
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// wait waits until Handle returns, unblocking the streams
+// which are opening the FIFOs of finished jobs.
+func (p *PipeHandler) wait() {
+	for {
+		p.UnBlock()
+		select {
+		case <-p.done:
+			return
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
 func (p *PipeHandler) Finished() {
 	if p.output.IsFailed() {
 		// If an job fails,next step job status must be aborted even if the job is successed.
@@ -0,0 +1,84 @@
+package workflow
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPipeResults(t *testing.T) {
+	dir := t.TempDir()
+	workflow, err := LoadWorkflow(strings.NewReader(`{"jobs": [
+		{"jobId": "seq", "command": ["sh", "-c", "seq 1 200000 > ` + dir + `/fifo1"],
+		 "outputs": [{"writeTo": "TEXT", "path": "` + dir + `/fifo1"}]},
+		{"jobId": "count", "command": ["sh", "-c", "wc -c < ` + dir + `/fifo2 > /dev/null"],
+		 "inputs": [{"readFrom": "TEXT", "path": "` + dir + `/fifo2"}]},
+		{"jobId": "head", "command": ["sh", "-c", "head -c 10 ` + dir + `/fifo3 > /dev/null"],
+		 "inputs": [{"readFrom": "TEXT", "path": "` + dir + `/fifo3"}]}
+	]}`))
+	assert.NoError(t, err)
+	result := workflow.Execute(make(chan Event, 10))
+	var size int64
+	for i := 1; i <= 200000; i++ {
+		size += int64(len(strconv.Itoa(i)) + 1)
+	}
+	if !assert.Len(t, result.Pipes, 1) {
+		return
+	}
+	pipe := result.Pipes[0]
+	assert.Equal(t, "TEXT", pipe.Key)
+	assert.Equal(t, "seq", pipe.Producer)
+	assert.Equal(t, size, pipe.BytesRead)
+	assert.Greater(t, pipe.Throughput, 0.0)
+	assert.True(t, pipe.ClosedEarly)
+	if assert.Len(t, pipe.Consumers, 2) {
+		assert.Equal(t, "count", pipe.Consumers[0].JobId)
+		assert.Equal(t, size, pipe.Consumers[0].BytesWritten)
+		assert.False(t, pipe.Consumers[0].ClosedEarly)
+		assert.Equal(t, "head", pipe.Consumers[1].JobId)
+		assert.Less(t, pipe.Consumers[1].BytesWritten, size)
+		assert.True(t, pipe.Consumers[1].ClosedEarly)
+	}
+}
+
+func TestPipeEvents(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "sample.fastq")
+	workflow, err := LoadWorkflow(strings.NewReader(`{"objectstore": {"endpoint": "file:../testdata/objectstore"}, "jobs": [
+		{"jobId": "download", "type": "ObjectStore", "bucket": "bucket", "key": "runs/sample_L001.fastq", "writeTo": "FASTQ"},
+		{"jobId": "sink", "type": "File", "readFrom": "FASTQ", "path": "` + out + `"}
+	]}`))
+	assert.NoError(t, err)
+	workflow.JobEvents = true
+	workflow.PipeEvents = true
+	events := make(chan Event, 10)
+	var pipeEvents []*PipeEvent
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for event := range events {
+			if pipeEvent, ok := event.(*PipeEvent); ok {
+				pipeEvents = append(pipeEvents, pipeEvent)
+			}
+			if _, ok := event.(*WorkflowEvent); ok {
+				return
+			}
+		}
+	}()
+	result := workflow.Execute(events)
+	<-done
+	assert.Equal(t, Successed, result.Status)
+	info, err := os.Stat("../testdata/objectstore/bucket/runs/sample_L001.fastq")
+	assert.NoError(t, err)
+	if assert.NotEmpty(t, pipeEvents) {
+		last := pipeEvents[len(pipeEvents)-1]
+		assert.True(t, last.Finished)
+		assert.Equal(t, "FASTQ", last.Pipe.Key)
+		assert.Equal(t, "download", last.Pipe.Producer)
+		assert.Equal(t, info.Size(), last.Pipe.Size)
+		assert.Equal(t, info.Size(), last.Pipe.BytesRead)
+	}
+}
@@ -0,0 +1,195 @@
+package workflow
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// hostName returns the name of the host of the jobs placed on host,
+// which is empty for the jobs running in flowyexec itself.
+func hostName(host string) string {
+	if host == "" {
+		return "flowyexec"
+	}
+	return host
+}
+
+func agentJobId(name string) string {
+	return "<agent " + name + ">"
+}
+
+func (dto *WorkflowDto) hasPlacements() bool {
+	for _, job := range dto.Jobs {
+		if job.Placement != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// validatePlacements checks the placements of the jobs on agents.
+// A job cannot depend on a job on another host, and the jobs connected
+// by pipes crossing hosts cannot be retried, because the pipes are
+// connected only once.
+func (dto *WorkflowDto) validatePlacements() error {
+	for name, addr := range dto.Agents {
+		if addr == "" {
+			return fmt.Errorf("agent %s requires an address", name)
+		}
+	}
+	hosts := map[string]string{}
+	for _, job := range dto.Jobs {
+		if job.Placement != "" {
+			if _, ok := dto.Agents[job.Placement]; !ok {
+				return fmt.Errorf("job %s has undefined placement %s", job.JobId, job.Placement)
+			}
+			if job.Type == "Workflow" {
+				return fmt.Errorf("workflow job %s cannot be placed on an agent", job.JobId)
+			}
+		}
+		hosts[job.JobId] = job.Placement
+	}
+	jobComponents, _ := pipeComponents(dto.Jobs)
+	componentHosts := map[int]map[string]bool{}
+	for _, job := range dto.Jobs {
+		for _, dependency := range job.dependencies() {
+			if hosts[dependency.JobId] != job.Placement {
+				return fmt.Errorf("job %s cannot depend on job %s on another host", job.JobId, dependency.JobId)
+			}
+		}
+		component := jobComponents[job.JobId]
+		if componentHosts[component] == nil {
+			componentHosts[component] = map[string]bool{}
+		}
+		componentHosts[component][job.Placement] = true
+	}
+	for _, job := range dto.Jobs {
+		if job.Retries > 0 && len(componentHosts[jobComponents[job.JobId]]) > 1 {
+			return fmt.Errorf("job %s cannot be retried because its pipes connect jobs on other hosts", job.JobId)
+		}
+	}
+	return nil
+}
+
+// partition splits the jobs of a workflow by their placements, keyed by
+// the name of the agent or "" for the jobs running in flowyexec. A pipe
+// between jobs on different hosts is replaced with a NetSend job on the
+// host of the producer and a NetReceive job on the host of the consumers,
+// which are ordered so that the producers of the pipes precede the
+// consumers on every host. The jobs sent to the agents have no placement.
+func (dto *WorkflowDto) partition() map[string][]*JobDto {
+	producers := map[string]string{}
+	for _, job := range dto.Jobs {
+		for _, key := range job.outputKeys() {
+			producers[key] = job.Placement
+		}
+	}
+	receives := map[string][]*JobDto{}
+	jobs := map[string][]*JobDto{}
+	sends := map[string][]*JobDto{}
+	bridged := map[string]bool{}
+	for _, job := range dto.Jobs {
+		host := job.Placement
+		if host != "" {
+			placed := *job
+			placed.Placement = ""
+			job = &placed
+		}
+		jobs[host] = append(jobs[host], job)
+		for _, key := range job.inputKeys() {
+			from, ok := producers[key]
+			if !ok || from == host || bridged[key+"\x00"+host] {
+				continue
+			}
+			bridged[key+"\x00"+host] = true
+			send := &JobDto{JobId: sendBridgeId(key, host), Type: netSendType, ReadFrom: key}
+			receive := &JobDto{JobId: receiveBridgeId(key, from), Type: netReceiveType, WriteTo: key}
+			// flowyexec dials the agents as it does not accept connections
+			if host != "" {
+				send.Url = "tcp://" + dto.Agents[host]
+			} else {
+				receive.Url = "tcp://" + dto.Agents[from]
+			}
+			sends[from] = append(sends[from], send)
+			receives[host] = append(receives[host], receive)
+		}
+	}
+	partitions := map[string][]*JobDto{"": {}}
+	for _, hostJobs := range []map[string][]*JobDto{receives, jobs, sends} {
+		for host, list := range hostJobs {
+			partitions[host] = append(partitions[host], list...)
+		}
+	}
+	return partitions
+}
+
+// placeJobs returns the jobs running in flowyexec, with an Agent job for
+// every agent running jobs, and the ids of the jobs added to run the jobs
+// on the agents, whose results are not reported.
+func (dto *WorkflowDto) placeJobs() ([]*JobDto, map[string]bool) {
+	partitions := dto.partition()
+	hidden := map[string]bool{}
+	names := make([]string, 0, len(partitions))
+	for name, jobs := range partitions {
+		for _, job := range jobs {
+			if job.Type == netSendType || job.Type == netReceiveType {
+				hidden[job.JobId] = true
+			}
+		}
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	local := partitions[""]
+	for _, name := range names {
+		agent := &JobDto{
+			JobId: agentJobId(name),
+			Type:  agentType,
+			Url:   "tcp://" + dto.Agents[name],
+			workflow: &WorkflowDto{
+				Name:         dto.Name,
+				Objectstore:  dto.Objectstore,
+				Objectstores: dto.Objectstores,
+				Parameters:   dto.Parameters,
+				Jobs:         partitions[name],
+			},
+		}
+		hidden[agent.JobId] = true
+		local = append(local, agent)
+	}
+	return local, hidden
+}
+
+// placedResults returns the results of the jobs of the workflow in the
+// order of its definition, replacing the results of the hidden jobs with
+// the results of the jobs run by the agents.
+func (w *Workflow) placedResults(jobs []Job, results []*JobResult) []*JobResult {
+	if w.jobOrder == nil {
+		return results
+	}
+	byId := map[string]*JobResult{}
+	for _, result := range results {
+		byId[result.JobId] = result
+	}
+	for _, job := range jobs {
+		if agent, ok := job.(*agentJob); ok {
+			for _, result := range agent.remoteResults() {
+				byId[result.JobId] = result
+			}
+		}
+	}
+	placed := make([]*JobResult, 0, len(w.jobOrder))
+	for _, jobId := range w.jobOrder {
+		if result, ok := byId[jobId]; ok {
+			placed = append(placed, result)
+		}
+	}
+	return placed
+}
+
+// isReservedJobId reports whether jobId is reserved for the jobs added by flowyexec.
+func isReservedJobId(jobId string) bool {
+	return strings.HasPrefix(jobId, "<")
+}
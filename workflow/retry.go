@@ -0,0 +1,206 @@
+package workflow
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// RetryPolicy selects the failures of a batch job which are retried.
+// A failure is retried if the job has exited with one of ExitCodes
+// or has been killed by one of Signals, such as SIGKILL or KILL.
+type RetryPolicy struct {
+	ExitCodes []int
+	Signals   []string
+}
+
+// JobAttempt is the result of an attempt of a job which has been retried.
+type JobAttempt struct {
+	Status   JobStatus
+	Start    *time.Time
+	End      *time.Time
+	ExitCode int
+	Message  string
+	Signal   string `json:",omitempty"`
+}
+
+func newJobAttempt(result *JobResult) *JobAttempt {
+	return &JobAttempt{
+		Status:   result.Status,
+		Start:    result.Start,
+		End:      result.End,
+		ExitCode: result.ExitCode,
+		Message:  result.Message,
+		Signal:   result.Signal,
+	}
+}
+
+// signalNumber returns the signal named name with or without the SIG prefix,
+// or 0 if there is no such signal.
+func signalNumber(name string) syscall.Signal {
+	name = strings.ToUpper(name)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	return unix.SignalNum(name)
+}
+
+func (p *RetryPolicy) validate(jobId string) error {
+	if p == nil {
+		return nil
+	}
+	for _, signal := range p.Signals {
+		if signalNumber(signal) == 0 {
+			return fmt.Errorf("job %s has unsupported signal %s in retryOn", jobId, signal)
+		}
+	}
+	return nil
+}
+
+// matches reports whether a failed job is retried.
+// Every failure is retried if there is no policy.
+func (p *RetryPolicy) matches(result *JobResult) bool {
+	if p == nil {
+		return true
+	}
+	if result.Signal != "" {
+		for _, signal := range p.Signals {
+			if signalNumber(signal) == signalNumber(result.Signal) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, code := range p.ExitCodes {
+		if code == result.ExitCode {
+			return true
+		}
+	}
+	return false
+}
+
+// restartable reports whether a job can run again from the beginning.
+// The other jobs read their sources again and overwrite their destinations,
+// while a POST request may not be sent twice.
+func (jobDto *JobDto) restartable() bool {
+	if jobDto.workflow != nil {
+		for _, job := range jobDto.workflow.Jobs {
+			if !job.restartable() {
+				return false
+			}
+		}
+	}
+	return !(jobDto.Type == "HTTP" && jobDto.ReadFrom != "" && jobDto.Method == http.MethodPost)
+}
+
+// validateRetries checks that the jobs connected by pipes to a retried job
+// can restart with it.
+func validateRetries(jobs []*JobDto) error {
+	jobComponents, _ := pipeComponents(jobs)
+	for _, job := range jobs {
+		if job.Retries == 0 {
+			continue
+		}
+		for _, other := range jobs {
+			if jobComponents[other.JobId] == jobComponents[job.JobId] && !other.restartable() {
+				return fmt.Errorf("job %s cannot be retried because job %s connected by pipes cannot restart", job.JobId, other.JobId)
+			}
+		}
+	}
+	return nil
+}
+
+// hasRetries reports whether a job of a group can be retried after the current attempt.
+func (w *Workflow) hasRetries(group *jobGroup) bool {
+	for _, run := range group.runs {
+		if dto, ok := w.jobDtos[run.job.GetId()]; ok && dto.Retries >= group.attempt {
+			return true
+		}
+	}
+	return false
+}
+
+// shouldRetry reports whether a job of a group has failed
+// in the way it is retried and it has retries left.
+// The jobs aborted because of the failure of another job are not counted.
+func (w *Workflow) shouldRetry(group *jobGroup) bool {
+	for _, run := range group.runs {
+		dto, ok := w.jobDtos[run.job.GetId()]
+		if !ok || dto.Retries < group.attempt || run.job.GetStatus() != Failed {
+			continue
+		}
+		if dto.RetryOn.matches(run.job.GetResult()) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryGroup records the attempt of the jobs of a group and executes
+// the jobs again with new FIFOs and PipeHandlers. The jobs run with the
+// decisions of the first attempt, since the jobs they depend on have finished.
+func (w *Workflow) retryGroup(group *jobGroup, wg *sync.WaitGroup) {
+	jobs := make([]Job, 0, len(group.runs))
+	jobIds := make([]string, 0, len(group.runs))
+	for _, run := range group.runs {
+		run.attempts = append(run.attempts, newJobAttempt(run.job.GetResult()))
+		run.job = w.createJob(w.jobDtos[run.job.GetId()])
+		jobs = append(jobs, run.job)
+		jobIds = append(jobIds, run.job.GetId())
+	}
+	group.attempt++
+	group.running = len(group.runs)
+	oldHandlers := group.handlers
+	group.handlers = CreateHandlers(jobs)
+	w.replaceJobs(jobs, oldHandlers, group.handlers)
+	if w.isCanceled() {
+		// Cancel has not aborted the jobs if it has run before they are replaced
+		for _, job := range jobs {
+			job.Abort()
+		}
+	}
+	w.log().WithFields(logrus.Fields{"jobIds": jobIds, "attempt": group.attempt}).Warn("Retry Jobs")
+	w.startHandlers(group.handlers)
+	for _, run := range group.runs {
+		wg.Add(1)
+		go func(run *jobRun) {
+			defer wg.Done()
+			w.executeJob(run, wg)
+		}(run)
+	}
+}
+
+// replaceJobs replaces the jobs and the handlers of a retried group.
+func (w *Workflow) replaceJobs(jobs []Job, oldHandlers []*PipeHandler, newHandlers []*PipeHandler) {
+	replaced := map[string]Job{}
+	for _, job := range jobs {
+		replaced[job.GetId()] = job
+	}
+	removed := map[*PipeHandler]bool{}
+	for _, handler := range oldHandlers {
+		removed[handler] = true
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	allJobs := make([]Job, 0, len(w.Jobs))
+	for _, job := range w.Jobs {
+		if newJob, ok := replaced[job.GetId()]; ok {
+			job = newJob
+		}
+		allJobs = append(allJobs, job)
+	}
+	w.Jobs = allJobs
+	handlers := make([]*PipeHandler, 0, len(w.handlers))
+	for _, handler := range w.handlers {
+		if !removed[handler] {
+			handlers = append(handlers, handler)
+		}
+	}
+	w.handlers = append(handlers, newHandlers...)
+}
@@ -0,0 +1,62 @@
+package workflow
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateRetries(t *testing.T) {
+	tests := []struct {
+		name string
+		jobs string
+		err  string
+	}{
+		{
+			name: "negative retries",
+			jobs: `[{"jobId": "a", "command": ["true"], "retries": -1}]`,
+			err:  "job a has negative retries",
+		},
+		{
+			name: "unsupported signal",
+			jobs: `[{"jobId": "a", "command": ["true"], "retries": 1, "retryOn": {"signals": ["SIGNOPE"]}}]`,
+			err:  "job a has unsupported signal SIGNOPE in retryOn",
+		},
+		{
+			name: "not a batch job",
+			jobs: `[{"jobId": "a", "type": "Literal", "writeTo": "K", "retries": 1}]`,
+			err:  "job a cannot be retried because it is not a batch job",
+		},
+		{
+			name: "not restartable",
+			jobs: `[{"jobId": "a", "command": ["true"], "outputs": [{"writeTo": "K", "path": "fifo1"}], "retries": 1}, {"jobId": "b", "type": "HTTP", "readFrom": "K", "url": "http://localhost/", "method": "POST"}]`,
+			err:  "job a cannot be retried because job b connected by pipes cannot restart",
+		},
+		{
+			name: "valid",
+			jobs: `[{"jobId": "a", "command": ["true"], "outputs": [{"writeTo": "K", "path": "fifo1"}], "retries": 1, "retryOn": {"exitCodes": [1], "signals": ["KILL"]}}, {"jobId": "b", "type": "HTTP", "readFrom": "K", "url": "http://localhost/"}]`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := LoadWorkflow(strings.NewReader(`{"jobs": ` + test.jobs + `}`))
+			if test.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, test.err)
+			}
+		})
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	policy := &RetryPolicy{ExitCodes: []int{3}, Signals: []string{"kill", "SIGTERM"}}
+	assert.True(t, policy.matches(&JobResult{ExitCode: 3}))
+	assert.False(t, policy.matches(&JobResult{ExitCode: 1}))
+	assert.True(t, policy.matches(&JobResult{ExitCode: -1, Signal: "SIGKILL"}))
+	assert.True(t, policy.matches(&JobResult{ExitCode: -1, Signal: "SIGTERM"}))
+	assert.False(t, policy.matches(&JobResult{ExitCode: -1, Signal: "SIGINT"}))
+	var none *RetryPolicy
+	assert.True(t, none.matches(&JobResult{ExitCode: 1}))
+}
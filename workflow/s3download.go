@@ -3,61 +3,57 @@ package workflow
 import (
 	"fmt"
 	"io"
-	"os"
+	"path"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/sirupsen/logrus"
 )
 
-type ObjectStore struct {
-	Region    string
-	Endpoint  string
-	AccessKey string
-	SecretKey string
+// ObjectStoreDownloadJob streams objects to pipes.
+// It reads the object named by key, or the objects listed under prefix
+// or matching key when key contains glob characters.
+// The listed objects are sorted by key, and are either concatenated into
+// the output given by writeTo, or streamed one by one into the outputs.
+type ObjectStoreDownloadJob struct {
+	workflow   *Workflow
+	jobId      string
+	status     JobStatus
+	Bucket     string
+	key        string
+	prefix     string
+	store      ObjectStoreBackend
+	storeName  string
+	encryption *EncryptionConfig
+	outputs    []*objectStoreDownloadOutput
+	Start      time.Time
+	End        time.Time
+	listOnce   sync.Once
+	objects    []string
+	listError  error
+	readError  error
+	closeCh    chan JobStatus
+	// started is closed when the job is executed,
+	// which may be delayed by the dependencies of the job.
+	started chan struct{}
 }
 
-var session_1 *session.Session
-
-func (o *ObjectStore) Init() error {
-	var c *credentials.Credentials
-	if o.AccessKey != "" && o.SecretKey != "" {
-		c = credentials.NewStaticCredentials(o.AccessKey, o.SecretKey, "")
-	}
-	var endpoints *string
-	if o.Endpoint != "" {
-		endpoints = &o.Endpoint
-	}
-	tmp := os.Getenv("OBJECTSTORE_ENDPOINT")
-	if tmp != "" {
-		endpoints = &tmp
-	}
-	config := &aws.Config{
-		Region:           aws.String(o.Region),
-		Credentials:      c,
-		Endpoint:         endpoints,
-		S3ForcePathStyle: aws.Bool(o.Endpoint != ""),
-		DisableSSL:       aws.Bool(true),
-	}
-	var err error
-	session_1, err = session.NewSession(config)
-	return err
+type objectStoreDownloadOutput struct {
+	job     *ObjectStoreDownloadJob
+	writeTo string
+	// index is the index of the object streamed to this output,
+	// or -1 if all objects are concatenated.
+	index  int
+	reader io.ReadCloser
+	// length is the size of the object if it is known, which is
+	// unknown if the objects are concatenated or decrypted.
+	length int64
 }
 
-type ObjectStoreDownloadJob struct {
-	jobId   string
-	status  JobStatus
-	writeTo string
-	Bucket  string
-	key     string
-	Start   time.Time
-	End     time.Time
-	reader  io.ReadCloser
-	closeCh chan JobStatus
+func (o *objectStoreDownloadOutput) size() int64 {
+	return o.length
 }
 
 func (job *ObjectStoreDownloadJob) GetResult() *JobResult {
@@ -67,10 +63,20 @@ func (job *ObjectStoreDownloadJob) GetResult() *JobResult {
 		Start:    &job.Start,
 		End:      &job.End,
 		ExitCode: job.status.GetDefaultExitCode(),
-		Message:  "",
+		Message:  job.message(),
 	}
 }
 
+func (job *ObjectStoreDownloadJob) message() string {
+	if job.listError != nil {
+		return job.listError.Error()
+	}
+	if job.readError != nil {
+		return job.readError.Error()
+	}
+	return ""
+}
+
 func (job *ObjectStoreDownloadJob) GetId() string {
 	return job.jobId
 }
@@ -83,72 +89,249 @@ func (job *ObjectStoreDownloadJob) GetInputs() []Input {
 }
 
 func (job *ObjectStoreDownloadJob) GetOutputs() []Output {
-	return []Output{job}
-}
-func (job *ObjectStoreDownloadJob) Label() string {
-	return job.key
-}
-
-func (job *ObjectStoreDownloadJob) IsFailed() bool {
-	return job.status.IsFailed()
-}
-func (job *ObjectStoreDownloadJob) Clear() {
+	outputs := make([]Output, 0, len(job.outputs))
+	for _, output := range job.outputs {
+		outputs = append(outputs, output)
+	}
+	return outputs
 }
 
 func (job *ObjectStoreDownloadJob) Execute(wf *Workflow, wg *sync.WaitGroup) {
 	defer wg.Done()
 	defer wf.UnBlock()
+	if job.status == Skipped {
+		close(job.started)
+		job.workflow.jobLog(job.jobId).Info("Job Skipped")
+		return
+	}
 	if job.status.IsFinished() {
-		logrus.WithFields(logrus.Fields{"jobId": job.jobId, "status": job.status, "exitCode": -1}).Warn("Job Failed")
+		close(job.started)
+		job.workflow.jobLog(job.jobId).WithFields(logrus.Fields{"status": job.status, "exitCode": -1}).Warn("Job Failed")
 		return
 	}
 	job.status = Running
-	status := <-job.closeCh
-	if status.IsFinished() {
-		job.status = status
-		if status == Successed {
-			logrus.WithFields(logrus.Fields{"jobId": job.jobId, "status": job.status, "exitCode": 0}).Warn("Job Finished")
-		} else {
-			logrus.WithFields(logrus.Fields{"jobId": job.jobId, "status": job.status, "exitCode": -1}).Warn("Job Failed")
+	job.Start = time.Now()
+	close(job.started)
+	status := Successed
+	for range job.outputs {
+		status = <-job.closeCh
+		if status != Successed {
+			break
 		}
 	}
+	job.End = time.Now()
+	if status == Aborted && (job.readError != nil || job.listError != nil) {
+		// the objects could not be read, it is not caused by other jobs
+		status = Failed
+	}
+	job.status = status
+	if status == Successed {
+		job.workflow.jobLog(job.jobId).WithFields(logrus.Fields{"status": job.status, "exitCode": 0}).Warn("Job Finished")
+	} else {
+		job.workflow.jobLog(job.jobId).WithFields(logrus.Fields{"status": job.status, "exitCode": -1}).Warn("Job Failed")
+	}
+}
+
+// notify tells Execute that an output is finished.
+// It never blocks, because Execute stops receiving once an output fails.
+func (job *ObjectStoreDownloadJob) notify(status JobStatus) {
+	select {
+	case job.closeCh <- status:
+	default:
+	}
 }
 
+// Abort aborts the job, which is marked as aborted if it has not been
+// started so that its outputs neither list nor open objects.
 func (job *ObjectStoreDownloadJob) Abort() {
-	job.closeCh <- Aborted
+	if job.status == Created {
+		job.status = Aborted
+	}
+	job.notify(Aborted)
 }
 
-func (job *ObjectStoreDownloadJob) Read(p []byte) (n int, err error) {
-	return job.reader.Read(p)
+// Skip marks the job as skipped unless it has been started.
+func (job *ObjectStoreDownloadJob) Skip() {
+	if job.status == Created {
+		job.status = Skipped
+	}
 }
 
-func (job *ObjectStoreDownloadJob) Close() error {
-	err := job.reader.Close()
-	job.closeCh <- Successed
-	return err
+// listObjects returns the keys of the objects to download in order.
+func (job *ObjectStoreDownloadJob) listObjects(backend ObjectStoreBackend) ([]string, error) {
+	job.listOnce.Do(func() {
+		if job.prefix == "" && !hasGlob(job.key) {
+			job.objects = []string{job.key}
+			return
+		}
+		prefix := job.prefix
+		if prefix == "" {
+			prefix = job.key[:strings.IndexAny(job.key, "*?[")]
+		}
+		keys, err := backend.List(job.workflow.jobContext(job.jobId), job.Bucket, prefix)
+		if err != nil {
+			job.listError = err
+			return
+		}
+		objects := []string{}
+		for _, key := range keys {
+			if job.prefix == "" {
+				if matched, _ := path.Match(job.key, key); !matched {
+					continue
+				}
+			}
+			objects = append(objects, key)
+		}
+		sort.Strings(objects)
+		if len(objects) == 0 {
+			job.listError = fmt.Errorf("no object matches %s in bucket %s", job.Label(), job.Bucket)
+		} else if job.outputs[0].index >= 0 && len(objects) != len(job.outputs) {
+			job.listError = fmt.Errorf("%d objects match %s, but the job has %d outputs", len(objects), job.Label(), len(job.outputs))
+		}
+		job.objects = objects
+	})
+	return job.objects, job.listError
 }
 
-func (job *ObjectStoreDownloadJob) Key() string {
-	return job.writeTo
+func hasGlob(key string) bool {
+	return strings.ContainsAny(key, "*?[")
 }
-func (p *ObjectStoreDownloadJob) UnBlock() {
 
+func (job *ObjectStoreDownloadJob) Label() string {
+	if job.prefix != "" {
+		return job.prefix
+	}
+	return job.key
 }
 
-func (job *ObjectStoreDownloadJob) GetReader() (io.ReadCloser, error) {
-	if session_1 == nil {
-		return nil, fmt.Errorf("s3 session is not initialized")
+func (job *ObjectStoreDownloadJob) getObject(backend ObjectStoreBackend, key string) (io.ReadCloser, error) {
+	sse, err := job.encryption.sseParams()
+	if err != nil {
+		return nil, err
 	}
-	s3c := s3.New(session_1)
-	out, err := s3c.GetObject(&s3.GetObjectInput{
-		Bucket: aws.String(job.Bucket),
-		Key:    aws.String(job.key),
-	})
+	kek, err := job.encryption.encryptionKey()
+	if err != nil {
+		return nil, err
+	}
+	reader, err := backend.Open(job.workflow.jobContext(job.jobId), job.Bucket, key, sse)
+	if err != nil {
+		return nil, err
+	}
+	if kek != nil {
+		return newDecryptingReader(reader, kek), nil
+	}
+	return reader, nil
+}
+
+func (o *objectStoreDownloadOutput) Label() string {
+	if o.index >= 0 && o.index < len(o.job.objects) {
+		return o.job.objects[o.index]
+	}
+	return o.job.Label()
+}
+
+func (o *objectStoreDownloadOutput) IsFailed() bool {
+	return o.job.status.IsFailed()
+}
+func (o *objectStoreDownloadOutput) Clear() {
+}
+func (o *objectStoreDownloadOutput) Abort() {
+	o.job.Abort()
+}
+func (o *objectStoreDownloadOutput) Key() string {
+	return o.writeTo
+}
+func (o *objectStoreDownloadOutput) UnBlock() {
+}
+
+func (o *objectStoreDownloadOutput) Read(p []byte) (n int, err error) {
+	n, err = o.reader.Read(p)
+	if err != nil && err != io.EOF {
+		o.job.readError = err
+	}
+	return n, err
+}
+
+func (o *objectStoreDownloadOutput) Close() error {
+	err := o.reader.Close()
+	o.job.notify(Successed)
+	return err
+}
+
+func (o *objectStoreDownloadOutput) GetReader() (io.ReadCloser, error) {
+	job := o.job
+	<-job.started
+	if job.status == Skipped {
+		return nil, errSkipped
+	}
+	if job.status.IsFinished() {
+		return nil, fmt.Errorf("Job %s has already finished", job.jobId)
+	}
+	backend := job.store
+	if backend == nil {
+		var err error
+		if backend, err = job.workflow.objectStoreBackend(job.storeName); err != nil {
+			job.status = Failed
+			return nil, err
+		}
+	}
+	objects, err := job.listObjects(backend)
 	if err != nil {
 		job.status = Failed
 		return nil, err
+	}
+	if o.index >= 0 {
+		objects = objects[o.index : o.index+1]
+	}
+	reader, err := job.getObject(backend, objects[0])
+	if err != nil {
+		job.status = Failed
+		return nil, err
+	}
+	if len(objects) == 1 {
+		o.length = objectSize(reader)
 	} else {
-		job.reader = out.Body
-		return job, nil
+		reader = &concatenatedReader{
+			reader: reader,
+			keys:   objects[1:],
+			open: func(key string) (io.ReadCloser, error) {
+				return job.getObject(backend, key)
+			},
+		}
 	}
+	o.reader = reader
+	return o, nil
+}
+
+// concatenatedReader reads objects one after another, opening each of them
+// when the previous one is completely read.
+type concatenatedReader struct {
+	reader io.ReadCloser
+	keys   []string
+	open   func(key string) (io.ReadCloser, error)
+}
+
+func (r *concatenatedReader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.reader.Read(p)
+		if err != io.EOF || len(r.keys) == 0 {
+			return n, err
+		}
+		if err := r.reader.Close(); err != nil {
+			return n, err
+		}
+		r.reader, err = r.open(r.keys[0])
+		if err != nil {
+			r.reader = io.NopCloser(strings.NewReader(""))
+			return n, fmt.Errorf("cannot read %s: %w", r.keys[0], err)
+		}
+		r.keys = r.keys[1:]
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+func (r *concatenatedReader) Close() error {
+	return r.reader.Close()
 }
@@ -1,142 +1,168 @@
 package workflow
 
 import (
-	"bytes"
 	"fmt"
 	"io"
+	"mime"
+	"path"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/sirupsen/logrus"
 )
 
 type ObjectStoreUploadJob struct {
-	jobId    string
-	status   JobStatus
-	readFrom string
-	Bucket   string
-	key      string
-	uploader *ObjectStoreUploader
-	closeCh  chan JobStatus
-	Start    time.Time
-	End      time.Time
-}
-
-type ObjectStoreUploader struct {
-	job             *ObjectStoreUploadJob
-	output          *s3.CreateMultipartUploadOutput
-	client          *s3.S3
-	buff            []byte
-	len             int64
-	compuletedParts []*s3.CompletedPart
-	partNumber      int64
-	total_uploaded  int
-	total_writed    int
-}
-
-func (p *ObjectStoreUploader) upload() error {
-	if p.len <= 0 {
-		return nil
+	workflow   *Workflow
+	jobId      string
+	status     JobStatus
+	readFrom   string
+	Bucket     string
+	key        string
+	store      ObjectStoreBackend
+	storeName  string
+	encryption *EncryptionConfig
+	options    uploadOptions
+	err        error
+	closeCh    chan JobStatus
+	Start      time.Time
+	End        time.Time
+	// started is closed when the job is executed,
+	// which may be delayed by the dependencies of the job.
+	started chan struct{}
+}
+
+// uploadOptions holds the properties set to uploaded objects.
+type uploadOptions struct {
+	contentType     string
+	contentEncoding string
+	storageClass    string
+	acl             string
+	metadata        map[string]string
+	tags            map[string]string
+}
+
+// contentTypes maps the extensions of files used in bioinformatics,
+// which are not known by mime.TypeByExtension, to content types.
+var contentTypes = map[string]string{
+	".bam":   "application/octet-stream",
+	".bed":   "text/plain",
+	".bgz":   "application/gzip",
+	".cram":  "application/octet-stream",
+	".fa":    "text/plain",
+	".fasta": "text/plain",
+	".fastq": "text/plain",
+	".fq":    "text/plain",
+	".gff":   "text/plain",
+	".gtf":   "text/plain",
+	".gz":    "application/gzip",
+	".sam":   "text/plain",
+	".vcf":   "text/plain",
+	".zst":   "application/zstd",
+}
+
+// detectContentType returns the content type of an object from the extension of its key.
+func detectContentType(key string) string {
+	ext := strings.ToLower(path.Ext(key))
+	if contentType, ok := contentTypes[ext]; ok {
+		return contentType
 	}
-	PartNumber := aws.Int64(p.partNumber)
-	input := s3.UploadPartInput{
-		Body:          bytes.NewReader(p.buff[:p.len]),
-		Bucket:        p.output.Bucket,
-		Key:           p.output.Key,
-		PartNumber:    PartNumber,
-		UploadId:      p.output.UploadId,
-		ContentLength: aws.Int64(p.len),
+	if contentType := mime.TypeByExtension(ext); contentType != "" {
+		return contentType
 	}
-	resp, err := p.client.UploadPart(&input)
-	if err != nil {
-		p.job.status = Failed
-		return err
-	}
-	completedPart := s3.CompletedPart{
-		ETag:       resp.ETag,
-		PartNumber: PartNumber,
-	}
-	fmt.Printf("uploaded %d bytes\n", p.len)
-	p.total_uploaded += int(p.len)
-	p.compuletedParts = append(p.compuletedParts, &completedPart)
-	p.len = 0
-	p.partNumber++
-	return err
+	return "application/octet-stream"
 }
-func (p *ObjectStoreUploader) Write(data []byte) (n int, err error) {
-	p.total_writed += len(data)
-	curr := data
-	buff := p.buff[p.len:]
-	var total_writed int = 0
-	for {
-		if len(curr) < len(buff) {
-			copy(buff, curr)
-			p.len += int64(len(curr))
-			// copy to buffer and return if buffer is not full
-			total_writed += len(curr)
-			return total_writed, nil
+
+// properties returns the properties of the uploaded object,
+// whose metadata records the workflow and the job producing the object.
+func (p *ObjectStoreUploadJob) properties(sse *sseParams, encrypted bool) *objectProperties {
+	contentType := p.options.contentType
+	if contentType == "" {
+		if encrypted {
+			contentType = "application/octet-stream"
 		} else {
-			copy(buff, curr[:len(buff)])
-			p.len += int64(len(curr))
-			total_writed += len(buff)
-			err := p.upload()
-			if err != nil {
-				p.job.status = Failed
-				return total_writed, err
-			}
-			curr = curr[len(buff):]
-			buff = p.buff
+			contentType = detectContentType(p.key)
 		}
 	}
+	metadata := map[string]string{}
+	for k, v := range p.options.metadata {
+		metadata[k] = v
+	}
+	if p.workflow != nil {
+		if p.workflow.Name != "" {
+			metadata["flowy-workflow"] = p.workflow.Name
+		}
+		metadata["flowy-run-id"] = p.workflow.RunId
+	}
+	metadata["flowy-job-id"] = p.jobId
+	return &objectProperties{
+		contentType:     contentType,
+		contentEncoding: p.options.contentEncoding,
+		storageClass:    p.options.storageClass,
+		acl:             p.options.acl,
+		metadata:        metadata,
+		tags:            p.options.tags,
+		sse:             sse,
+	}
 }
-func (p *ObjectStoreUploader) Abort() {
-	p.job.Abort()
-}
-func (p *ObjectStoreUploader) Key() string {
-	return p.job.key
+
+// objectStoreUploadWriter reports the result of the upload to the job.
+// If the job is aborted before the writer is closed, the object is discarded.
+type objectStoreUploadWriter struct {
+	job      *ObjectStoreUploadJob
+	writer   ObjectWriter
+	written  int64
+	progress progressLog
 }
-func (p *ObjectStoreUploader) Close() error {
-	err := p.upload()
-	if err != nil {
-		p.job.status = Failed
-		p.job.closeCh <- Failed
-		return err
-	}
-	resp := p.output
-	completeInput := s3.CompleteMultipartUploadInput{
-		Bucket:   resp.Bucket,
-		Key:      resp.Key,
-		UploadId: resp.UploadId,
-		MultipartUpload: &s3.CompletedMultipartUpload{
-			Parts: p.compuletedParts,
-		},
-	}
-	_, err = p.client.CompleteMultipartUpload(&completeInput)
+
+func (w *objectStoreUploadWriter) Write(data []byte) (int, error) {
+	n, err := w.writer.Write(data)
+	w.written += int64(n)
 	if err != nil {
-		p.job.status = Failed
+		w.job.status = Failed
+		w.job.err = err
+	}
+	if w.progress.due() {
+		w.job.workflow.jobLog(w.job.jobId).WithFields(logrus.Fields{"bytes": w.written}).Info("Upload Progress")
+	}
+	return n, err
+}
 
-	} else {
-		p.job.status = Successed
+func (w *objectStoreUploadWriter) Close() error {
+	if w.job.status == Aborted {
+		w.writer.Abort()
+		w.job.notify(Aborted)
+		return nil
+	}
+	err := w.writer.Close()
+	if err != nil {
+		w.job.status = Failed
+		w.job.err = err
+	} else if w.job.status != Failed {
+		w.job.status = Successed
 	}
-	p.job.closeCh <- p.job.status
+	w.job.notify(w.job.status)
 	return err
 }
+
 func (job *ObjectStoreUploadJob) Execute(wf *Workflow, wg *sync.WaitGroup) {
 	defer wg.Done()
 	defer wf.UnBlock()
-	job.status = Running
+	if !job.status.IsFinished() {
+		job.status = Running
+	}
 	job.Start = time.Now()
+	close(job.started)
 	status := <-job.closeCh
-	job.closeCh = nil
 	job.status = status
 	job.End = time.Now()
 	if status.IsFinished() {
-		if status.IsFailed() {
-			logrus.WithFields(logrus.Fields{"jobId": job.jobId, "status": job.status, "exitCode": -1}).Warn("Job Failed")
+		if status == Skipped {
+			job.workflow.jobLog(job.jobId).Info("Job Skipped")
+		} else if status.IsFailed() {
+			job.workflow.jobLog(job.jobId).WithFields(logrus.Fields{"status": job.status, "exitCode": -1}).Warn("Job Failed")
 		} else {
-			logrus.WithFields(logrus.Fields{"jobId": job.jobId, "status": job.status, "exitCode": 0}).Warn("Job Finished")
+			job.workflow.jobLog(job.jobId).WithFields(logrus.Fields{"status": job.status, "exitCode": 0}).Warn("Job Finished")
 		}
 	}
 }
@@ -147,7 +173,7 @@ func (job *ObjectStoreUploadJob) GetResult() *JobResult {
 		Start:    &job.Start,
 		End:      &job.End,
 		ExitCode: job.status.GetDefaultExitCode(),
-		Message:  "",
+		Message:  job.message(),
 	}
 }
 func (p *ObjectStoreUploadJob) GetId() string {
@@ -156,10 +182,31 @@ func (p *ObjectStoreUploadJob) GetId() string {
 func (p *ObjectStoreUploadJob) GetStatus() JobStatus {
 	return p.status
 }
+func (p *ObjectStoreUploadJob) message() string {
+	if p.err != nil {
+		return p.err.Error()
+	}
+	return ""
+}
+
+// notify tells Execute that the upload is finished.
+// It never blocks, because only the first notification is received.
+func (p *ObjectStoreUploadJob) notify(status JobStatus) {
+	select {
+	case p.closeCh <- status:
+	default:
+	}
+}
 func (p *ObjectStoreUploadJob) Abort() {
 	p.status = Aborted
-	if p.closeCh != nil {
-		p.closeCh <- Aborted
+	p.notify(Aborted)
+}
+
+// Skip marks the job as skipped unless it has been started.
+func (p *ObjectStoreUploadJob) Skip() {
+	if p.status == Created {
+		p.status = Skipped
+		p.notify(Skipped)
 	}
 }
 func (p *ObjectStoreUploadJob) Key() string {
@@ -182,27 +229,35 @@ func (p *ObjectStoreUploadJob) UnBlock() {
 
 }
 func (p *ObjectStoreUploadJob) GetWriter() (io.WriteCloser, error) {
-	if session_1 == nil {
-		return nil, fmt.Errorf("s3 session is not initialized")
+	<-p.started
+	if p.status == Skipped {
+		return nil, errSkipped
+	}
+	if p.status.IsFinished() {
+		return nil, fmt.Errorf("Job %s has already finished", p.jobId)
+	}
+	backend := p.store
+	if backend == nil {
+		var err error
+		if backend, err = p.workflow.objectStoreBackend(p.storeName); err != nil {
+			return nil, err
+		}
 	}
-	client := s3.New(session_1)
-	input := s3.CreateMultipartUploadInput{
-		Bucket:      aws.String(p.Bucket),
-		Key:         aws.String(p.key),
-		ContentType: aws.String("application/octet-stream"),
+	sse, err := p.encryption.sseParams()
+	if err != nil {
+		return nil, err
+	}
+	kek, err := p.encryption.encryptionKey()
+	if err != nil {
+		return nil, err
 	}
-	output, err := client.CreateMultipartUpload(&input)
+	writer, err := backend.Create(p.workflow.jobContext(p.jobId), p.Bucket, p.key, p.properties(sse, kek != nil))
 	if err != nil {
 		return nil, err
 	}
-	uploader := ObjectStoreUploader{
-		job:        p,
-		client:     client,
-		output:     output,
-		buff:       make([]byte, 10*1024*1024),
-		len:        0,
-		partNumber: 1,
+	uploader := &objectStoreUploadWriter{job: p, writer: writer}
+	if kek != nil {
+		return newEncryptingWriter(uploader, kek)
 	}
-	p.uploader = &uploader
-	return &uploader, nil
+	return uploader, nil
 }
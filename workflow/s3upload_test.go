@@ -0,0 +1,39 @@
+package workflow
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectContentType(t *testing.T) {
+	assert.Equal(t, "application/gzip", detectContentType("runs/sample_R1.fastq.gz"))
+	assert.Equal(t, "text/plain", detectContentType("sample.FASTQ"))
+	assert.Equal(t, "application/json", detectContentType("results.json"))
+	assert.Equal(t, "application/octet-stream", detectContentType("sample.unknownext"))
+}
+
+func TestUploadProperties(t *testing.T) {
+	wf := &Workflow{Name: "align", RunId: "run1"}
+	job := CreateObjectStoreJob(wf, &JobDto{
+		JobId:        "upload",
+		ReadFrom:     "BAM",
+		Bucket:       "bucket",
+		Key:          "sample.bam",
+		StorageClass: "STANDARD_IA",
+		Metadata:     map[string]string{"sample": "S1"},
+		Tags:         map[string]string{"project": "p 1", "access": "controlled"},
+	}).(*ObjectStoreUploadJob)
+	input := createMultipartUploadInput(job.Bucket, job.key, job.properties(&sseParams{}, false))
+	assert.Equal(t, "application/octet-stream", aws.StringValue(input.ContentType))
+	assert.Equal(t, "STANDARD_IA", aws.StringValue(input.StorageClass))
+	assert.Nil(t, input.ACL)
+	assert.Equal(t, "access=controlled&project=p+1", aws.StringValue(input.Tagging))
+	assert.Equal(t, map[string]string{
+		"sample":         "S1",
+		"flowy-workflow": "align",
+		"flowy-run-id":   "run1",
+		"flowy-job-id":   "upload",
+	}, aws.StringValueMap(input.Metadata))
+}
@@ -0,0 +1,226 @@
+package workflow
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+)
+
+const (
+	FormatLines = "lines"
+	FormatFastq = "fastq"
+	FormatBgzf  = "bgzf"
+)
+
+// bgzfEOF is the empty BGZF block which terminates a BGZF file.
+var bgzfEOF = []byte{
+	0x1f, 0x8b, 0x08, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0xff, 0x06, 0x00, 0x42, 0x43,
+	0x02, 0x00, 0x1b, 0x00, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+}
+
+// SplitJob distributes the records read from one stream to several streams.
+// Records are written to the outputs in turn, chunkRecords records
+// or at least chunkBytes bytes at a time, and are never split.
+type SplitJob struct {
+	streamJob
+	format       string
+	chunkRecords int
+	chunkBytes   int
+}
+
+func CreateSplitJob(jobDto *JobDto) Job {
+	job := &SplitJob{
+		streamJob:    streamJob{jobId: jobDto.JobId, status: Created},
+		format:       jobDto.Format,
+		chunkRecords: jobDto.ChunkRecords,
+		chunkBytes:   jobDto.ChunkBytes,
+	}
+	if job.format == "" {
+		job.format = FormatLines
+	}
+	if job.chunkRecords <= 0 && job.chunkBytes <= 0 {
+		job.chunkRecords = 1
+	}
+	job.addInput(jobDto.ReadFrom)
+	for _, output := range jobDto.Outputs {
+		job.addOutput(output.WriteTo)
+	}
+	return job
+}
+
+func (job *SplitJob) Execute(wf *Workflow, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer wf.UnBlock()
+	if !job.begin() {
+		return
+	}
+	job.finish(job.split())
+}
+
+func (job *SplitJob) split() error {
+	records, err := newRecordReader(job.format, job.inputs[0].reader)
+	if err != nil {
+		return err
+	}
+	writers := make([]*bufio.Writer, len(job.outputs))
+	for idx, output := range job.outputs {
+		writers[idx] = bufio.NewWriterSize(output.writer, 64*1024)
+	}
+	current := 0
+	records_in_chunk := 0
+	bytes_in_chunk := 0
+	for {
+		record, err := records.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := writers[current].Write(record); err != nil {
+			return err
+		}
+		records_in_chunk++
+		bytes_in_chunk += len(record)
+		if (job.chunkRecords > 0 && records_in_chunk >= job.chunkRecords) ||
+			(job.chunkBytes > 0 && bytes_in_chunk >= job.chunkBytes) {
+			current = (current + 1) % len(writers)
+			records_in_chunk = 0
+			bytes_in_chunk = 0
+		}
+	}
+	for _, writer := range writers {
+		if job.format == FormatBgzf {
+			if _, err := writer.Write(bgzfEOF); err != nil {
+				return err
+			}
+		}
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordReader reads a stream record by record.
+// The slice returned by Next is valid until the next call of Next.
+type recordReader interface {
+	Next() ([]byte, error)
+}
+
+func newRecordReader(format string, r io.Reader) (recordReader, error) {
+	reader := bufio.NewReaderSize(r, 64*1024)
+	switch format {
+	case FormatLines:
+		return &lineReader{reader: reader}, nil
+	case FormatFastq:
+		return &fastqReader{lines: lineReader{reader: reader}}, nil
+	case FormatBgzf:
+		return &bgzfReader{reader: reader}, nil
+	}
+	return nil, fmt.Errorf("unsupported format %s", format)
+}
+
+type lineReader struct {
+	reader *bufio.Reader
+	line   []byte
+}
+
+func (r *lineReader) Next() ([]byte, error) {
+	r.line = r.line[:0]
+	for {
+		data, err := r.reader.ReadSlice('\n')
+		r.line = append(r.line, data...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF && len(r.line) > 0 {
+			return r.line, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return r.line, nil
+	}
+}
+
+// fastqReader reads FASTQ records, which consist of four lines.
+type fastqReader struct {
+	lines  lineReader
+	record []byte
+	count  int
+}
+
+func (r *fastqReader) Next() ([]byte, error) {
+	r.record = r.record[:0]
+	for i := 0; i < 4; i++ {
+		line, err := r.lines.Next()
+		if err == io.EOF && i > 0 {
+			return nil, fmt.Errorf("FASTQ record %d is truncated", r.count+1)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if (i == 0 && line[0] != '@') || (i == 2 && line[0] != '+') {
+			return nil, fmt.Errorf("FASTQ record %d is malformed", r.count+1)
+		}
+		r.record = append(r.record, line...)
+	}
+	r.count++
+	return r.record, nil
+}
+
+// bgzfReader reads BGZF blocks without decompressing them.
+// The empty EOF blocks are skipped.
+type bgzfReader struct {
+	reader *bufio.Reader
+	header [12]byte
+	block  []byte
+}
+
+func (r *bgzfReader) Next() ([]byte, error) {
+	for {
+		header := r.header[:]
+		if _, err := io.ReadFull(r.reader, header); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return nil, fmt.Errorf("BGZF block header is truncated")
+			}
+			return nil, err
+		}
+		if header[0] != 0x1f || header[1] != 0x8b || header[2] != 8 || header[3]&4 == 0 {
+			return nil, fmt.Errorf("stream is not BGZF")
+		}
+		xlen := int(binary.LittleEndian.Uint16(header[10:12]))
+		headerSize := len(header) + xlen
+		r.block = append(r.block[:0], header...)
+		r.block = append(r.block, make([]byte, xlen)...)
+		if _, err := io.ReadFull(r.reader, r.block[len(header):]); err != nil {
+			return nil, fmt.Errorf("BGZF block header is truncated")
+		}
+		bsize := -1
+		for extra := r.block[len(header):]; len(extra) >= 4; {
+			slen := int(binary.LittleEndian.Uint16(extra[2:4]))
+			if extra[0] == 'B' && extra[1] == 'C' && slen == 2 && len(extra) >= 6 {
+				bsize = int(binary.LittleEndian.Uint16(extra[4:6]))
+			}
+			if len(extra) < 4+slen {
+				break
+			}
+			extra = extra[4+slen:]
+		}
+		if bsize+1 < headerSize {
+			return nil, fmt.Errorf("BGZF block size is missing or invalid")
+		}
+		r.block = append(r.block, make([]byte, bsize+1-headerSize)...)
+		if _, err := io.ReadFull(r.reader, r.block[headerSize:]); err != nil {
+			return nil, fmt.Errorf("BGZF block is truncated")
+		}
+		if bytes.Equal(r.block, bgzfEOF) {
+			continue
+		}
+		return r.block, nil
+	}
+}
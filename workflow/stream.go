@@ -0,0 +1,240 @@
+package workflow
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var errAborted = errors.New("job is aborted")
+
+// errSkipped is returned by the streams of skipped jobs.
+var errSkipped = errors.New("job is skipped")
+
+// streamJob holds the state shared by jobs which process streams inside
+// flowyexec instead of running an external process.
+// The streams are connected to PipeHandlers through in-process pipes.
+type streamJob struct {
+	jobId   string
+	mu      sync.Mutex
+	status  JobStatus
+	message string
+	inputs  []*pipeInput
+	outputs []*pipeOutput
+	// onAbort, if set, is called when the job is aborted
+	// to interrupt the work not blocked on the pipes.
+	onAbort func()
+	Start   time.Time
+	End     time.Time
+	// owner is the workflow running the job.
+	owner *Workflow
+}
+
+func (job *streamJob) setOwner(w *Workflow) {
+	job.owner = w
+}
+
+// log returns the entry of the log lines of the job.
+func (job *streamJob) log() *logrus.Entry {
+	return job.owner.jobLog(job.jobId)
+}
+
+// pipeInput is an Input whose data is read by the job itself.
+type pipeInput struct {
+	job    *streamJob
+	key    string
+	reader *io.PipeReader
+	writer *io.PipeWriter
+}
+
+// pipeOutput is an Output whose data is written by the job itself.
+type pipeOutput struct {
+	job    *streamJob
+	key    string
+	reader *io.PipeReader
+	writer *io.PipeWriter
+}
+
+func (job *streamJob) addInput(key string) *pipeInput {
+	r, w := io.Pipe()
+	input := &pipeInput{job: job, key: key, reader: r, writer: w}
+	job.inputs = append(job.inputs, input)
+	return input
+}
+
+func (job *streamJob) addOutput(key string) *pipeOutput {
+	r, w := io.Pipe()
+	output := &pipeOutput{job: job, key: key, reader: r, writer: w}
+	job.outputs = append(job.outputs, output)
+	return output
+}
+
+func (job *streamJob) GetId() string {
+	return job.jobId
+}
+
+func (job *streamJob) GetStatus() JobStatus {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	return job.status
+}
+
+func (job *streamJob) GetInputs() []Input {
+	inputs := make([]Input, 0, len(job.inputs))
+	for _, input := range job.inputs {
+		inputs = append(inputs, input)
+	}
+	return inputs
+}
+
+func (job *streamJob) GetOutputs() []Output {
+	outputs := make([]Output, 0, len(job.outputs))
+	for _, output := range job.outputs {
+		outputs = append(outputs, output)
+	}
+	return outputs
+}
+
+func (job *streamJob) GetResult() *JobResult {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	// the times are copied as the job may be running
+	start, end := job.Start, job.End
+	return &JobResult{
+		JobId:    job.jobId,
+		Status:   job.status,
+		Start:    &start,
+		End:      &end,
+		ExitCode: job.status.GetDefaultExitCode(),
+		Message:  job.message,
+	}
+}
+
+// Abort marks the job as aborted unless it has failed by itself,
+// and breaks all of its pipes so that the job and the PipeHandlers
+// blocked on them return.
+func (job *streamJob) Abort() {
+	job.mu.Lock()
+	if job.status != Failed {
+		job.status = Aborted
+	}
+	job.mu.Unlock()
+	if job.onAbort != nil {
+		job.onAbort()
+	}
+	job.closePipes(errAborted)
+}
+
+// Skip marks the job as skipped unless it has been started,
+// and breaks all of its pipes with errSkipped.
+func (job *streamJob) Skip() {
+	job.mu.Lock()
+	if job.status != Created {
+		job.mu.Unlock()
+		return
+	}
+	job.status = Skipped
+	job.mu.Unlock()
+	job.closePipes(errSkipped)
+}
+
+func (job *streamJob) closePipes(err error) {
+	for _, input := range job.inputs {
+		input.reader.CloseWithError(err)
+	}
+	for _, output := range job.outputs {
+		output.writer.CloseWithError(err)
+	}
+}
+
+// begin marks the job as running.
+// It returns false if the job has been aborted before it is started.
+func (job *streamJob) begin() bool {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	job.Start = time.Now()
+	if job.status.IsFinished() {
+		job.End = job.Start
+		if job.status == Skipped {
+			job.log().Info("Job Skipped")
+		}
+		return false
+	}
+	job.status = Running
+	job.log().Info("Start Job")
+	return true
+}
+
+// finish closes the pipes of the job and records the result of the job.
+// A nil err means that all outputs are completely written.
+func (job *streamJob) finish(err error) {
+	job.closePipes(err)
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	job.End = time.Now()
+	if job.status == Aborted {
+		job.log().WithFields(logrus.Fields{"status": job.status, "exitCode": -1}).Warn("Job Aborted")
+		return
+	}
+	if err != nil {
+		job.status = Failed
+		job.message = err.Error()
+		job.log().WithFields(logrus.Fields{"status": job.status, "exitCode": -1}).WithError(err).Warn("Job Failed")
+		return
+	}
+	job.status = Successed
+	job.log().WithFields(logrus.Fields{"status": job.status, "exitCode": 0}).Info("Finished Job")
+}
+
+func (s *pipeInput) GetWriter() (io.WriteCloser, error) {
+	if s.job.GetStatus() == Skipped {
+		return nil, errSkipped
+	}
+	if s.job.GetStatus().IsFinished() {
+		return nil, fmt.Errorf("Job %s has already finished", s.job.jobId)
+	}
+	return s.writer, nil
+}
+func (s *pipeInput) Abort() {
+	s.job.Abort()
+}
+func (s *pipeInput) Clear() {
+}
+func (s *pipeInput) Key() string {
+	return s.key
+}
+func (s *pipeInput) Label() string {
+	return s.job.jobId
+}
+func (s *pipeInput) UnBlock() {
+}
+
+func (s *pipeOutput) GetReader() (io.ReadCloser, error) {
+	if s.job.GetStatus() == Skipped {
+		return nil, errSkipped
+	}
+	if s.job.GetStatus().IsFinished() {
+		return nil, fmt.Errorf("Job %s has already finished", s.job.jobId)
+	}
+	return s.reader, nil
+}
+func (s *pipeOutput) IsFailed() bool {
+	return s.job.GetStatus().IsFailed()
+}
+func (s *pipeOutput) Abort() {
+	s.job.Abort()
+}
+func (s *pipeOutput) Clear() {
+}
+func (s *pipeOutput) Key() string {
+	return s.key
+}
+func (s *pipeOutput) Label() string {
+	return s.job.jobId
+}
+func (s *pipeOutput) UnBlock() {
+}
@@ -0,0 +1,272 @@
+package workflow
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// SubWorkflowJob runs another workflow as a single job. The inputs and the
+// outputs of the job are connected to the streams the workflow declares
+// as its inputs and outputs. The batch jobs of the workflow run in the
+// directory named after the job, so that the FIFOs of the workflows used
+// by several jobs do not collide.
+type SubWorkflowJob struct {
+	streamJob
+	path     string
+	workflow *Workflow
+	// bridges are the ids of the jobs copying the streams
+	// between the job and the workflow.
+	bridges map[string]bool
+	results []*JobResult
+}
+
+// streamBridgeJob copies a stream between a SubWorkflowJob and its workflow.
+// dst is closed when the job finishes, is skipped or is aborted,
+// so that the reader of dst does not wait for the job.
+type streamBridgeJob struct {
+	streamJob
+	src io.Reader
+	dst *io.PipeWriter
+}
+
+func newStreamBridgeJob(jobId string) *streamBridgeJob {
+	job := &streamBridgeJob{streamJob: streamJob{jobId: jobId, status: Created}}
+	job.onAbort = func() {
+		job.dst.CloseWithError(errAborted)
+	}
+	return job
+}
+
+func inputBridgeId(name string) string {
+	return "<input " + name + ">"
+}
+
+func outputBridgeId(name string) string {
+	return "<output " + name + ">"
+}
+
+// loadSubWorkflow loads the workflow of a job of type Workflow, relative to dir.
+// loading are the files of the workflows including the workflow.
+func (jobDto *JobDto) loadSubWorkflow(dir string, loading []string) error {
+	path := jobDto.Path
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	for _, including := range loading {
+		if including == abs {
+			return fmt.Errorf("workflow %s includes itself", path)
+		}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("job %s: %w", jobDto.JobId, err)
+	}
+	defer f.Close()
+	workflow, err := loadWorkflowDto(f, path, jobDto.Parameters, append(loading, abs))
+	if err != nil {
+		return fmt.Errorf("workflow %s of job %s: %w", path, jobDto.JobId, err)
+	}
+	inputs := map[string]bool{}
+	for _, input := range jobDto.Inputs {
+		if !contains(workflow.Inputs, input.Name) {
+			return fmt.Errorf("job %s has input %s not declared by workflow %s", jobDto.JobId, input.Name, path)
+		}
+		inputs[input.Name] = true
+	}
+	for _, name := range workflow.Inputs {
+		if !inputs[name] {
+			return fmt.Errorf("job %s does not connect input %s of workflow %s", jobDto.JobId, name, path)
+		}
+	}
+	outputs := map[string]bool{}
+	for _, output := range jobDto.Outputs {
+		if !contains(workflow.Outputs, output.Name) {
+			return fmt.Errorf("job %s has output %s not declared by workflow %s", jobDto.JobId, output.Name, path)
+		}
+		outputs[output.Name] = true
+	}
+	for _, name := range workflow.Outputs {
+		if !outputs[name] {
+			return fmt.Errorf("job %s does not connect output %s of workflow %s", jobDto.JobId, name, path)
+		}
+	}
+	if len(workflow.Agents) > 0 {
+		return fmt.Errorf("workflow %s of job %s cannot place jobs on agents", path, jobDto.JobId)
+	}
+	jobDto.workflow = workflow
+	return nil
+}
+
+// validateStreams checks the inputs and the outputs declared by a workflow.
+// The inputs are written by the job using the workflow and the outputs
+// are read by it, so that the jobs connected to them cannot be retried.
+func (dto *WorkflowDto) validateStreams() error {
+	jobComponents, keyComponents := pipeComponents(dto.Jobs)
+	connected := map[int]bool{}
+	for _, name := range dto.Inputs {
+		for _, job := range dto.Jobs {
+			if contains(job.outputKeys(), name) {
+				return fmt.Errorf("input %s of the workflow is written by job %s", name, job.JobId)
+			}
+		}
+		if component, ok := keyComponents[name]; ok {
+			connected[component] = true
+		}
+	}
+	for _, name := range dto.Outputs {
+		component, ok := -1, false
+		for _, job := range dto.Jobs {
+			if contains(job.outputKeys(), name) {
+				component, ok = jobComponents[job.JobId], true
+			}
+		}
+		if !ok {
+			return fmt.Errorf("output %s of the workflow is not written by any job", name)
+		}
+		connected[component] = true
+	}
+	for _, job := range dto.Jobs {
+		if isReservedJobId(job.JobId) {
+			return fmt.Errorf("job id %s is reserved", job.JobId)
+		}
+		if job.Retries > 0 && connected[jobComponents[job.JobId]] {
+			return fmt.Errorf("job %s cannot be retried because it is connected to the inputs or outputs of the workflow", job.JobId)
+		}
+	}
+	return nil
+}
+
+// createSubWorkflowJob creates a job running the workflow of jobDto
+// in a directory under the directory of w.
+func (w *Workflow) createSubWorkflowJob(jobDto *JobDto) Job {
+	job := &SubWorkflowJob{
+		streamJob: streamJob{jobId: jobDto.JobId, status: Created},
+		path:      jobDto.Path,
+		bridges:   map[string]bool{},
+	}
+	for _, input := range jobDto.Inputs {
+		job.addInput(input.ReadFrom)
+	}
+	for _, output := range jobDto.Outputs {
+		job.addOutput(output.WriteTo)
+	}
+	if jobDto.workflow == nil {
+		// the workflow is loaded by LoadWorkflow
+		return job
+	}
+	dto := *jobDto.workflow
+	if dto.Objectstore == nil {
+		dto.Objectstore = w.Objectstore
+	}
+	stores := map[string]*ObjectStore{}
+	for name, store := range w.Objectstores {
+		stores[name] = store
+	}
+	for name, store := range dto.Objectstores {
+		stores[name] = store
+	}
+	dto.Objectstores = stores
+	dto.Jobs = nil
+	// the bridges of the inputs precede the jobs reading them
+	// and the bridges of the outputs follow the jobs writing them
+	bridges := map[string]Job{}
+	for idx, input := range jobDto.Inputs {
+		bridge := newStreamBridgeJob(inputBridgeId(input.Name))
+		bridge.src = job.inputs[idx].reader
+		bridge.dst = bridge.addOutput(input.Name).writer
+		bridges[bridge.jobId] = bridge
+		dto.Jobs = append(dto.Jobs, &JobDto{JobId: bridge.jobId, WriteTo: input.Name})
+	}
+	dto.Jobs = append(dto.Jobs, jobDto.workflow.Jobs...)
+	for idx, output := range jobDto.Outputs {
+		bridge := newStreamBridgeJob(outputBridgeId(output.Name))
+		bridge.src = bridge.addInput(output.Name).reader
+		bridge.dst = job.outputs[idx].writer
+		bridges[bridge.jobId] = bridge
+		dto.Jobs = append(dto.Jobs, &JobDto{JobId: bridge.jobId, ReadFrom: output.Name})
+	}
+	for jobId := range bridges {
+		job.bridges[jobId] = true
+	}
+	job.workflow = createWorkflow(&dto, filepath.Join(w.dir, jobDto.JobId), bridges)
+	job.onAbort = job.workflow.abort
+	return job
+}
+
+func (job *SubWorkflowJob) Execute(wf *Workflow, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer wf.UnBlock()
+	if !job.begin() {
+		return
+	}
+	job.finish(job.run(wf))
+}
+
+func (job *SubWorkflowJob) run(wf *Workflow) error {
+	if job.workflow == nil {
+		return fmt.Errorf("workflow %s is not loaded", job.path)
+	}
+	job.workflow.parent = wf.jobContext(job.jobId)
+	// the jobs of the workflow are logged as part of the run
+	job.workflow.RunId = wf.RunId
+	job.workflow.Logger = wf.Logger
+	job.workflow.JobLogs = wf.JobLogs
+	if err := os.MkdirAll(job.workflow.dir, 0755); err != nil {
+		return err
+	}
+	// the directory is left if the jobs have written files in it
+	defer os.Remove(job.workflow.dir)
+	events := make(chan Event, 1)
+	result := job.workflow.Execute(events)
+	if event, ok := (<-events).(*WorkflowEvent); ok && event.ExecError != nil {
+		return event.ExecError
+	}
+	results := make([]*JobResult, 0, len(result.Results))
+	for _, r := range result.Results {
+		if !job.bridges[r.JobId] {
+			results = append(results, r)
+		}
+	}
+	job.mu.Lock()
+	job.results = results
+	job.mu.Unlock()
+	if result.Status != Successed {
+		return fmt.Errorf("workflow %s has failed", job.path)
+	}
+	return nil
+}
+
+// GetResult returns the result of the job with the results of the jobs of its workflow.
+func (job *SubWorkflowJob) GetResult() *JobResult {
+	result := job.streamJob.GetResult()
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	result.Jobs = job.results
+	return result
+}
+
+func (job *streamBridgeJob) Execute(wf *Workflow, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer wf.UnBlock()
+	if !job.begin() {
+		return
+	}
+	_, err := io.Copy(job.dst, job.src)
+	job.dst.CloseWithError(err)
+	job.finish(err)
+}
+
+// Skip skips the job and the reader of dst.
+func (job *streamBridgeJob) Skip() {
+	job.streamJob.Skip()
+	if job.GetStatus() == Skipped {
+		job.dst.CloseWithError(errSkipped)
+	}
+}
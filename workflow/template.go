@@ -0,0 +1,190 @@
+package workflow
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// A job template is a job definition shared by jobs, whose strings
+// have parameter slots written as ${name}. The parameters of a template
+// and their default values are listed in its parameters, where a null
+// value means that the parameter is required. A job using a template has
+// the template and the values of the parameters, and may override the
+// other fields of the template. The parameters of a job using a template
+// are the parameters of the template, not those of a sub-workflow.
+//
+//	"templates": {
+//	    "fastqc": {
+//	        "parameters": {"reads": null, "threads": 4},
+//	        "command": ["fastqc", "--threads", "${threads}", "${reads}"]
+//	    }
+//	},
+//	"jobs": [
+//	    {"jobId": "qc", "template": "fastqc", "parameters": {"reads": "sample.fastq"}}
+//	]
+
+var parameterSlot = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// templateFile is a file of templates listed in the templateFiles of a workflow.
+type templateFile struct {
+	Templates map[string]map[string]interface{}
+}
+
+// loadTemplates returns the templates of the workflow and of its template
+// files relative to dir. The templates of the workflow override the others.
+func (dto *WorkflowDto) loadTemplates(dir string) (map[string]map[string]interface{}, error) {
+	templates := map[string]map[string]interface{}{}
+	for _, path := range dto.TemplateFiles {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var file templateFile
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("template file %s: %w", path, err)
+		}
+		for name, template := range file.Templates {
+			templates[name] = template
+		}
+	}
+	for name, template := range dto.Templates {
+		templates[name] = template
+	}
+	return templates, nil
+}
+
+// expandJob creates a job from its definition in a workflow file, filling
+// the parameter slots with the parameters of the workflow, or with the
+// parameters of the template and the job if the job uses a template.
+// The slots whose names are not parameters are left as they are,
+// so that the variables of shell commands are kept.
+func expandJob(raw map[string]interface{}, templates map[string]map[string]interface{}, params map[string]interface{}) (*JobDto, error) {
+	fields := lowerKeys(raw)
+	jobId, _ := fields["jobid"].(string)
+	if name, ok := fields["template"]; ok {
+		templateName, _ := name.(string)
+		template, ok := templates[templateName]
+		if !ok {
+			return nil, fmt.Errorf("template %v of job %s is not defined", name, jobId)
+		}
+		templateFields := lowerKeys(template)
+		defaults, _ := templateFields["parameters"].(map[string]interface{})
+		// the values of the parameters may have the slots of the workflow parameters
+		values, _ := substitute(fields["parameters"], params, nil).(map[string]interface{})
+		scope := map[string]interface{}{}
+		for name, value := range params {
+			scope[name] = value
+		}
+		for name, value := range defaults {
+			scope[name] = value
+		}
+		for _, name := range sortedKeys(values) {
+			if _, ok := defaults[name]; !ok {
+				return nil, fmt.Errorf("template %s of job %s has no parameter %s", templateName, jobId, name)
+			}
+			scope[name] = values[name]
+		}
+		for _, name := range sortedKeys(defaults) {
+			if scope[name] == nil {
+				return nil, fmt.Errorf("job %s requires parameter %s of template %s", jobId, name, templateName)
+			}
+		}
+		delete(templateFields, "parameters")
+		delete(fields, "parameters")
+		for name, value := range fields {
+			templateFields[name] = value
+		}
+		fields = templateFields
+		params = scope
+	}
+	data, err := json.Marshal(substitute(fields, params, reflect.TypeOf(JobDto{})))
+	if err != nil {
+		return nil, err
+	}
+	var job JobDto
+	if err := json.Unmarshal(data, &job); err != nil {
+		return nil, fmt.Errorf("job %s: %w", jobId, err)
+	}
+	return &job, nil
+}
+
+// substitute fills the parameter slots in the strings of value, which is
+// decoded to typ. A string which is just a slot is replaced with the value
+// of the parameter to keep its type, unless it is decoded to a string.
+func substitute(value interface{}, params map[string]interface{}, typ reflect.Type) interface{} {
+	for typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	switch v := value.(type) {
+	case string:
+		if match := parameterSlot.FindStringSubmatch(v); match != nil && match[0] == v {
+			if param, ok := params[match[1]]; ok && (typ == nil || typ.Kind() != reflect.String) {
+				return param
+			}
+		}
+		return parameterSlot.ReplaceAllStringFunc(v, func(slot string) string {
+			param, ok := params[slot[2:len(slot)-1]]
+			if !ok {
+				return slot
+			}
+			if s, ok := param.(string); ok {
+				return s
+			}
+			return fmt.Sprint(param)
+		})
+	case []interface{}:
+		var elem reflect.Type
+		if typ != nil && typ.Kind() == reflect.Slice {
+			elem = typ.Elem()
+		}
+		values := make([]interface{}, len(v))
+		for idx, item := range v {
+			values[idx] = substitute(item, params, elem)
+		}
+		return values
+	case map[string]interface{}:
+		values := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			var field reflect.Type
+			if typ != nil && typ.Kind() == reflect.Map {
+				field = typ.Elem()
+			} else if typ != nil && typ.Kind() == reflect.Struct {
+				// fields are matched regardless of case as they are decoded
+				if f, ok := typ.FieldByNameFunc(func(name string) bool { return strings.EqualFold(name, key) }); ok {
+					field = f.Type
+				}
+			}
+			values[key] = substitute(item, params, field)
+		}
+		return values
+	}
+	return value
+}
+
+// lowerKeys copies the fields of a JSON object with lower-case names,
+// so that the fields are overridden regardless of case as they are decoded.
+func lowerKeys(fields map[string]interface{}) map[string]interface{} {
+	lowered := make(map[string]interface{}, len(fields))
+	for name, value := range fields {
+		lowered[strings.ToLower(name)] = value
+	}
+	return lowered
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,88 @@
+package workflow
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandJob(t *testing.T) {
+	templates := map[string]map[string]interface{}{
+		"bwa": {
+			"parameters": map[string]interface{}{"reads": nil, "threads": 4.0},
+			"command":    []interface{}{"bwa", "mem", "-t", "${threads}", "${reads}", "$HOME", "${HOME}"},
+			"retries":    "${threads}",
+		},
+	}
+	job, err := expandJob(map[string]interface{}{
+		"jobId":      "align",
+		"template":   "bwa",
+		"parameters": map[string]interface{}{"reads": "${sample}.fastq"},
+		"retries":    1.0,
+	}, templates, map[string]interface{}{"sample": "s1"})
+	assert.NoError(t, err)
+	assert.Equal(t, "align", job.JobId)
+	assert.Equal(t, "bwa", job.Template)
+	assert.Equal(t, []string{"bwa", "mem", "-t", "4", "s1.fastq", "$HOME", "${HOME}"}, job.Command)
+	assert.Equal(t, 1, job.Retries)
+
+	job, err = expandJob(map[string]interface{}{
+		"jobId":   "plain",
+		"command": []interface{}{"echo", "${sample}", "${other}"},
+	}, templates, map[string]interface{}{"sample": "s1"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"echo", "s1", "${other}"}, job.Command)
+
+	_, err = expandJob(map[string]interface{}{"jobId": "a", "template": "bwa"}, templates, nil)
+	assert.EqualError(t, err, "job a requires parameter reads of template bwa")
+	_, err = expandJob(map[string]interface{}{"jobId": "a", "template": "bwa", "parameters": map[string]interface{}{"reads": "x", "index": "y"}}, templates, nil)
+	assert.EqualError(t, err, "template bwa of job a has no parameter index")
+	_, err = expandJob(map[string]interface{}{"jobId": "a", "template": "star"}, templates, nil)
+	assert.EqualError(t, err, "template star of job a is not defined")
+}
+
+func TestInvalidSubWorkflow(t *testing.T) {
+	tests := []struct {
+		name     string
+		workflow string
+		err      string
+	}{
+		{
+			name:     "undeclared input",
+			workflow: `{"jobs": [{"jobId": "a", "type": "Workflow", "path": "../testdata/subworkflows/count.json", "inputs": [{"name": "FASTQ", "readFrom": "K"}]}]}`,
+			err:      "job a has input FASTQ not declared by workflow ../testdata/subworkflows/count.json",
+		},
+		{
+			name:     "unconnected output",
+			workflow: `{"jobs": [{"jobId": "a", "type": "Workflow", "path": "../testdata/subworkflows/count.json", "inputs": [{"name": "READS", "readFrom": "K"}]}]}`,
+			err:      "job a does not connect output REPORT of workflow ../testdata/subworkflows/count.json",
+		},
+		{
+			name:     "undeclared parameter",
+			workflow: `{"jobs": [{"jobId": "a", "type": "Workflow", "path": "../testdata/subworkflows/count.json", "parameters": {"threads": 4}}]}`,
+			err:      "workflow ../testdata/subworkflows/count.json of job a: workflow has no parameter threads",
+		},
+		{
+			name:     "cycle",
+			workflow: `{"jobs": [{"jobId": "a", "type": "Workflow", "path": "../testdata/subworkflows/self.json"}]}`,
+			err:      "workflow ../testdata/subworkflows/self.json of job a: workflow ../testdata/subworkflows/self.json includes itself",
+		},
+		{
+			name:     "input written by job",
+			workflow: `{"inputs": ["K"], "jobs": [{"jobId": "a", "type": "Literal", "writeTo": "K"}]}`,
+			err:      "input K of the workflow is written by job a",
+		},
+		{
+			name:     "output not written",
+			workflow: `{"outputs": ["K"], "jobs": []}`,
+			err:      "output K of the workflow is not written by any job",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := LoadWorkflow(strings.NewReader(test.workflow))
+			assert.EqualError(t, err, test.err)
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package workflow
+
+import (
+	"context"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.25.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The workflows are traced by the global tracer provider of OpenTelemetry,
+// which StartTracing sets. Without it the spans are not recorded.
+const tracerName = "github.com/bioflowy/flowy-exec/workflow"
+
+// traceContext propagates the trace of a workflow to the agents
+// and the commands of the batch jobs.
+var traceContext = propagation.TraceContext{}
+
+// StartTracing exports the traces of the workflows to the OTLP collector at
+// endpoint, a URL such as http://localhost:4317, over gRPC. The returned function
+// flushes the spans which are not exported yet and stops exporting.
+func StartTracing(ctx context.Context, endpoint string) (func(context.Context) error, error) {
+	exporter, err := otlptracegrpc.New(ctx, otlptracegrpc.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName("flowyexec")))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// endSpan records the status of a job, a pipe or a workflow and ends its span.
+func endSpan(span trace.Span, status JobStatus, message string) {
+	span.SetAttributes(attribute.String("flowyexec.status", status.String()))
+	if status.IsFailed() {
+		span.SetStatus(codes.Error, message)
+	}
+	span.End()
+}
+
+// jobContext returns the context of the span of a job,
+// or the context of the workflow if the job has not started.
+func (w *Workflow) jobContext(jobId string) context.Context {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if ctx, ok := w.jobContexts[jobId]; ok {
+		return ctx
+	}
+	if w.ctx != nil {
+		return w.ctx
+	}
+	return context.Background()
+}
+
+// startJobSpan starts the span of an attempt of a job.
+func (w *Workflow) startJobSpan(run *jobRun) {
+	ctx, span := tracer().Start(w.ctx, "job "+run.job.GetId(), trace.WithAttributes(
+		attribute.String("flowyexec.job_id", run.job.GetId()),
+		attribute.Int("flowyexec.attempt", run.group.attempt),
+	))
+	run.span = span
+	w.mu.Lock()
+	w.jobContexts[run.job.GetId()] = ctx
+	w.mu.Unlock()
+}
+
+// injectTrace returns the carrier propagating the trace of ctx.
+func injectTrace(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	traceContext.Inject(ctx, carrier)
+	return carrier
+}
+
+// traceEnv returns the environment variables propagating the trace of ctx
+// to a command, TRACEPARENT and TRACESTATE.
+func traceEnv(ctx context.Context) []string {
+	env := []string{}
+	for key, value := range injectTrace(ctx) {
+		env = append(env, strings.ToUpper(key)+"="+value)
+	}
+	return env
+}
+
+// s3SpanKey is the key of the span of an S3 request in its context.
+type s3SpanKey struct{}
+
+// startS3Span starts the span of an S3 request when it is built.
+// The span is ended by endS3Span when the request has completed.
+func startS3Span(r *request.Request) {
+	ctx, span := tracer().Start(r.Context(), "s3 "+r.Operation.Name, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("rpc.system", "aws-api"), attribute.String("rpc.method", r.Operation.Name)))
+	r.SetContext(context.WithValue(ctx, s3SpanKey{}, span))
+}
+
+// endS3Span ends the span of an S3 request.
+func endS3Span(r *request.Request) {
+	span, ok := r.Context().Value(s3SpanKey{}).(trace.Span)
+	if !ok {
+		return
+	}
+	if r.HTTPResponse != nil {
+		span.SetAttributes(attribute.Int("http.response.status_code", r.HTTPResponse.StatusCode))
+	}
+	if r.Error != nil {
+		span.RecordError(r.Error)
+		span.SetStatus(codes.Error, r.Error.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,54 @@
+package workflow
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() { provider.Shutdown(context.Background()) })
+
+	dir := t.TempDir()
+	out := filepath.Join(dir, "traceparent.txt")
+	workflow, err := LoadWorkflow(strings.NewReader(`{"name": "traced", "jobs": [
+		{"jobId": "literal", "type": "Literal", "content": "x\n", "writeTo": "TEXT"},
+		{"jobId": "echo", "command": ["sh", "-c", "cat ` + dir + `/fifo1 > /dev/null; echo $TRACEPARENT > ` + dir + `/fifo2"],
+		 "inputs": [{"readFrom": "TEXT", "path": "` + dir + `/fifo1"}], "outputs": [{"writeTo": "PARENT", "path": "` + dir + `/fifo2"}]},
+		{"jobId": "sink", "type": "File", "readFrom": "PARENT", "path": "` + out + `"}
+	]}`))
+	assert.NoError(t, err)
+	result := workflow.Execute(make(chan Event, 10))
+	assert.Equal(t, Successed, result.Status)
+
+	spans := map[string]tracetest.SpanStub{}
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = span
+	}
+	root, ok := spans["workflow traced"]
+	assert.True(t, ok)
+	for _, name := range []string{"job literal", "job echo", "job sink", "pipe TEXT", "pipe PARENT"} {
+		span, ok := spans[name]
+		if assert.True(t, ok, name) {
+			assert.Equal(t, root.SpanContext.TraceID(), span.SpanContext.TraceID(), name)
+			assert.Equal(t, root.SpanContext.SpanID(), span.Parent.SpanID(), name)
+		}
+	}
+	for _, name := range []string{"open reader", "open writer"} {
+		assert.Contains(t, spans, name)
+	}
+	data, err := os.ReadFile(out)
+	assert.NoError(t, err)
+	echo := spans["job echo"].SpanContext
+	assert.Equal(t, "00-"+echo.TraceID().String()+"-"+echo.SpanID().String()+"-01", strings.TrimSpace(string(data)))
+}
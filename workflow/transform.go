@@ -0,0 +1,255 @@
+package workflow
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/klauspost/pgzip"
+)
+
+const (
+	CodecGzip   = "gzip"
+	CodecGunzip = "gunzip"
+	CodecZstd   = "zstd"
+	CodecUnzstd = "unzstd"
+	CodecBgzip  = "bgzip"
+)
+
+// TransformJob compresses or decompresses a stream without running an external process.
+// A nil level means the default level of the codec,
+// and threads of 0 means the default parallelism of the codec.
+type TransformJob struct {
+	streamJob
+	codec   string
+	level   *int
+	threads int
+}
+
+func CreateTransformJob(jobDto *JobDto) Job {
+	job := &TransformJob{
+		streamJob: streamJob{jobId: jobDto.JobId, status: Created},
+		codec:     jobDto.Codec,
+		level:     jobDto.Level,
+		threads:   jobDto.Threads,
+	}
+	job.addInput(jobDto.ReadFrom)
+	job.addOutput(jobDto.WriteTo)
+	return job
+}
+
+func (job *TransformJob) Execute(wf *Workflow, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer wf.UnBlock()
+	if !job.begin() {
+		return
+	}
+	job.finish(job.transform())
+}
+
+func (job *TransformJob) transform() error {
+	reader := job.inputs[0].reader
+	writer := job.outputs[0].writer
+	switch job.codec {
+	case CodecGzip:
+		level := gzip.DefaultCompression
+		if job.level != nil {
+			level = *job.level
+		}
+		w, err := pgzip.NewWriterLevel(writer, level)
+		if err != nil {
+			return err
+		}
+		if job.threads > 0 {
+			if err := w.SetConcurrency(1<<20, job.threads); err != nil {
+				return err
+			}
+		}
+		return copyAndClose(w, reader)
+	case CodecGunzip:
+		var r io.ReadCloser
+		var err error
+		if job.threads > 1 {
+			r, err = pgzip.NewReaderN(reader, 1<<20, job.threads)
+		} else {
+			r, err = gzip.NewReader(reader)
+		}
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		_, err = io.Copy(writer, r)
+		return err
+	case CodecZstd:
+		options := []zstd.EOption{}
+		if job.level != nil {
+			options = append(options, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(*job.level)))
+		}
+		if job.threads > 0 {
+			options = append(options, zstd.WithEncoderConcurrency(job.threads))
+		}
+		w, err := zstd.NewWriter(writer, options...)
+		if err != nil {
+			return err
+		}
+		return copyAndClose(w, reader)
+	case CodecUnzstd:
+		options := []zstd.DOption{}
+		if job.threads > 0 {
+			options = append(options, zstd.WithDecoderConcurrency(job.threads))
+		}
+		r, err := zstd.NewReader(reader, options...)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		_, err = io.Copy(writer, r)
+		return err
+	case CodecBgzip:
+		level := flate.DefaultCompression
+		if job.level != nil {
+			level = *job.level
+		}
+		return copyAndClose(newBgzfWriter(writer, level, job.threads), reader)
+	}
+	return fmt.Errorf("unsupported codec %s", job.codec)
+}
+
+func copyAndClose(w io.WriteCloser, r io.Reader) error {
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// bgzfBlockSize is the maximum size of uncompressed data in a BGZF block.
+// It is the same as the one used by htslib, so that a compressed block
+// fits the 64KiB limit even if the data is not compressible.
+const bgzfBlockSize = 0xff00
+
+// bgzfWriter writes BGZF, compressing up to threads blocks concurrently.
+type bgzfWriter struct {
+	writer  io.Writer
+	level   int
+	threads int
+	blocks  [][]byte
+	err     error
+}
+
+func newBgzfWriter(w io.Writer, level int, threads int) *bgzfWriter {
+	if threads <= 0 {
+		threads = 1
+	}
+	return &bgzfWriter{
+		writer:  w,
+		level:   level,
+		threads: threads,
+		blocks:  [][]byte{make([]byte, 0, bgzfBlockSize)},
+	}
+}
+
+func (w *bgzfWriter) Write(data []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	written := 0
+	for len(data) > 0 {
+		block := w.blocks[len(w.blocks)-1]
+		n := bgzfBlockSize - len(block)
+		if n > len(data) {
+			n = len(data)
+		}
+		w.blocks[len(w.blocks)-1] = append(block, data[:n]...)
+		data = data[n:]
+		written += n
+		if len(w.blocks[len(w.blocks)-1]) == bgzfBlockSize {
+			if len(w.blocks) == w.threads {
+				if err := w.flush(); err != nil {
+					return written, err
+				}
+			}
+			w.blocks = append(w.blocks, make([]byte, 0, bgzfBlockSize))
+		}
+	}
+	return written, nil
+}
+
+// flush compresses the buffered blocks and writes them in order.
+func (w *bgzfWriter) flush() error {
+	compressed := make([][]byte, len(w.blocks))
+	errs := make([]error, len(w.blocks))
+	var wg sync.WaitGroup
+	for idx, block := range w.blocks {
+		if len(block) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(idx int, block []byte) {
+			defer wg.Done()
+			compressed[idx], errs[idx] = compressBgzfBlock(block, w.level)
+		}(idx, block)
+	}
+	wg.Wait()
+	for idx := range compressed {
+		if errs[idx] != nil {
+			w.err = errs[idx]
+			return w.err
+		}
+		if _, err := w.writer.Write(compressed[idx]); err != nil {
+			w.err = err
+			return err
+		}
+	}
+	w.blocks = w.blocks[:0]
+	return nil
+}
+
+func (w *bgzfWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	if err := w.flush(); err != nil {
+		return err
+	}
+	_, err := w.writer.Write(bgzfEOF)
+	return err
+}
+
+func compressBgzfBlock(data []byte, level int) ([]byte, error) {
+	var buf bytes.Buffer
+	// gzip header with the BC extra subfield, whose value is filled later
+	buf.Write([]byte{0x1f, 0x8b, 0x08, 0x04, 0, 0, 0, 0, 0, 0xff, 6, 0, 'B', 'C', 2, 0, 0, 0})
+	if err := deflateBgzfBlock(&buf, data, level); err != nil {
+		return nil, err
+	}
+	if buf.Len()+8 > 0x10000 {
+		// incompressible data, store it as is
+		buf.Truncate(18)
+		if err := deflateBgzfBlock(&buf, data, flate.NoCompression); err != nil {
+			return nil, err
+		}
+	}
+	binary.Write(&buf, binary.LittleEndian, crc32.ChecksumIEEE(data))
+	binary.Write(&buf, binary.LittleEndian, uint32(len(data)))
+	block := buf.Bytes()
+	binary.LittleEndian.PutUint16(block[16:18], uint16(len(block)-1))
+	return block, nil
+}
+
+func deflateBgzfBlock(buf *bytes.Buffer, data []byte, level int) error {
+	fw, err := flate.NewWriter(buf, level)
+	if err != nil {
+		return err
+	}
+	if _, err := fw.Write(data); err != nil {
+		return err
+	}
+	return fw.Close()
+}
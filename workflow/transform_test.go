@@ -0,0 +1,40 @@
+package workflow
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransformLevelZero(t *testing.T) {
+	content := strings.Repeat("ACGT", 1000)
+	for _, codec := range []string{CodecGzip, CodecBgzip} {
+		t.Run(codec, func(t *testing.T) {
+			out := filepath.Join(t.TempDir(), "out.gz")
+			workflow, err := LoadWorkflow(strings.NewReader(`{"jobs": [
+				{"jobId": "literal", "type": "Literal", "content": "` + content + `", "writeTo": "TEXT"},
+				{"jobId": "compress", "type": "Transform", "codec": "` + codec + `", "level": 0, "readFrom": "TEXT", "writeTo": "GZ"},
+				{"jobId": "sink", "type": "File", "readFrom": "GZ", "path": "` + out + `"}
+			]}`))
+			assert.NoError(t, err)
+			result := workflow.Execute(make(chan Event, 10))
+			assert.Equal(t, Successed, result.Status)
+			data, err := os.ReadFile(out)
+			assert.NoError(t, err)
+			// level 0 stores the data without compressing it
+			assert.Greater(t, len(data), len(content))
+			r, err := gzip.NewReader(bytes.NewReader(data))
+			if assert.NoError(t, err) {
+				decompressed, err := io.ReadAll(r)
+				assert.NoError(t, err)
+				assert.Equal(t, content, string(decompressed))
+			}
+		})
+	}
+}
@@ -1,12 +1,25 @@
 package workflow
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type WorkflowEvent struct {
@@ -16,134 +29,789 @@ type WorkflowEvent struct {
 	Message   string
 }
 type WorkflowDto struct {
+	Name        string
 	Objectstore *ObjectStore
-	Jobs        []*JobDto
-	handlers    []*PipeHandler
-	Status      JobStatus
+	// Objectstores are the named object stores selected by the store of ObjectStore jobs.
+	Objectstores map[string]*ObjectStore
+	Jobs         []*JobDto
+	handlers     []*PipeHandler
+	Status       JobStatus
+	// Parameters are the values referenced by the when expressions of jobs
+	// and filling the ${name} slots of the jobs.
+	Parameters map[string]interface{}
+	// Inputs and Outputs are the keys of the streams connected to the job
+	// using the workflow as a sub-workflow.
+	Inputs  []string
+	Outputs []string
+	// Templates are the job templates by name, in addition to the templates
+	// in TemplateFiles.
+	Templates     map[string]map[string]interface{}
+	TemplateFiles []string
+	// Agents are the addresses of the agents by name,
+	// on which the jobs are placed by their Placement.
+	Agents map[string]string
 }
 type Workflow struct {
+	Name        string
+	RunId       string
 	Objectstore *ObjectStore
-	Jobs        []Job
-	handlers    []*PipeHandler
-	Status      JobStatus
+	// Objectstores are the named object stores.
+	Objectstores map[string]*ObjectStore
+	// backends are the connections to the object stores of this workflow,
+	// keyed by the name of the store. The default store is named "".
+	backends map[string]ObjectStoreBackend
+	Jobs     []Job
+	handlers []*PipeHandler
+	Status   JobStatus
+	// Parameters are the values referenced by the when expressions of jobs.
+	Parameters map[string]interface{}
+	// dependencies are the dependsOn of the jobs keyed by job id.
+	dependencies map[string][]JobDependency
+	// conditions are the when expressions of the jobs keyed by job id.
+	conditions map[string]expression
+	// upstreams are the jobs writing the pipes read by the jobs keyed by job id.
+	upstreams map[string][]string
+	// jobComponents and keyComponents group the jobs and the pipes
+	// which are connected with each other.
+	jobComponents map[string]int
+	keyComponents map[string]int
+	// jobDtos are the definitions of the jobs keyed by job id,
+	// from which the jobs are created again to be retried.
+	jobDtos map[string]*JobDto
+	// mu guards Jobs and handlers, which are replaced when jobs are retried.
+	mu sync.Mutex
+	// dir is the directory where the batch jobs run, which is the current
+	// directory unless the workflow is a sub-workflow.
+	dir string
+	// bridges are the jobs connecting a sub-workflow to the job running it.
+	bridges map[string]Job
+	// JobEvents makes Execute send a JobEvent to its channel when a job starts
+	// and when a job finishes. The receiver must receive all the events
+	// until the WorkflowEvent, which is the last event.
+	JobEvents bool
+	// PipeEvents makes Execute send PipeEvents with the progress of the pipes
+	// to its channel as well, if JobEvents is set.
+	PipeEvents bool
+	events     chan Event
+	// Logger is the logger of the workflow, which is the standard logger
+	// of logrus if it is nil.
+	Logger *logrus.Logger
+	// JobLogs makes each job write its log lines and the output of its
+	// command to <jobId>.log in the directory where the batch jobs run.
+	JobLogs bool
+	// AgentToken is the token authenticating the workflow
+	// to the agents running the jobs placed on them.
+	AgentToken string
+	// runLogger is the logger of the execution of the workflow, which writes
+	// to the log files of the jobs, and jobLogs are their log files.
+	runLogger *logrus.Logger
+	jobLogs   *jobLogFiles
+	// canceled is set by Cancel, which stops the jobs from being retried.
+	canceled bool
+	// agent is the agent running the workflow for the host executing it.
+	agent *Agent
+	// jobOrder are the ids of the jobs in the order of the workflow if jobs
+	// are placed on agents, and hidden are the ids of the jobs added to run
+	// them, whose events and results are not reported.
+	jobOrder []string
+	hidden   map[string]bool
+	// parent is the context of the span which the span of the workflow
+	// belongs to, the job running a sub-workflow or the workflow placing
+	// jobs on an agent. ctx is the context of the span of the workflow,
+	// and jobContexts are the contexts of the spans of the jobs keyed by job id.
+	parent      context.Context
+	ctx         context.Context
+	jobContexts map[string]context.Context
 }
 type WorkflowResult struct {
 	Status  JobStatus
 	Results []*JobResult
 	Start   *time.Time
 	End     *time.Time
+	// Pipes are the accounting of the pipes of the jobs,
+	// including the pipes of the jobs placed on agents.
+	Pipes []*PipeResult `json:",omitempty"`
 }
 type JobDto struct {
-	JobId    string
-	Type     string
-	Command  []string
-	Inputs   []JobInput
-	Outputs  []JobOutput
-	Bucket   string
-	Key      string
+	JobId   string
+	Type    string
+	Command []string
+	Inputs  []JobInput
+	Outputs []JobOutput
+	Bucket  string
+	Key     string
+	Prefix  string
+	// Url is the location of the object, s3://bucket/key or a file: URL,
+	// used instead of Bucket and Key, or the URL of an HTTP job.
+	Url string
+	// Store is the name of the object store used by an ObjectStore job.
+	// The default object store is used if it is empty.
+	Store    string
 	WriteTo  string
 	ReadFrom string
+	// Format, ChunkRecords and ChunkBytes configure Split jobs.
+	Format       string
+	ChunkRecords int
+	ChunkBytes   int
+	// Codec, Level and Threads configure Transform jobs.
+	// The default level of the codec is used if Level is not set.
+	Codec   string
+	Level   *int
+	Threads int
+	// Encryption configures ObjectStore jobs.
+	Encryption *EncryptionConfig
+	// ContentType, ContentEncoding, StorageClass, ACL, Metadata and Tags
+	// are set to the objects uploaded by ObjectStore jobs.
+	ContentType     string
+	ContentEncoding string
+	StorageClass    string
+	ACL             string
+	Metadata        map[string]string
+	Tags            map[string]string
+	// Method and Headers configure the requests of HTTP jobs.
+	// HeadersFromEnv are the headers whose values are read from the
+	// environment variables named by them, so that credentials need not
+	// be written in workflow files.
+	Method         string
+	Headers        map[string]string
+	HeadersFromEnv map[string]string
+	// Path is the file read or written by a File job,
+	// or the workflow file of a job of type Workflow.
+	// Mode (octal, 0644 by default) and Fsync configure the written file.
+	Path  string
+	Mode  string
+	Fsync bool
+	// Content or ContentBase64 is the data streamed by a Literal job.
+	Content       string
+	ContentBase64 string
+	// DependsOn are the jobs which must finish before the job starts.
+	DependsOn []JobDependency
+	// When is an expression which skips the job if it is false.
+	When string
+	// SuccessCodes are the exit codes of a batch job which mean success in addition to 0.
+	SuccessCodes []int
+	// Retries is the number of times a failed batch job is retried with the jobs
+	// connected to it by pipes. RetryOn selects the failures which are retried.
+	Retries int
+	RetryOn *RetryPolicy
+	// Template is the name of the template of the job.
+	Template string
+	// Parameters are the parameters of a template, or of the workflow of a
+	// job of type Workflow, whose file is Path.
+	Parameters map[string]interface{}
+	// Placement is the name of the agent running the job.
+	// The job runs in flowyexec itself if it is empty.
+	Placement string
+	workflow  *WorkflowDto
 }
 type JobInput struct {
 	Path     string
 	ReadFrom string
+	// Materialize writes the stream to a regular file at Path
+	// for tools which cannot read from a FIFO.
+	Materialize bool
+	// Name is the input of the workflow of a job of type Workflow.
+	Name string
 }
 
 type JobOutput struct {
 	Path    string
 	WriteTo string
+	// Name is the output of the workflow of a job of type Workflow.
+	Name string
 }
 
 func LoadWorkflow(reader io.Reader) (*Workflow, error) {
-	var workflow WorkflowDto
+	return LoadWorkflowWithParameters(reader, nil)
+}
+
+// LoadWorkflowWithParameters loads a workflow whose parameters are overridden by params.
+func LoadWorkflowWithParameters(reader io.Reader, params map[string]interface{}) (*Workflow, error) {
+	workflow, err := loadWorkflowDto(reader, "", params, nil)
+	if err != nil {
+		return nil, err
+	}
+	return CreateWorkflow(workflow), nil
+}
+
+// LoadRunWorkflow loads a workflow whose parameters are overridden by params
+// and whose batch jobs run in the directory named after its run id under dir,
+// so that the workflows executed at the same time do not share their FIFOs
+// and files. The directory must be created before the workflow is executed.
+func LoadRunWorkflow(reader io.Reader, params map[string]interface{}, dir string) (*Workflow, error) {
+	workflow, err := loadWorkflowDto(reader, "", params, nil)
+	if err != nil {
+		return nil, err
+	}
+	runId := newRunId()
+	wf := createWorkflow(workflow, filepath.Join(dir, runId), nil)
+	wf.RunId = runId
+	return wf, nil
+}
+
+// LoadWorkflowFile loads the workflow file at path. The sub-workflows and
+// the template files of the workflow are relative to the directory of the file.
+func LoadWorkflowFile(path string) (*Workflow, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	workflow, err := loadWorkflowDto(f, path, nil, []string{abs})
+	if err != nil {
+		return nil, err
+	}
+	return CreateWorkflow(workflow), nil
+}
+
+// loadWorkflowDto reads and validates the workflow in the file at path,
+// which is empty if the workflow is not read from a file. params override
+// the parameters of the workflow, and loading are the files of the workflow
+// and of the workflows including it as a sub-workflow.
+func loadWorkflowDto(reader io.Reader, path string, params map[string]interface{}, loading []string) (*WorkflowDto, error) {
+	// the jobs are expanded from their templates before they are decoded
+	var raw struct {
+		WorkflowDto
+		Jobs []map[string]interface{}
+	}
 	data, err := ioutil.ReadAll(reader)
 	if err != nil {
 		return nil, err
 	}
-	if err := json.Unmarshal(data, &workflow); err != nil {
+	if err := json.Unmarshal(data, &raw); err != nil {
 		if jsonErr, ok := err.(*json.SyntaxError); ok {
 			problemPart := data[jsonErr.Offset-10 : jsonErr.Offset+10]
 			err = fmt.Errorf("%w ~ error near '%s' (offset %d)", err, problemPart, jsonErr.Offset)
 		}
-		fmt.Println(err)
 		return nil, err
 	}
+	workflow := &raw.WorkflowDto
+	for _, name := range sortedKeys(params) {
+		if _, ok := workflow.Parameters[name]; !ok {
+			return nil, fmt.Errorf("workflow has no parameter %s", name)
+		}
+		workflow.Parameters[name] = params[name]
+	}
+	dir := filepath.Dir(path)
+	templates, err := workflow.loadTemplates(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, fields := range raw.Jobs {
+		job, err := expandJob(fields, templates, workflow.Parameters)
+		if err != nil {
+			return nil, err
+		}
+		workflow.Jobs = append(workflow.Jobs, job)
+	}
+	if err := workflow.validate(dir, loading); err != nil {
+		return nil, err
+	}
+	return workflow, nil
+}
+
+// validate checks the jobs of a workflow and loads their sub-workflows
+// relative to dir.
+func (workflow *WorkflowDto) validate(dir string, loading []string) error {
 	jobIdMap := map[string]*JobDto{}
 	for _, job := range workflow.Jobs {
 		if _, ok := jobIdMap[job.JobId]; ok {
-			err = fmt.Errorf("duplicated job id %s)", job.JobId)
-			return nil, err
+			return fmt.Errorf("duplicated job id %s)", job.JobId)
 		}
 		jobIdMap[job.JobId] = job
+		switch job.Type {
+		case netSendType, netReceiveType, agentType:
+			return fmt.Errorf("job %s has reserved type %s", job.JobId, job.Type)
+		}
+		if err := job.validate(); err != nil {
+			return err
+		}
+		if _, ok := workflow.Objectstores[job.Store]; job.Store != "" && !ok {
+			return fmt.Errorf("object store %s of job %s is not defined", job.Store, job.JobId)
+		}
+		if job.Type == "Workflow" {
+			if err := job.loadSubWorkflow(dir, loading); err != nil {
+				return err
+			}
+		}
+	}
+	if err := workflow.validateGraph(); err != nil {
+		return err
+	}
+	if err := workflow.validatePlacements(); err != nil {
+		return err
+	}
+	if err := workflow.validateStreams(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// validateGraph checks the pipes and the dependencies between the jobs.
+func (workflow *WorkflowDto) validateGraph() error {
+	if err := validatePipes(workflow.Jobs, workflow.Inputs); err != nil {
+		return err
 	}
-	return CreateWorkflow(&workflow), nil
+	if err := validateDependencies(workflow.Jobs); err != nil {
+		return err
+	}
+	if err := validateRetries(workflow.Jobs); err != nil {
+		return err
+	}
+	return nil
 }
+
 func CreateWorkflow(dto *WorkflowDto) *Workflow {
-	jobs := make([]Job, 0, len(dto.Jobs))
-	for _, jobDto := range dto.Jobs {
-		var job Job
-		switch jobDto.Type {
-		case "ObjectStore":
-			job = CreateObjectStoreJob(jobDto)
+	return createWorkflow(dto, "", nil)
+}
+
+// createWorkflow creates a workflow whose batch jobs run in dir.
+// The jobs in bridges are used instead of creating them from their definitions.
+// The jobs placed on agents are run by an Agent job for each agent.
+func createWorkflow(dto *WorkflowDto, dir string, bridges map[string]Job) *Workflow {
+	jobDtos := dto.Jobs
+	var jobOrder []string
+	var hidden map[string]bool
+	if dto.hasPlacements() {
+		for _, jobDto := range dto.Jobs {
+			jobOrder = append(jobOrder, jobDto.JobId)
+		}
+		jobDtos, hidden = dto.placeJobs()
+	}
+	wf := &Workflow{
+		Name:         dto.Name,
+		RunId:        newRunId(),
+		Objectstore:  dto.Objectstore,
+		Objectstores: dto.Objectstores,
+		Status:       Created,
+		Parameters:   dto.Parameters,
+		dependencies: map[string][]JobDependency{},
+		conditions:   map[string]expression{},
+		upstreams:    pipeUpstreams(jobDtos),
+		jobDtos:      map[string]*JobDto{},
+		dir:          dir,
+		bridges:      bridges,
+		jobOrder:     jobOrder,
+		hidden:       hidden,
+	}
+	wf.jobComponents, wf.keyComponents = pipeComponents(jobDtos)
+	jobs := make([]Job, 0, len(jobDtos))
+	for _, jobDto := range jobDtos {
+		if dependencies := jobDto.dependencies(); len(dependencies) > 0 {
+			wf.dependencies[jobDto.JobId] = dependencies
+		}
+		if jobDto.When != "" {
+			// the expression has been validated by LoadWorkflow
+			wf.conditions[jobDto.JobId], _ = parseExpression(jobDto.When)
+		}
+		wf.jobDtos[jobDto.JobId] = jobDto
+		jobs = append(jobs, wf.createJob(jobDto))
+	}
+	wf.Jobs = jobs
+	return wf
+}
+
+func (w *Workflow) createJob(jobDto *JobDto) Job {
+	job := w.newJob(jobDto)
+	if j, ok := job.(ownedJob); ok {
+		j.setOwner(w)
+	}
+	return job
+}
+
+// ownedJob is a job which logs through the logger of the workflow running it.
+type ownedJob interface {
+	setOwner(w *Workflow)
+}
+
+func (w *Workflow) newJob(jobDto *JobDto) Job {
+	if job, ok := w.bridges[jobDto.JobId]; ok {
+		return job
+	}
+	switch jobDto.Type {
+	case "ObjectStore":
+		return CreateObjectStoreJob(w, jobDto)
+	case "Split":
+		return CreateSplitJob(jobDto)
+	case "Transform":
+		return CreateTransformJob(jobDto)
+	case "HTTP":
+		return CreateHTTPJob(jobDto)
+	case "File":
+		return CreateFileJob(jobDto)
+	case "Literal":
+		return CreateLiteralJob(jobDto)
+	case "Workflow":
+		return w.createSubWorkflowJob(jobDto)
+	case netSendType:
+		return createNetSendJob(jobDto)
+	case netReceiveType:
+		return createNetReceiveJob(jobDto)
+	case agentType:
+		return createAgentJob(jobDto)
+	default:
+		return createBatchJob(jobDto, w.dir)
+	}
+}
+
+// newRunId returns an id which identifies an execution of a workflow.
+func newRunId() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return fmt.Sprintf("%s-%x", time.Now().UTC().Format("20060102T150405"), b)
+}
+
+func (jobDto *JobDto) validate() error {
+	if jobDto.When != "" {
+		if _, err := parseExpression(jobDto.When); err != nil {
+			return fmt.Errorf("job %s has invalid when: %w", jobDto.JobId, err)
+		}
+	}
+	if jobDto.Retries < 0 {
+		return fmt.Errorf("job %s has negative retries", jobDto.JobId)
+	}
+	if err := jobDto.RetryOn.validate(jobDto.JobId); err != nil {
+		return err
+	}
+	switch jobDto.Type {
+	case "ObjectStore", "Split", "Transform", "HTTP", "File", "Literal", "Workflow":
+		if jobDto.Retries > 0 || jobDto.RetryOn != nil {
+			return fmt.Errorf("job %s cannot be retried because it is not a batch job", jobDto.JobId)
+		}
+	}
+	switch jobDto.Type {
+	case "ObjectStore":
+		if e := jobDto.Encryption; e != nil {
+			switch e.ServerSide {
+			case "", s3.ServerSideEncryptionAes256, s3.ServerSideEncryptionAwsKms:
+			default:
+				return fmt.Errorf("object store job %s has unsupported server-side encryption %s", jobDto.JobId, e.ServerSide)
+			}
+			if e.CustomerKeyFile != "" && e.ServerSide != "" {
+				return fmt.Errorf("object store job %s cannot use SSE-C with %s", jobDto.JobId, e.ServerSide)
+			}
+		}
+		if jobDto.StorageClass != "" && !contains(s3.StorageClass_Values(), jobDto.StorageClass) {
+			return fmt.Errorf("object store job %s has unsupported storage class %s", jobDto.JobId, jobDto.StorageClass)
+		}
+		if jobDto.ACL != "" && !contains(s3.ObjectCannedACL_Values(), jobDto.ACL) {
+			return fmt.Errorf("object store job %s has unsupported ACL %s", jobDto.JobId, jobDto.ACL)
+		}
+		if jobDto.Store != "" && strings.HasPrefix(jobDto.Url, "file:") {
+			return fmt.Errorf("object store job %s cannot have both store and a file URL", jobDto.JobId)
+		}
+		if jobDto.Url != "" {
+			if jobDto.Bucket != "" || jobDto.Key != "" {
+				return fmt.Errorf("object store job %s cannot have both url and bucket or key", jobDto.JobId)
+			}
+			if _, _, _, err := parseObjectUrl(jobDto.Url); err != nil {
+				return fmt.Errorf("object store job %s: %w", jobDto.JobId, err)
+			}
+		}
+		if jobDto.Prefix != "" && jobDto.Key != "" {
+			return fmt.Errorf("object store job %s cannot have both key and prefix", jobDto.JobId)
+		}
+		if len(jobDto.Outputs) > 0 && (jobDto.WriteTo != "" || jobDto.ReadFrom != "") {
+			return fmt.Errorf("object store job %s cannot have outputs with writeTo or readFrom", jobDto.JobId)
+		}
+		if (jobDto.Prefix != "" || hasGlob(jobDto.Key)) && jobDto.ReadFrom != "" {
+			return fmt.Errorf("object store job %s cannot upload to a prefix or a glob", jobDto.JobId)
+		}
+	case "HTTP":
+		if (jobDto.ReadFrom == "") == (jobDto.WriteTo == "") {
+			return fmt.Errorf("http job %s requires either readFrom or writeTo", jobDto.JobId)
+		}
+		if !strings.HasPrefix(jobDto.Url, "http://") && !strings.HasPrefix(jobDto.Url, "https://") {
+			return fmt.Errorf("http job %s has unsupported url %s", jobDto.JobId, jobDto.Url)
+		}
+		switch jobDto.Method {
+		case "", http.MethodPut, http.MethodPost:
+			if jobDto.Method != "" && jobDto.WriteTo != "" {
+				return fmt.Errorf("http job %s cannot have a method with writeTo", jobDto.JobId)
+			}
 		default:
-			job = CreateBatchJob(jobDto)
+			return fmt.Errorf("http job %s has unsupported method %s", jobDto.JobId, jobDto.Method)
+		}
+		for header, name := range jobDto.HeadersFromEnv {
+			if name == "" {
+				return fmt.Errorf("http job %s requires the environment variable of header %s", jobDto.JobId, header)
+			}
+			if _, ok := jobDto.Headers[header]; ok {
+				return fmt.Errorf("http job %s cannot have header %s in both headers and headersFromEnv", jobDto.JobId, header)
+			}
+		}
+	case "File":
+		if (jobDto.ReadFrom == "") == (jobDto.WriteTo == "") {
+			return fmt.Errorf("file job %s requires either readFrom or writeTo", jobDto.JobId)
+		}
+		if jobDto.Path == "" {
+			return fmt.Errorf("file job %s requires path", jobDto.JobId)
+		}
+		if jobDto.Mode != "" {
+			if mode, err := strconv.ParseUint(jobDto.Mode, 8, 32); err != nil || mode > 07777 {
+				return fmt.Errorf("file job %s has invalid mode %s", jobDto.JobId, jobDto.Mode)
+			}
+		}
+	case "Literal":
+		if jobDto.WriteTo == "" {
+			return fmt.Errorf("literal job %s requires writeTo", jobDto.JobId)
+		}
+		if jobDto.Content != "" && jobDto.ContentBase64 != "" {
+			return fmt.Errorf("literal job %s cannot have both content and contentBase64", jobDto.JobId)
+		}
+		if _, err := base64.StdEncoding.DecodeString(jobDto.ContentBase64); err != nil {
+			return fmt.Errorf("literal job %s has invalid contentBase64: %w", jobDto.JobId, err)
+		}
+	case "Workflow":
+		if jobDto.Path == "" {
+			return fmt.Errorf("workflow job %s requires path", jobDto.JobId)
+		}
+		for _, input := range jobDto.Inputs {
+			if input.Name == "" || input.ReadFrom == "" {
+				return fmt.Errorf("workflow job %s requires name and readFrom of inputs", jobDto.JobId)
+			}
+		}
+		for _, output := range jobDto.Outputs {
+			if output.Name == "" || output.WriteTo == "" {
+				return fmt.Errorf("workflow job %s requires name and writeTo of outputs", jobDto.JobId)
+			}
+		}
+	case "Split":
+		if jobDto.ReadFrom == "" || len(jobDto.Outputs) == 0 {
+			return fmt.Errorf("split job %s requires readFrom and outputs", jobDto.JobId)
+		}
+		switch jobDto.Format {
+		case "", FormatLines, FormatFastq, FormatBgzf:
+		default:
+			return fmt.Errorf("split job %s has unsupported format %s", jobDto.JobId, jobDto.Format)
+		}
+	case "Transform":
+		if jobDto.ReadFrom == "" || jobDto.WriteTo == "" {
+			return fmt.Errorf("transform job %s requires readFrom and writeTo", jobDto.JobId)
+		}
+		switch jobDto.Codec {
+		case CodecGzip, CodecGunzip, CodecZstd, CodecUnzstd, CodecBgzip:
+		default:
+			return fmt.Errorf("transform job %s has unsupported codec %s", jobDto.JobId, jobDto.Codec)
+		}
+	case netSendType, netReceiveType:
+	default:
+		if len(jobDto.Command) == 0 {
+			return fmt.Errorf("batch job %s requires command", jobDto.JobId)
 		}
-		jobs = append(jobs, job)
 	}
-	return &Workflow{
-		Objectstore: dto.Objectstore,
-		Jobs:        jobs,
-		Status:      Created,
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
 	}
+	return false
 }
 
-func CreateObjectStoreJob(jobDto *JobDto) Job {
+func CreateObjectStoreJob(wf *Workflow, jobDto *JobDto) Job {
+	bucket, key := jobDto.Bucket, jobDto.Key
+	var store ObjectStoreBackend
+	if jobDto.Url != "" {
+		// the URL has been validated by LoadWorkflow
+		store, bucket, key, _ = parseObjectUrl(jobDto.Url)
+	}
 	if jobDto.ReadFrom != "" {
 		return &ObjectStoreUploadJob{
-			jobId:    jobDto.JobId,
-			status:   Created,
-			readFrom: jobDto.ReadFrom,
-			Bucket:   jobDto.Bucket,
-			key:      jobDto.Key,
-			closeCh:  make(chan JobStatus),
-		}
-	} else if jobDto.WriteTo != "" {
-		return &ObjectStoreDownloadJob{
-			jobId:   jobDto.JobId,
-			status:  Created,
-			writeTo: jobDto.WriteTo,
-			Bucket:  jobDto.Bucket,
-			key:     jobDto.Key,
-			closeCh: make(chan JobStatus),
+			workflow:   wf,
+			jobId:      jobDto.JobId,
+			status:     Created,
+			readFrom:   jobDto.ReadFrom,
+			Bucket:     bucket,
+			key:        key,
+			store:      store,
+			storeName:  jobDto.Store,
+			encryption: jobDto.Encryption,
+			options: uploadOptions{
+				contentType:     jobDto.ContentType,
+				contentEncoding: jobDto.ContentEncoding,
+				storageClass:    jobDto.StorageClass,
+				acl:             jobDto.ACL,
+				metadata:        jobDto.Metadata,
+				tags:            jobDto.Tags,
+			},
+			closeCh: make(chan JobStatus, 1),
+			started: make(chan struct{}),
+		}
+	} else if jobDto.WriteTo != "" || len(jobDto.Outputs) > 0 {
+		job := &ObjectStoreDownloadJob{
+			workflow:   wf,
+			jobId:      jobDto.JobId,
+			status:     Created,
+			Bucket:     bucket,
+			key:        key,
+			prefix:     jobDto.Prefix,
+			store:      store,
+			storeName:  jobDto.Store,
+			encryption: jobDto.Encryption,
+			started:    make(chan struct{}),
+		}
+		if jobDto.WriteTo != "" {
+			job.outputs = []*objectStoreDownloadOutput{{job: job, writeTo: jobDto.WriteTo, index: -1}}
+		} else {
+			for idx, output := range jobDto.Outputs {
+				job.outputs = append(job.outputs, &objectStoreDownloadOutput{job: job, writeTo: output.WriteTo, index: idx})
+			}
 		}
+		job.closeCh = make(chan JobStatus, len(job.outputs)+1)
+		return job
 	}
 	panic("unimplemented")
 }
 
 func CreateBatchJob(jobDto *JobDto) Job {
+	return createBatchJob(jobDto, "")
+}
+
+// createBatchJob creates a batch job running in dir,
+// where the relative paths of its inputs and outputs are.
+func createBatchJob(jobDto *JobDto, dir string) Job {
 	job := &BatchJob{
-		JobId:   jobDto.JobId,
-		status:  Created,
-		Command: jobDto.Command,
-		Inputs:  make([]BatchJobInput, len(jobDto.Inputs)),
-		Outputs: make([]BatchJobOutput, len(jobDto.Outputs)),
+		JobId:        jobDto.JobId,
+		status:       Created,
+		Command:      jobDto.Command,
+		Inputs:       make([]BatchJobInput, len(jobDto.Inputs)),
+		Outputs:      make([]BatchJobOutput, len(jobDto.Outputs)),
+		SuccessCodes: jobDto.SuccessCodes,
+		dir:          dir,
 	}
+	job.ctx, job._cancel = context.WithCancel(context.Background())
 	for idx, input := range jobDto.Inputs {
 		job.Inputs[idx] = BatchJobInput{
-			job:  job,
-			path: input.Path,
-			key:  input.ReadFrom,
+			job:         job,
+			path:        inDir(dir, input.Path),
+			key:         input.ReadFrom,
+			materialize: input.Materialize,
+		}
+		if input.Materialize {
+			job.Inputs[idx].materialized = &materializedFile{done: make(chan struct{})}
 		}
 	}
 	for idx, output := range jobDto.Outputs {
 		job.Outputs[idx] = BatchJobOutput{
 			job:  job,
-			path: output.Path,
+			path: inDir(dir, output.Path),
 			key:  output.WriteTo,
 		}
 	}
 	return job
 }
+
+// initObjectStores connects to the object stores of the workflow.
+// OBJECTSTORE_ENDPOINT overrides the endpoint of the default object store.
+func (w *Workflow) initObjectStores() error {
+	w.backends = map[string]ObjectStoreBackend{}
+	if w.Objectstore != nil {
+		endpoint := w.Objectstore.Endpoint
+		if tmp := os.Getenv("OBJECTSTORE_ENDPOINT"); tmp != "" {
+			endpoint = tmp
+		}
+		backend, err := w.Objectstore.newBackend(endpoint)
+		if err != nil {
+			return err
+		}
+		w.backends[""] = backend
+	}
+	for name, store := range w.Objectstores {
+		backend, err := store.newBackend(store.Endpoint)
+		if err != nil {
+			return fmt.Errorf("object store %s: %w", name, err)
+		}
+		w.backends[name] = backend
+	}
+	return nil
+}
+
+// objectStoreBackend returns the backend of the object store named name.
+func (w *Workflow) objectStoreBackend(name string) (ObjectStoreBackend, error) {
+	if w == nil {
+		return nil, fmt.Errorf("object store is not initialized")
+	}
+	backend, ok := w.backends[name]
+	if !ok {
+		if name == "" {
+			return nil, fmt.Errorf("object store is not initialized")
+		}
+		return nil, fmt.Errorf("object store %s is not initialized", name)
+	}
+	return backend, nil
+}
+
+// abort aborts all the jobs of the workflow.
+func (w *Workflow) abort() {
+	w.mu.Lock()
+	jobs := w.Jobs
+	w.mu.Unlock()
+	for _, job := range jobs {
+		job.Abort()
+	}
+}
+
+// Cancel aborts the jobs of the workflow, including the jobs which have not
+// started yet, and stops the failed jobs from being retried.
+// Execute returns when the aborted jobs have finished.
+func (w *Workflow) Cancel() {
+	w.mu.Lock()
+	w.canceled = true
+	jobs := w.Jobs
+	w.mu.Unlock()
+	w.log().Warn("Cancel Workflow")
+	for _, job := range jobs {
+		// the jobs which have finished keep their status
+		if !job.GetStatus().IsFinished() {
+			job.Abort()
+		}
+	}
+}
+
+func (w *Workflow) isCanceled() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.canceled
+}
+
+// GetResults returns the current results of the jobs,
+// which may be running while the workflow is executed.
+func (w *Workflow) GetResults() []*JobResult {
+	w.mu.Lock()
+	jobs := w.Jobs
+	w.mu.Unlock()
+	results := make([]*JobResult, 0, len(jobs))
+	for _, job := range jobs {
+		results = append(results, job.GetResult())
+	}
+	return w.placedResults(jobs, results)
+}
+
+// emitJobEvent sends an event of a job if JobEvents is set.
+func (w *Workflow) emitJobEvent(event *JobEvent) {
+	if w.events == nil || w.hidden[event.JobId] {
+		return
+	}
+	event.Occured = time.Now()
+	w.events <- event
+}
+
+func (w *Workflow) emitPipeEvent(event *PipeEvent) {
+	if w.events == nil || !w.PipeEvents {
+		return
+	}
+	event.Occured = time.Now()
+	w.events <- event
+}
+
 func (w *Workflow) GetStatus() JobStatus {
 	status := Successed
 	for _, job := range w.Jobs {
@@ -160,51 +828,127 @@ func (we *WorkflowEvent) GetEventType() EventType {
 	return WorkflowEvents
 }
 func (w *Workflow) UnBlock() {
-	for _, handler := range w.handlers {
+	w.mu.Lock()
+	handlers := w.handlers
+	w.mu.Unlock()
+	for _, handler := range handlers {
 		handler.UnBlock()
 	}
 }
 func (w *Workflow) Execute(status_ch chan Event) *WorkflowResult {
 	start := time.Now()
-	if w.Objectstore != nil {
-		err := w.Objectstore.Init()
-		if err != nil {
-			status_ch <- &WorkflowEvent{
-				Status:    Failed,
-				ExecError: err,
-			}
-			return &WorkflowResult{}
+	parent := w.parent
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, span := tracer().Start(parent, "workflow "+w.Name, trace.WithAttributes(
+		attribute.String("flowyexec.workflow", w.Name),
+		attribute.String("flowyexec.run_id", w.RunId),
+	))
+	w.mu.Lock()
+	w.ctx = ctx
+	w.jobContexts = map[string]context.Context{}
+	w.mu.Unlock()
+	w.startLogs()
+	defer w.stopLogs()
+	if err := w.initObjectStores(); err != nil {
+		span.RecordError(err)
+		endSpan(span, Failed, err.Error())
+		status_ch <- &WorkflowEvent{
+			Status:    Failed,
+			ExecError: err,
 		}
+		return &WorkflowResult{}
 	}
-	w.handlers = CreateHandlers(w.Jobs)
-	for _, handler := range w.handlers {
-		handler.Init()
-	}
-	for _, handler := range w.handlers {
-		go handler.Handle()
+	if w.JobEvents {
+		w.events = status_ch
 	}
+	w.handlers = CreateHandlers(w.Jobs)
+	w.startHandlers(w.handlers)
+	runs := w.createJobRuns()
 	var wg sync.WaitGroup
-	for _, job := range w.Jobs {
+	for _, run := range runs {
 		wg.Add(1)
-		go job.Execute(w, &wg)
+		go w.runJob(run, runs, &wg)
 	}
 	wg.Wait()
-	for _, handler := range w.handlers {
-		handler.Finished()
+	// the spans and the last events of the pipes precede
+	// the end of the workflow and the WorkflowEvent
+	w.mu.Lock()
+	handlers := w.handlers
+	w.mu.Unlock()
+	for _, handler := range handlers {
+		handler.wait()
 	}
 	end := time.Now()
+	endSpan(span, w.GetStatus(), "")
 	status_ch <- &WorkflowEvent{
 		Status:   w.GetStatus(),
 		ExitCode: w.GetStatus().GetDefaultExitCode(),
 	}
 	results := make([]*JobResult, 0)
 	for _, job := range w.Jobs {
-		results = append(results, job.GetResult())
+		result := job.GetResult()
+		if attempts := runs[job.GetId()].attempts; len(attempts) > 0 {
+			result.Attempts = append(attempts, newJobAttempt(result))
+		}
+		results = append(results, result)
 	}
 	return &WorkflowResult{
 		Status:  w.GetStatus(),
-		Results: results,
+		Results: w.placedResults(w.Jobs, results),
 		Start:   &start,
 		End:     &end,
+		Pipes:   w.pipeResults(),
+	}
+}
+
+// pipeResults returns the accounting of the pipes in the order of their producers.
+func (w *Workflow) pipeResults() []*PipeResult {
+	w.mu.Lock()
+	jobs := w.Jobs
+	handlers := w.handlers
+	w.mu.Unlock()
+	byProducer := map[string][]*PipeHandler{}
+	for _, handler := range handlers {
+		byProducer[handler.producer] = append(byProducer[handler.producer], handler)
+	}
+	pipes := make([]*PipeResult, 0, len(handlers))
+	for _, job := range jobs {
+		for _, handler := range byProducer[job.GetId()] {
+			pipes = append(pipes, handler.Result())
+		}
+		if agent, ok := job.(*agentJob); ok {
+			pipes = append(pipes, agent.remotePipes()...)
+		}
+	}
+	return pipes
+}
+
+// pipeHandler returns the current handler of the pipe of key,
+// or nil if the job using it has been created without a workflow.
+func (w *Workflow) pipeHandler(key string) *PipeHandler {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, handler := range w.handlers {
+		if handler.output.Key() == key {
+			return handler
+		}
+	}
+	return nil
+}
+
+// startHandlers starts transferring the data of the pipes.
+func (w *Workflow) startHandlers(handlers []*PipeHandler) {
+	for _, handler := range handlers {
+		handler.ctx = w.ctx
+		handler.owner = w
+		handler.Init()
+	}
+	for _, handler := range handlers {
+		go handler.Handle()
 	}
 }
@@ -1,8 +1,11 @@
 package workflow
 
 import (
+	"bytes"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -40,6 +43,44 @@ func TestS3DownloadWorkflow(t *testing.T) {
 	testWorkflow(t, "../testdata/s3_download.json", &evs)
 }
 
+func TestS3DownloadGlobWorkflow(t *testing.T) {
+	evs := WorkflowResult{
+		Status: Successed,
+		Results: []*JobResult{
+			{
+				JobId:    "download",
+				Status:   Successed,
+				ExitCode: 0,
+			},
+			{
+				JobId:    "wordcount",
+				Status:   Successed,
+				ExitCode: 0,
+			},
+		},
+	}
+	testWorkflow(t, "../testdata/s3_download_glob.json", &evs)
+}
+
+func TestS3DownloadGlobNoMatch(t *testing.T) {
+	evs := WorkflowResult{
+		Status: Failed,
+		Results: []*JobResult{
+			{
+				JobId:    "download",
+				Status:   Failed,
+				ExitCode: -1,
+			},
+			{
+				JobId:    "wordcount",
+				Status:   Aborted,
+				ExitCode: -1,
+			},
+		},
+	}
+	testWorkflow(t, "../testdata/s3_download_glob_nomatch.json", &evs)
+}
+
 func TestS3UploadWorkflow(t *testing.T) {
 	evs := WorkflowResult{
 		Status: Successed,
@@ -59,6 +100,25 @@ func TestS3UploadWorkflow(t *testing.T) {
 	testWorkflow(t, "../testdata/s3_upload.json", &evs)
 }
 
+func TestS3EncryptedUploadWorkflow(t *testing.T) {
+	evs := WorkflowResult{
+		Status: Successed,
+		Results: []*JobResult{
+			{
+				JobId:    "ls-l",
+				Status:   Successed,
+				ExitCode: 0,
+			},
+			{
+				JobId:    "uploadS3",
+				Status:   Successed,
+				ExitCode: 0,
+			},
+		},
+	}
+	testWorkflow(t, "../testdata/s3_upload_encrypted.json", &evs)
+}
+
 func TestS3DownloadNoBucket(t *testing.T) {
 	evs := WorkflowResult{
 		Status: Failed,
@@ -183,6 +243,500 @@ func TestForkPipeError(t *testing.T) {
 	}
 	testWorkflow(t, "../testdata/forked_pipe_error.json", &evs)
 }
+func TestFinishedProducer(t *testing.T) {
+	evs := WorkflowResult{
+		Status: Successed,
+		Results: []*JobResult{
+			{
+				JobId:    "empty",
+				Status:   Successed,
+				ExitCode: 0,
+			},
+			{
+				JobId:    "wordcount",
+				Status:   Successed,
+				ExitCode: 0,
+			},
+		},
+	}
+	testWorkflow(t, "../testdata/finished_producer.json", &evs)
+}
+func TestSplitFastq(t *testing.T) {
+	evs := WorkflowResult{
+		Status: Successed,
+		Results: []*JobResult{
+			{
+				JobId:    "fastq",
+				Status:   Successed,
+				ExitCode: 0,
+			},
+			{
+				JobId:    "split",
+				Status:   Successed,
+				ExitCode: 0,
+			},
+			{
+				JobId:    "count1",
+				Status:   Successed,
+				ExitCode: 0,
+			},
+			{
+				JobId:    "count2",
+				Status:   Successed,
+				ExitCode: 0,
+			},
+		},
+	}
+	testWorkflow(t, "../testdata/split_fastq.json", &evs)
+}
+func TestSplitFastqMalformed(t *testing.T) {
+	evs := WorkflowResult{
+		Status: Failed,
+		Results: []*JobResult{
+			{
+				JobId:    "fastq",
+				Status:   Aborted,
+				ExitCode: -1,
+			},
+			{
+				JobId:    "split",
+				Status:   Failed,
+				ExitCode: -1,
+			},
+			{
+				JobId:    "wc1",
+				Status:   Aborted,
+				ExitCode: -1,
+			},
+			{
+				JobId:    "wc2",
+				Status:   Aborted,
+				ExitCode: -1,
+			},
+		},
+	}
+	testWorkflow(t, "../testdata/split_fastq_malformed.json", &evs)
+}
+func TestTransformRoundTrip(t *testing.T) {
+	evs := WorkflowResult{
+		Status: Successed,
+		Results: []*JobResult{
+			{
+				JobId:    "seq",
+				Status:   Successed,
+				ExitCode: 0,
+			},
+			{
+				JobId:    "gzip",
+				Status:   Successed,
+				ExitCode: 0,
+			},
+			{
+				JobId:    "gunzip",
+				Status:   Successed,
+				ExitCode: 0,
+			},
+			{
+				JobId:    "zstd",
+				Status:   Successed,
+				ExitCode: 0,
+			},
+			{
+				JobId:    "unzstd",
+				Status:   Successed,
+				ExitCode: 0,
+			},
+			{
+				JobId:    "count",
+				Status:   Successed,
+				ExitCode: 0,
+			},
+		},
+	}
+	testWorkflow(t, "../testdata/transform_roundtrip.json", &evs)
+}
+func TestTransformBgzipSplit(t *testing.T) {
+	evs := WorkflowResult{
+		Status: Successed,
+		Results: []*JobResult{
+			{
+				JobId:    "seq",
+				Status:   Successed,
+				ExitCode: 0,
+			},
+			{
+				JobId:    "bgzip",
+				Status:   Successed,
+				ExitCode: 0,
+			},
+			{
+				JobId:    "split",
+				Status:   Successed,
+				ExitCode: 0,
+			},
+			{
+				JobId:    "count",
+				Status:   Successed,
+				ExitCode: 0,
+			},
+		},
+	}
+	testWorkflow(t, "../testdata/transform_bgzip_split.json", &evs)
+}
+func TestLocalStoreDownload(t *testing.T) {
+	evs := WorkflowResult{
+		Status: Successed,
+		Results: []*JobResult{
+			{
+				JobId:    "download",
+				Status:   Successed,
+				ExitCode: 0,
+			},
+			{
+				JobId:    "count",
+				Status:   Successed,
+				ExitCode: 0,
+			},
+		},
+	}
+	testWorkflow(t, "../testdata/local_store_download.json", &evs)
+}
+func TestLocalStoreDownloadOutputsMismatch(t *testing.T) {
+	evs := WorkflowResult{
+		Status: Failed,
+		Results: []*JobResult{
+			{
+				JobId:    "download",
+				Status:   Failed,
+				ExitCode: -1,
+			},
+			{
+				JobId:    "count",
+				Status:   Aborted,
+				ExitCode: -1,
+			},
+		},
+	}
+	testWorkflow(t, "../testdata/local_store_download_outputs.json", &evs)
+}
+func TestLocalStoreUpload(t *testing.T) {
+	defer os.RemoveAll("local_store")
+	evs := WorkflowResult{
+		Status: Successed,
+		Results: []*JobResult{
+			{
+				JobId:    "seq",
+				Status:   Successed,
+				ExitCode: 0,
+			},
+			{
+				JobId:    "upload",
+				Status:   Successed,
+				ExitCode: 0,
+			},
+		},
+	}
+	testWorkflow(t, "../testdata/local_store_upload.json", &evs)
+	data, err := os.ReadFile("local_store/seq.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, 1000, bytes.Count(data, []byte("\n")))
+	files, _ := os.ReadDir("local_store")
+	assert.Len(t, files, 1, "temporary file must be renamed")
+}
+func TestNamedObjectStores(t *testing.T) {
+	defer os.RemoveAll("named_stores")
+	evs := WorkflowResult{
+		Status: Successed,
+		Results: []*JobResult{
+			{
+				JobId:    "download",
+				Status:   Successed,
+				ExitCode: 0,
+			},
+			{
+				JobId:    "upload",
+				Status:   Successed,
+				ExitCode: 0,
+			},
+		},
+	}
+	testWorkflow(t, "../testdata/named_stores.json", &evs)
+	expected, err := os.ReadFile("../testdata/objectstore/bucket/runs/sample_L001.fastq")
+	assert.NoError(t, err)
+	data, err := os.ReadFile("named_stores/copy/sample_L001.fastq")
+	assert.NoError(t, err)
+	assert.Equal(t, expected, data)
+}
+func TestUndefinedObjectStore(t *testing.T) {
+	_, err := LoadWorkflow(strings.NewReader(`{"jobs": [{"jobId": "download", "type": "ObjectStore", "store": "missing", "bucket": "b", "key": "k", "writeTo": "OUT"}]}`))
+	assert.EqualError(t, err, "object store missing of job download is not defined")
+}
+func TestFileCopy(t *testing.T) {
+	defer os.RemoveAll("file_sink")
+	evs := WorkflowResult{
+		Status: Successed,
+		Results: []*JobResult{
+			{
+				JobId:    "source",
+				Status:   Successed,
+				ExitCode: 0,
+			},
+			{
+				JobId:    "sink",
+				Status:   Successed,
+				ExitCode: 0,
+			},
+		},
+	}
+	testWorkflow(t, "../testdata/file_copy.json", &evs)
+	expected, err := os.ReadFile("../testdata/objectstore/bucket/runs/sample_L001.fastq")
+	assert.NoError(t, err)
+	data, err := os.ReadFile("file_sink/sample.fastq")
+	assert.NoError(t, err)
+	assert.Equal(t, expected, data)
+	info, err := os.Stat("file_sink/sample.fastq")
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
+func TestFileSinkUpstreamFailed(t *testing.T) {
+	defer os.RemoveAll("file_sink")
+	evs := WorkflowResult{
+		Status: Failed,
+		Results: []*JobResult{
+			{
+				JobId:    "seq",
+				Status:   Failed,
+				ExitCode: 1,
+			},
+			{
+				JobId:    "sink",
+				Status:   Aborted,
+				ExitCode: -1,
+			},
+		},
+	}
+	testWorkflow(t, "../testdata/file_sink_upstream_fail.json", &evs)
+	files, err := os.ReadDir("file_sink")
+	assert.NoError(t, err)
+	assert.Empty(t, files, "partial file must be removed")
+}
+func TestLiteral(t *testing.T) {
+	evs := WorkflowResult{
+		Status: Successed,
+		Results: []*JobResult{
+			{
+				JobId:    "intervals",
+				Status:   Successed,
+				ExitCode: 0,
+			},
+			{
+				JobId:    "sheet",
+				Status:   Successed,
+				ExitCode: 0,
+			},
+			{
+				JobId:    "check",
+				Status:   Successed,
+				ExitCode: 0,
+			},
+		},
+	}
+	testWorkflow(t, "../testdata/literal.json", &evs)
+}
+func TestMaterialize(t *testing.T) {
+	evs := WorkflowResult{
+		Status: Successed,
+		Results: []*JobResult{
+			{
+				JobId:    "seq",
+				Status:   Successed,
+				ExitCode: 0,
+			},
+			{
+				JobId:    "literal",
+				Status:   Successed,
+				ExitCode: 0,
+			},
+			{
+				JobId:    "seek",
+				Status:   Successed,
+				ExitCode: 0,
+			},
+		},
+	}
+	testWorkflow(t, "../testdata/materialize.json", &evs)
+	assert.NoFileExists(t, "materialized.txt")
+}
+func TestMaterializeUpstreamFailed(t *testing.T) {
+	defer os.Remove("materialize_started")
+	evs := WorkflowResult{
+		Status: Failed,
+		Results: []*JobResult{
+			{
+				JobId:    "literal",
+				Status:   Successed,
+				ExitCode: 0,
+			},
+			{
+				JobId:    "gunzip",
+				Status:   Failed,
+				ExitCode: -1,
+			},
+			{
+				JobId:    "seek",
+				Status:   Aborted,
+				ExitCode: -1,
+			},
+		},
+	}
+	testWorkflow(t, "../testdata/materialize_upstream_fail.json", &evs)
+	assert.NoFileExists(t, "materialize_started", "job must not start")
+	assert.NoFileExists(t, "materialized.txt")
+}
+func TestMaterializeBatchUpstreamFailed(t *testing.T) {
+	defer os.Remove("materialize_started")
+	evs := WorkflowResult{
+		Status: Failed,
+		Results: []*JobResult{
+			{
+				JobId:    "partial",
+				Status:   Failed,
+				ExitCode: 3,
+			},
+			{
+				JobId:    "copy",
+				Status:   Aborted,
+				ExitCode: -1,
+			},
+		},
+	}
+	testWorkflow(t, "../testdata/materialize_batch_upstream_fail.json", &evs)
+	assert.NoFileExists(t, "materialize_started", "job must not start on a truncated file")
+	assert.NoFileExists(t, "materialized.txt")
+}
+
+// TestMaterializeSharedProducer runs a job on a materialized input whose
+// producer writes another pipe, which is read after the output of the job.
+func TestMaterializeSharedProducer(t *testing.T) {
+	defer os.Remove("materialize_shared.txt")
+	evs := WorkflowResult{
+		Status: Successed,
+		Results: []*JobResult{
+			{
+				JobId:    "produce",
+				Status:   Successed,
+				ExitCode: 0,
+			},
+			{
+				JobId:    "copy",
+				Status:   Successed,
+				ExitCode: 0,
+			},
+			{
+				JobId:    "concat",
+				Status:   Successed,
+				ExitCode: 0,
+			},
+		},
+	}
+	testWorkflow(t, "../testdata/materialize_shared_producer.json", &evs)
+	data, err := os.ReadFile("materialize_shared.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "100001", strings.TrimSpace(string(data)))
+	assert.NoFileExists(t, "materialized.txt")
+}
+func TestDependsOn(t *testing.T) {
+	defer os.Remove("depends_on.txt")
+	evs := WorkflowResult{
+		Status: Failed,
+		Results: []*JobResult{
+			{
+				JobId:    "literal",
+				Status:   Successed,
+				ExitCode: 0,
+			},
+			{
+				JobId:    "sink",
+				Status:   Successed,
+				ExitCode: 0,
+			},
+			{
+				JobId:    "index",
+				Status:   Successed,
+				ExitCode: 0,
+			},
+			{
+				JobId:    "fail",
+				Status:   Failed,
+				ExitCode: 1,
+			},
+			{
+				JobId:    "onFailure",
+				Status:   Successed,
+				ExitCode: 0,
+			},
+			{
+				JobId:    "onSuccess",
+				Status:   Aborted,
+				ExitCode: -1,
+			},
+			{
+				JobId:    "always",
+				Status:   Successed,
+				ExitCode: 0,
+			},
+		},
+	}
+	testWorkflow(t, "../testdata/depends_on.json", &evs)
+}
+func TestWhenSkip(t *testing.T) {
+	evs := WorkflowResult{
+		Status: Successed,
+		Results: []*JobResult{
+			{
+				JobId:    "grep",
+				Status:   Successed,
+				ExitCode: 1,
+			},
+			{
+				JobId:    "full",
+				Status:   Skipped,
+				ExitCode: 0,
+			},
+			{
+				JobId:    "fullConsumer",
+				Status:   Skipped,
+				ExitCode: 0,
+			},
+			{
+				JobId:    "afterFull",
+				Status:   Skipped,
+				ExitCode: 0,
+			},
+			{
+				JobId:    "noMatch",
+				Status:   Successed,
+				ExitCode: 0,
+			},
+			{
+				JobId:    "seq",
+				Status:   Successed,
+				ExitCode: 0,
+			},
+			{
+				JobId:    "skippedConsumer",
+				Status:   Skipped,
+				ExitCode: 0,
+			},
+			{
+				JobId:    "count",
+				Status:   Successed,
+				ExitCode: 0,
+			},
+		},
+	}
+	testWorkflow(t, "../testdata/when_skip.json", &evs)
+}
 
 func assertJobResult(t *testing.T, expected *JobResult, actual *JobResult) {
 	assert.Equal(t, expected.JobId, actual.JobId)
@@ -195,3 +749,114 @@ func assertWorkflowResult(t *testing.T, expected *WorkflowResult, actual *Workfl
 		assertJobResult(t, expected.Results[idx], jr)
 	}
 }
+func TestRetry(t *testing.T) {
+	defer os.RemoveAll("file_sink")
+	defer os.Remove("retry_attempted")
+	j, err := os.Open("../testdata/retry.json")
+	assert.NoError(t, err)
+	workflow, err := LoadWorkflow(j)
+	assert.NoError(t, err)
+	result := workflow.Execute(make(chan Event, 10))
+	assert.Equal(t, Successed, result.Status)
+	for _, jr := range result.Results {
+		assert.Equal(t, Successed, jr.Status, jr.JobId)
+		assert.Len(t, jr.Attempts, 2, jr.JobId)
+	}
+	flaky := result.Results[1].Attempts
+	assert.Equal(t, Failed, flaky[0].Status)
+	assert.Equal(t, 3, flaky[0].ExitCode)
+	assert.Equal(t, Successed, flaky[1].Status)
+	data, err := os.ReadFile("file_sink/retry.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "hello\n", string(data))
+}
+func TestRetryExhausted(t *testing.T) {
+	j, err := os.Open("../testdata/retry_exhausted.json")
+	assert.NoError(t, err)
+	workflow, err := LoadWorkflow(j)
+	assert.NoError(t, err)
+	result := workflow.Execute(make(chan Event, 10))
+	assert.Equal(t, Failed, result.Status)
+	killed := result.Results[0]
+	assert.Equal(t, Failed, killed.Status)
+	assert.Equal(t, "SIGTERM", killed.Signal)
+	assert.Len(t, killed.Attempts, 2)
+	for _, attempt := range killed.Attempts {
+		assert.Equal(t, "SIGTERM", attempt.Signal)
+	}
+	notRetried := result.Results[1]
+	assert.Equal(t, Failed, notRetried.Status)
+	assert.Equal(t, 2, notRetried.ExitCode)
+	assert.Empty(t, notRetried.Attempts)
+}
+func TestSubWorkflow(t *testing.T) {
+	defer os.RemoveAll("subworkflow_out")
+	workflow, err := LoadWorkflowFile("../testdata/subworkflow.json")
+	assert.NoError(t, err)
+	result := workflow.Execute(make(chan Event, 10))
+	assert.Equal(t, Successed, result.Status)
+	for _, jr := range result.Results {
+		assert.Equal(t, Successed, jr.Status, jr.JobId)
+	}
+	count1 := result.Results[1]
+	assert.Len(t, count1.Jobs, 1)
+	assert.Equal(t, "count", count1.Jobs[0].JobId)
+	data, err := os.ReadFile("subworkflow_out/first.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "first: 3\n", string(data))
+	data, err = os.ReadFile("subworkflow_out/second.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "count: 2\n", string(data))
+	assert.NoDirExists(t, "count1")
+	assert.NoDirExists(t, "count2")
+}
+func TestJobEvents(t *testing.T) {
+	j, err := os.Open("../testdata/literal.json")
+	assert.NoError(t, err)
+	workflow, err := LoadWorkflow(j)
+	assert.NoError(t, err)
+	workflow.JobEvents = true
+	ch := make(chan Event)
+	events := []Event{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for event := range ch {
+			events = append(events, event)
+			if event.GetEventType() == WorkflowEvents {
+				return
+			}
+		}
+	}()
+	result := workflow.Execute(ch)
+	<-done
+	assert.Equal(t, Successed, result.Status)
+	statuses := map[string][]JobStatus{}
+	for _, event := range events[:len(events)-1] {
+		jobEvent := event.(*JobEvent)
+		statuses[jobEvent.JobId] = append(statuses[jobEvent.JobId], jobEvent.Status)
+	}
+	for _, jobId := range []string{"intervals", "sheet", "check"} {
+		assert.Equal(t, []JobStatus{Running, Successed}, statuses[jobId], jobId)
+	}
+	assert.Equal(t, Successed, events[len(events)-1].(*WorkflowEvent).Status)
+}
+func TestCancel(t *testing.T) {
+	workflow := CreateWorkflow(&WorkflowDto{Jobs: []*JobDto{
+		{JobId: "sleep", Command: []string{"sleep", "10"}, Retries: 1},
+		{JobId: "after", Command: []string{"true"}, DependsOn: []JobDependency{{JobId: "sleep"}}},
+	}})
+	go func() {
+		for workflow.GetResults()[0].Status != Running {
+			time.Sleep(10 * time.Millisecond)
+		}
+		workflow.Cancel()
+	}()
+	start := time.Now()
+	result := workflow.Execute(make(chan Event, 10))
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.Equal(t, Failed, result.Status)
+	assert.Equal(t, Aborted, result.Results[0].Status)
+	assert.Empty(t, result.Results[0].Attempts)
+	assert.Equal(t, Aborted, result.Results[1].Status)
+}